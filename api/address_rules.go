@@ -0,0 +1,198 @@
+// address_rules.go contains the dictionaries and casing rules used to
+// clean the addresses of each region, and functions to load them from a
+// config file.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// An AddressRules struct maps the abbreviation dictionaries and casing
+// rules used by CleanAddressName in a region.
+type AddressRules struct {
+	// StreetAbbreviations maps street word abbreviations (usually the
+	// suffix, "rd") to their uncondensed counterpart ("Road").
+	StreetAbbreviations map[string]string `json:"street_abbreviations"`
+	// SuburbAbbreviations maps suburb word abbreviations to their
+	// uncondensed counterpart.
+	SuburbAbbreviations map[string]string `json:"suburb_abbreviations"`
+	// StreetStartAbbreviations replace the first word of a street
+	// instead of StreetAbbreviations, so that "St Johns St" becomes
+	// "Saint Johns Street".
+	StreetStartAbbreviations map[string]string `json:"street_start_abbreviations"`
+	// CasingExceptions are words that keep the given casing, such as
+	// "MacKelvie" (but not "Macleans").
+	CasingExceptions []string `json:"casing_exceptions"`
+	// CasingPrefixes are prefixes followed by a capital letter, such as
+	// "Mc" in "McLeod" or "O'" in "O'Neills".
+	CasingPrefixes []string `json:"casing_prefixes"`
+	// LowercaseParticles stay lowercase after the first part of a
+	// hyphenated name, such as "o" in "Wai-o-Taiki".
+	LowercaseParticles []string `json:"lowercase_particles"`
+}
+
+// An AddressRulesConfig struct maps a config file of AddressRules: rules
+// added to every region, and rules added to single regions by name.
+type AddressRulesConfig struct {
+	AddressRules
+	Regions map[string]AddressRules `json:"regions"`
+}
+
+// DefaultAddressRules returns the built-in AddressRules shared by all
+// regions, without the casing exceptions of any region.
+func DefaultAddressRules() AddressRules {
+	return AddressRules{
+		StreetAbbreviations:      copyStringMap(street_abbreviations),
+		SuburbAbbreviations:      copyStringMap(suburb_abbreviations),
+		StreetStartAbbreviations: copyStringMap(street_start_abbreviations),
+		CasingExceptions:         []string{},
+		CasingPrefixes:           []string{"Mc", "O'"},
+		LowercaseParticles:       []string{"o", "a", "ki"},
+	}
+}
+
+// Merge returns a copy of the rules with other rules added. Dictionary
+// entries of other replace entries of the rules, and an empty value
+// removes the entry. Lists of other are added to the lists of the rules.
+func (rules AddressRules) Merge(other AddressRules) AddressRules {
+	merged := AddressRules{
+		StreetAbbreviations:      copyStringMap(rules.StreetAbbreviations),
+		SuburbAbbreviations:      copyStringMap(rules.SuburbAbbreviations),
+		StreetStartAbbreviations: copyStringMap(rules.StreetStartAbbreviations),
+		CasingExceptions:         concatStrings(rules.CasingExceptions, other.CasingExceptions),
+		CasingPrefixes:           concatStrings(rules.CasingPrefixes, other.CasingPrefixes),
+		LowercaseParticles:       concatStrings(rules.LowercaseParticles, other.LowercaseParticles),
+	}
+
+	mergeStringMap(merged.StreetAbbreviations, other.StreetAbbreviations)
+	mergeStringMap(merged.SuburbAbbreviations, other.SuburbAbbreviations)
+	mergeStringMap(merged.StreetStartAbbreviations, other.StreetStartAbbreviations)
+	return merged
+}
+
+// LoadAddressRules returns the AddressRules of each region by name: the
+// built-in rules of the region merged with the rules of all regions in a
+// JSON config file, then with the rules of the region under "regions".
+func LoadAddressRules(path string) (map[string]AddressRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config AddressRulesConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	for name := range config.Regions {
+		if _, ok := Regions[name]; !ok {
+			return nil, fmt.Errorf("unknown region %q", name)
+		}
+	}
+
+	rules := map[string]AddressRules{}
+	for name, region := range Regions {
+		rules[name] = region.AddressRules.Merge(config.AddressRules).
+			Merge(config.Regions[name])
+	}
+	return rules, nil
+}
+
+// SetAddressRules replaces the AddressRules of the regions by name. It
+// is intended to be called once on startup.
+func SetAddressRules(rules map[string]AddressRules) {
+	for name, regionRules := range rules {
+		if region, ok := Regions[name]; ok {
+			region.AddressRules = regionRules
+		}
+	}
+}
+
+// CurrentParserVersion returns the version of the address parser with the
+// AddressRules of the region. It changes when ParserVersion is increased
+// or when the rules of the region are edited, but not when the rules of
+// other regions are.
+func (region *Region) CurrentParserVersion() int {
+	// Maps are marshalled with sorted keys, so equal rules always give
+	// the same checksum
+	data, _ := json.Marshal(region.AddressRules)
+	return ParserVersion<<16 | int(crc32.ChecksumIEEE(data)&0xffff)
+}
+
+// Abbreviations returns the abbreviation dictionary of an address type
+// ("street", "street_start" or "suburb").
+func (rules AddressRules) Abbreviations(address_type string) map[string]string {
+	switch address_type {
+	case "street":
+		return rules.StreetAbbreviations
+	case "street_start":
+		return rules.StreetStartAbbreviations
+	default:
+		return rules.SuburbAbbreviations
+	}
+}
+
+// TitleCase returns a single word of an address in title-case, following
+// the casing exceptions, prefixes and particles of the rules.
+func (rules AddressRules) TitleCase(word string) string {
+	caser := cases.Title(language.English)
+	word = strings.ToLower(word)
+
+	for _, exception := range rules.CasingExceptions {
+		if strings.ToLower(exception) == word {
+			return exception
+		}
+	}
+
+	// Title-case each part of hyphenated names, but keep particles in
+	// lowercase
+	if parts := strings.Split(word, "-"); len(parts) > 1 {
+		for i, part := range parts {
+			if i == 0 || !isStringInArray(part, rules.LowercaseParticles) {
+				parts[i] = rules.TitleCase(part)
+			}
+		}
+		return strings.Join(parts, "-")
+	}
+
+	for _, prefix := range rules.CasingPrefixes {
+		if len(word) > len(prefix) && strings.HasPrefix(word, strings.ToLower(prefix)) {
+			return prefix + caser.String(word[len(prefix):])
+		}
+	}
+
+	return caser.String(word)
+}
+
+// copyStringMap returns a shallow copy of a string map.
+func copyStringMap(m map[string]string) map[string]string {
+	copied := make(map[string]string, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
+
+// concatStrings returns a new slice of the strings of a followed by the
+// strings of b.
+func concatStrings(a, b []string) []string {
+	return append(append([]string{}, a...), b...)
+}
+
+// mergeStringMap adds all entries of src to dst. Entries with an empty
+// value are removed from dst instead.
+func mergeStringMap(dst, src map[string]string) {
+	for key, value := range src {
+		if value == "" {
+			delete(dst, strings.ToLower(key))
+		} else {
+			dst[strings.ToLower(key)] = value
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// address_rules_test.go contains tests that test address_rules.go
+package api
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// update rewrites the golden files with the current output when set,
+// for example: go test ./api -run Golden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// TestAddressToStreetSuburbGolden calls api.AddressToStreetSuburb for a
+// corpus of real addresses and compares the output to a golden file.
+func TestAddressToStreetSuburbGolden(t *testing.T) {
+	corpus, err := ioutil.ReadFile(filepath.Join("testdata", "addresses.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output []string
+	for _, address := range strings.Split(strings.TrimSpace(string(corpus)), "\n") {
+		street, suburb := AddressToStreetSuburb(address)
+		output = append(output, fmt.Sprintf("%s\t%s\t%s", address, street, suburb))
+	}
+	actual := strings.Join(output, "\n") + "\n"
+
+	golden := filepath.Join("testdata", "addresses.golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare line by line so that failures point at the address
+	expectedLines := strings.Split(string(expected), "\n")
+	for i, line := range strings.Split(actual, "\n") {
+		var expectedLine string
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+
+		if line != expectedLine {
+			t.Fatalf(
+				`TestAddressToStreetSuburbGolden did not return
+				%q
+				got
+				%q`,
+				expectedLine, line,
+			)
+		}
+	}
+}
+
+// TestTitleCase calls api.AddressRules.TitleCase and checks that the
+// casing exceptions, prefixes and particles are followed.
+func TestTitleCase(t *testing.T) {
+	rules := GetRegion("auckland").AddressRules
+	tests := map[string]string{
+		"mcleod":      "McLeod",
+		"mackelvie":   "MacKelvie",
+		"macleans":    "Macleans",
+		"o'neills":    "O'Neills",
+		"wai-o-taiki": "Wai-o-Taiki",
+		"o-a":         "O-a",
+		"ŌRĀKEI":      "Ōrākei",
+		"street":      "Street",
+	}
+
+	for test, expected := range tests {
+		if actual := rules.TitleCase(test); actual != expected {
+			t.Fatalf(
+				`TestTitleCase did not return %s got %s`,
+				expected, actual,
+			)
+		}
+	}
+
+	// The casing exceptions of Auckland are not used by other regions
+	if actual := GetRegion("wellington").AddressRules.TitleCase("mackelvie"); actual != "Mackelvie" {
+		t.Fatalf(`TestTitleCase did not return Mackelvie got %s`, actual)
+	}
+}
+
+// TestLoadAddressRules calls api.LoadAddressRules and checks that the
+// config file is merged with the rules of each region, and that rules
+// under "regions" are only added to their region.
+func TestLoadAddressRules(t *testing.T) {
+	regions, err := LoadAddressRules(filepath.Join("testdata", "address_rules.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := regions["auckland"]
+
+	tests := map[string]string{
+		rules.StreetAbbreviations["wy"]:                    "Way",
+		rules.StreetAbbreviations["rd"]:                    "Road",
+		rules.SuburbAbbreviations["nth"]:                   "North",
+		rules.TitleCase("macandrew"):                       "MacAndrew",
+		rules.TitleCase("mackelvie"):                       "MacKelvie",
+		rules.StreetAbbreviations["hwy"]:                   "",
+		regions["wellington"].StreetAbbreviations["hwy"]:   "Highway",
+		regions["wellington"].StreetAbbreviations["wy"]:    "Way",
+		regions["christchurch"].StreetAbbreviations["hwy"]: "",
+	}
+
+	for actual, expected := range tests {
+		if actual != expected {
+			t.Fatalf(
+				`TestLoadAddressRules did not return %s got %s`,
+				expected, actual,
+			)
+		}
+	}
+
+	if _, ok := rules.StreetStartAbbreviations["gt"]; ok {
+		t.Fatalf(`TestLoadAddressRules did not remove the "gt" abbreviation`)
+	}
+}
+
+// TestCurrentParserVersion calls api.Region.CurrentParserVersion and
+// checks that editing the address rules of a region only changes the
+// version of that region.
+func TestCurrentParserVersion(t *testing.T) {
+	auckland, wellington := GetRegion("auckland"), GetRegion("wellington")
+	before := [2]int{auckland.CurrentParserVersion(), wellington.CurrentParserVersion()}
+
+	rules := wellington.AddressRules
+	defer func() { wellington.AddressRules = rules }()
+	SetAddressRules(map[string]AddressRules{
+		"wellington": rules.Merge(AddressRules{CasingExceptions: []string{"MacAlister"}}),
+	})
+
+	if auckland.CurrentParserVersion() != before[0] ||
+		wellington.CurrentParserVersion() == before[1] ||
+		wellington.CurrentParserVersion()>>16 != ParserVersion {
+		t.Fatalf(`TestCurrentParserVersion did not change only the wellington version got %v, %v`,
+			auckland.CurrentParserVersion(), wellington.CurrentParserVersion())
+	}
+}
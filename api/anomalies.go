@@ -0,0 +1,317 @@
+// anomalies.go contains the job that raises alerts when the recent
+// outages of a suburb spike above its baseline, and the controller
+// function of the alerts.
+package api
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// AnomalySettings maps the settings of the anomaly detector. The outages
+// of each suburb in the latest window of Days days are compared with the
+// outages of the Baseline windows before it.
+type AnomalySettings struct {
+	Days       int
+	Baseline   int
+	Threshold  float64
+	MinOutages int
+}
+
+// GetAnomalySettings returns the settings of the anomaly detector, read
+// from the ANOMALY_WINDOW, ANOMALY_BASELINE, ANOMALY_THRESHOLD and
+// ANOMALY_MIN_OUTAGES environmental variables. They default to comparing
+// the last 7 days with the 12 windows before, and alerting at a z-score
+// of 3 with at least 3 outages.
+func GetAnomalySettings() AnomalySettings {
+	settings := AnomalySettings{Days: 7, Baseline: 12, Threshold: 3, MinOutages: 3}
+
+	if value, err := ParseWindow(os.Getenv("ANOMALY_WINDOW")); err == nil && value >= 24*time.Hour {
+		settings.Days = int(value / (24 * time.Hour))
+	}
+	if value, err := strconv.Atoi(os.Getenv("ANOMALY_BASELINE")); err == nil && value > 1 {
+		settings.Baseline = value
+	}
+	if value, err := strconv.ParseFloat(os.Getenv("ANOMALY_THRESHOLD"), 64); err == nil && value > 0 {
+		settings.Threshold = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("ANOMALY_MIN_OUTAGES")); err == nil && value > 0 {
+		settings.MinOutages = value
+	}
+	return settings
+}
+
+// An Alert struct maps a suburb whose outages in a window spiked above
+// its baseline.
+type Alert struct {
+	ID             int     `json:"id,omitempty"`
+	Region         string  `json:"region"`
+	Suburb         string  `json:"suburb"`
+	WindowStart    string  `json:"window_start"`
+	WindowEnd      string  `json:"window_end"`
+	Outages        int     `json:"outages"`
+	BaselineMean   float64 `json:"baseline_mean"`
+	BaselineStddev float64 `json:"baseline_stddev"`
+	ZScore         float64 `json:"z_score"`
+	CreatedAt      string  `json:"created_at,omitempty"`
+}
+
+// ZScore returns the z-score of a count against the counts of a
+// baseline. The standard deviation is at least the square root of the
+// mean (or 1), the standard deviation of a Poisson distribution, so
+// suburbs with few or steady outages do not alert on a single outage.
+func ZScore(count int, baseline []int) (z, mean, stddev float64) {
+	for _, value := range baseline {
+		mean += float64(value)
+	}
+	mean /= float64(len(baseline))
+
+	for _, value := range baseline {
+		stddev += math.Pow(float64(value)-mean, 2)
+	}
+	if len(baseline) > 1 {
+		stddev = math.Sqrt(stddev / float64(len(baseline)-1))
+	}
+
+	floor := math.Sqrt(math.Max(mean, 1))
+	return (float64(count) - mean) / math.Max(stddev, floor), mean, stddev
+}
+
+// FindAnomalies returns an alert for each suburb whose count in the
+// latest window (index 0 of its counts) reaches the minimum number of
+// outages and the z-score threshold against the windows before it
+// (index 1 onwards). The alerts are sorted by z-score, highest first.
+func FindAnomalies(counts map[string][]int, settings AnomalySettings) []Alert {
+	alerts := []Alert{}
+
+	for suburb, windows := range counts {
+		if len(windows) < 2 || windows[0] < settings.MinOutages {
+			continue
+		}
+
+		z, mean, stddev := ZScore(windows[0], windows[1:])
+		if z >= settings.Threshold {
+			alerts = append(alerts, Alert{
+				Suburb:         suburb,
+				Outages:        windows[0],
+				BaselineMean:   mean,
+				BaselineStddev: stddev,
+				ZScore:         z,
+			})
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].ZScore != alerts[j].ZScore {
+			return alerts[i].ZScore > alerts[j].ZScore
+		}
+		return alerts[i].Suburb < alerts[j].Suburb
+	})
+	return alerts
+}
+
+// CountWindows returns the number of windows before windowEnd to count,
+// the latest window and the baseline windows that start at or after the
+// earliest outage of a region. Older windows would count as having no
+// outages only because they were not recorded.
+func CountWindows(windowEnd, earliest time.Time, settings AnomalySettings) int {
+	for window := 1; window <= settings.Baseline; window++ {
+		if windowEnd.AddDate(0, 0, -(window+1)*settings.Days).Before(earliest) {
+			return window
+		}
+	}
+	return settings.Baseline + 1
+}
+
+// getWindowCounts returns the number of outages of each suburb of a
+// region in each window before windowEnd, with the latest window first.
+// Baseline windows older than the earliest outage of the region are left
+// out.
+func getWindowCounts(db *sql.DB, region string, windowEnd time.Time,
+	settings AnomalySettings) (map[string][]int, error) {
+	counts := make(map[string][]int)
+
+	var earliest sql.NullTime
+	err := db.QueryRow(
+		`SELECT MIN(start_date) FROM outage WHERE region = $1`, region,
+	).Scan(&earliest)
+	if err != nil || !earliest.Valid {
+		return counts, err
+	}
+	windows := CountWindows(windowEnd, earliest.Time, settings)
+
+	rows, err := db.Query(
+		`SELECT suburb, floor(EXTRACT(EPOCH FROM $2::timestamp - start_date)
+		/ ($3 * 86400))::int AS age, count(*) FROM outage
+		WHERE region = $1 AND suburb IS NOT NULL AND suburb <> ''
+		AND start_date < $2::timestamp
+		AND start_date >= $2::timestamp - $3 * $4 * INTERVAL '1 day'
+		GROUP BY 1, 2`,
+		region, windowEnd.Format("2006-01-02T15:04:05"), settings.Days, windows,
+	)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var suburb string
+		var age, count int
+		if err := rows.Scan(&suburb, &age, &count); err != nil {
+			return counts, err
+		}
+		if age < 0 || age >= windows {
+			continue
+		}
+		if counts[suburb] == nil {
+			counts[suburb] = make([]int, windows)
+		}
+		counts[suburb][age] = count
+	}
+	return counts, rows.Err()
+}
+
+// DetectAnomalies compares the outages of each suburb of the enabled
+// regions in the latest window, which ends at the end of today, with its
+// baseline. Alerts are saved to the alerts table, and updated while the
+// window is the same.
+func DetectAnomalies() {
+	settings := GetAnomalySettings()
+
+	// Open database
+	db := database.DB()
+
+	raised := 0
+	for _, region := range EnabledRegions() {
+		now := time.Now().In(region.Location())
+		year, month, day := now.Date()
+		windowEnd := time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		windowStart := windowEnd.AddDate(0, 0, -settings.Days)
+
+		counts, err := getWindowCounts(db, region.Name, windowEnd, settings)
+		if err != nil {
+			log.Printf("Detecting anomalies of %s failed: %v", region.Name, err)
+			continue
+		}
+
+		for _, alert := range FindAnomalies(counts, settings) {
+			_, err := db.Exec(
+				`INSERT INTO alerts (region, suburb, window_start, window_end,
+				outages, baseline_mean, baseline_stddev, z_score)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				ON CONFLICT (region, suburb, window_end) DO UPDATE SET
+				outages = excluded.outages, baseline_mean = excluded.baseline_mean,
+				baseline_stddev = excluded.baseline_stddev, z_score = excluded.z_score,
+				updated_at = CURRENT_TIMESTAMP`,
+				region.Name, alert.Suburb, windowStart.Format("2006-01-02T15:04:05"),
+				windowEnd.Format("2006-01-02T15:04:05"), alert.Outages,
+				alert.BaselineMean, alert.BaselineStddev, alert.ZScore,
+			)
+			if err != nil {
+				log.Printf("Saving an alert of %s failed: %v", alert.Suburb, err)
+				continue
+			}
+			raised++
+		}
+	}
+
+	log.Printf("Anomalies have been detected (%d alerts).", raised)
+}
+
+// GetAlerts JSON-encodes the latest alerts, optionally filtered by the
+// region, suburb and since (date of the end of the window) parameters.
+func GetAlerts(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetAlerts request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := r.URL.Query()
+	var conditions []string
+	var args []interface{}
+
+	if region := params.Get("region"); region != "" {
+		args = append(args, strings.ToLower(region))
+		conditions = append(conditions, "region = $"+strconv.Itoa(len(args)))
+	}
+	if suburb := params.Get("suburb"); suburb != "" {
+		args = append(args, suburb)
+		conditions = append(conditions, "lower(suburb) = lower($"+strconv.Itoa(len(args))+")")
+	}
+	if since := params.Get("since"); since != "" {
+		date, ok := parseDateParam(since, time.UTC)
+		if !ok {
+			WriteAppError(w, http.StatusBadRequest, AppError{
+				ErrorCode: 3440,
+				Message:   "invalid parameters",
+				Details:   "Parameters given for this API were invalid: since.",
+			})
+			return
+		}
+		args = append(args, date.Format("2006-01-02T15:04:05"))
+		conditions = append(conditions, "window_end > $"+strconv.Itoa(len(args)))
+	}
+
+	limit := 50
+	if value, err := strconv.Atoi(params.Get("limit")); err == nil && value > 0 && value <= 500 {
+		limit = value
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	rows, err := db.Query(
+		`SELECT id, region, suburb, window_start, window_end, outages,
+		baseline_mean, baseline_stddev, z_score, created_at FROM alerts`+where+
+			` ORDER BY window_end DESC, z_score DESC LIMIT `+strconv.Itoa(limit),
+		args...,
+	)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3490,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var alert Alert
+		if err := rows.Scan(&alert.ID, &alert.Region, &alert.Suburb,
+			&alert.WindowStart, &alert.WindowEnd, &alert.Outages,
+			&alert.BaselineMean, &alert.BaselineStddev, &alert.ZScore,
+			&alert.CreatedAt); err != nil {
+			log.Println(err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3491,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+
+		region := GetRegion(alert.Region)
+		alert.WindowStart = region.FormatOutageTime(alert.WindowStart)
+		alert.WindowEnd = region.FormatOutageTime(alert.WindowEnd)
+		alerts = append(alerts, alert)
+	}
+
+	WriteJSON(w, alerts)
+}
@@ -0,0 +1,80 @@
+// anomalies_test.go contains tests that test anomalies.go
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestZScore calls api.ZScore and checks that the standard deviation of a
+// steady baseline is floored by the square root of its mean.
+func TestZScore(t *testing.T) {
+	z, mean, stddev := ZScore(10, []int{4, 4, 4, 4})
+	if mean != 4 || stddev != 0 || z != 3 {
+		t.Fatalf(`TestZScore did not return 3, 4, 0 got %v, %v, %v`, z, mean, stddev)
+	}
+
+	z, mean, stddev = ZScore(9, []int{1, 3, 5, 7})
+	expected := (9 - 4) / math.Sqrt(20.0/3)
+	if mean != 4 || math.Abs(z-expected) > 1e-9 {
+		t.Fatalf(`TestZScore did not return %v, 4 got %v, %v`, expected, z, mean)
+	}
+}
+
+// TestFindAnomalies calls api.FindAnomalies and checks that only suburbs
+// with enough outages spiking above their baseline are alerted, highest
+// z-score first.
+func TestFindAnomalies(t *testing.T) {
+	settings := AnomalySettings{Days: 7, Baseline: 4, Threshold: 3, MinOutages: 3}
+	counts := map[string][]int{
+		"Remuera":     {9, 1, 0, 1, 0},
+		"Ponsonby":    {6, 1, 1, 1, 1},
+		"Mt Eden":     {2, 0, 0, 0, 0},
+		"Grey Lynn":   {5, 4, 6, 5, 4},
+		"Parnell":     {4},
+		"Onehunga":    {0, 0, 0, 0, 0},
+		"Epsom":       {3, 0, 0, 0, 0},
+		"Glen Innes":  {12, 2, 3, 2, 3},
+		"Mission Bay": {7, 5, 8, 6, 7},
+	}
+
+	actual := FindAnomalies(counts, settings)
+	expected := []string{"Remuera", "Glen Innes", "Ponsonby", "Epsom"}
+	if len(actual) != len(expected) {
+		t.Fatalf(`TestFindAnomalies did not return %v got %+v`, expected, actual)
+	}
+	for i, suburb := range expected {
+		if actual[i].Suburb != suburb {
+			t.Fatalf(`TestFindAnomalies did not return %v got %+v`, expected, actual)
+		}
+	}
+
+	if actual[0].Outages != 9 || actual[0].BaselineMean != 0.5 {
+		t.Fatalf(`TestFindAnomalies did not return 9 outages & 0.5 mean got %+v`, actual[0])
+	}
+}
+
+// TestCountWindows calls api.CountWindows and checks that baseline windows
+// starting before the earliest outage are left out.
+func TestCountWindows(t *testing.T) {
+	settings := AnomalySettings{Days: 7, Baseline: 4}
+	windowEnd := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		inputs   time.Time
+		expected int
+	}{
+		{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), 5},
+		{windowEnd.AddDate(0, 0, -35), 5},
+		{windowEnd.AddDate(0, 0, -30), 4},
+		{windowEnd.AddDate(0, 0, -14), 2},
+		{windowEnd.AddDate(0, 0, -3), 1},
+	}
+
+	for _, test := range tests {
+		if actual := CountWindows(windowEnd, test.inputs, settings); actual != test.expected {
+			t.Fatalf(`TestCountWindows did not return %v got %v`, test.expected, actual)
+		}
+	}
+}
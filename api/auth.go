@@ -0,0 +1,485 @@
+// auth.go contains the optional API keys of this app, the middleware
+// that checks their scopes and rate limits each API key and IP address,
+// and the controller functions of API keys.
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// ScopePublic allows reading outages a page at a time.
+	ScopePublic = "public"
+	// ScopeExport allows reading more than MaxPublicLimit outages at once.
+	ScopeExport = "export"
+	// ScopeAdmin allows managing API keys.
+	ScopeAdmin = "admin"
+)
+
+// Scopes are the scopes an API key can be given.
+var Scopes = []string{ScopePublic, ScopeExport, ScopeAdmin}
+
+const (
+	// MaxPublicLimit is the largest page of the main API without the
+	// export scope.
+	MaxPublicLimit = 500
+	// DefaultIPRateLimit is the number of requests per minute of each IP
+	// address without an API key.
+	DefaultIPRateLimit = 60
+	// DefaultKeyRateLimit is the number of requests per minute of each
+	// API key without its own limit.
+	DefaultKeyRateLimit = 600
+	// apiKeyPrefix starts each API key, so that they are easy to spot.
+	apiKeyPrefix = "wda_"
+)
+
+// An APIKey struct maps a key that is sent with requests to get its
+// scopes and rate limit (requests per minute, 0 is the default). The key
+// itself is only stored as a hash, and is only shown when it is created.
+type APIKey struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Key       string   `json:"key,omitempty"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rate_limit"`
+	CreatedAt string   `json:"created_at,omitempty"`
+}
+
+// An APIKeyStore finds the API keys that have not been revoked by the
+// hash of the key.
+type APIKeyStore interface {
+	FindAPIKey(hash string) (APIKey, bool, error)
+}
+
+// An Authenticator struct maps the settings of the authentication
+// middleware. Requests without an API key have the anonymous scopes, and
+// the admin key (if any) has all scopes without being stored.
+type Authenticator struct {
+	Store           APIKeyStore
+	Limiter         *RateLimiter
+	AnonymousScopes []string
+	AdminKeyHash    string
+	IPRateLimit     int
+	KeyRateLimit    int
+	TrustedProxies  int
+}
+
+// NewAuthenticator returns the Authenticator set up by the
+// environmental variables ADMIN_API_KEY, AUTH_ANONYMOUS_SCOPES (defaults
+// to public, or none to require an API key), RATE_LIMIT_IP, RATE_LIMIT_KEY
+// and TRUST_PROXY (the number of proxies in front of this app, or true
+// for one).
+func NewAuthenticator() *Authenticator {
+	auth := &Authenticator{
+		Store:           dbAPIKeyStore{},
+		Limiter:         NewRateLimiter(),
+		AnonymousScopes: []string{ScopePublic},
+		IPRateLimit:     DefaultIPRateLimit,
+		KeyRateLimit:    DefaultKeyRateLimit,
+	}
+
+	if key := os.Getenv("ADMIN_API_KEY"); key != "" {
+		auth.AdminKeyHash = HashAPIKey(key)
+	}
+	if value := os.Getenv("AUTH_ANONYMOUS_SCOPES"); value == "none" {
+		auth.AnonymousScopes = []string{}
+	} else if value != "" {
+		auth.AnonymousScopes, _ = ParseScopes(value)
+	}
+	if value, err := strconv.Atoi(os.Getenv("RATE_LIMIT_IP")); err == nil {
+		auth.IPRateLimit = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("RATE_LIMIT_KEY")); err == nil {
+		auth.KeyRateLimit = value
+	}
+	if value := os.Getenv("TRUST_PROXY"); value == "true" {
+		auth.TrustedProxies = 1
+	} else if number, err := strconv.Atoi(value); err == nil && number > 0 {
+		auth.TrustedProxies = number
+	}
+	return auth
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of an API key. Keys
+// are random, so a fast hash is enough.
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ParseScopes returns the scopes of a comma-separated list, and the
+// names that are not scopes.
+func ParseScopes(value string) (scopes, invalid []string) {
+	scopes = []string{}
+	for _, scope := range strings.Split(value, ",") {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || isStringInArray(scope, scopes) {
+			continue
+		}
+		if !isStringInArray(scope, Scopes) {
+			invalid = append(invalid, scope)
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+	return
+}
+
+// GetRequestAPIKey returns the API key of a request, sent in the
+// X-API-Key header, as an Authorization bearer token or in the api_key
+// parameter.
+func GetRequestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// ClientIP returns the IP address of a request. Behind trustedProxies
+// proxies, it is read from the X-Forwarded-For address added by the
+// furthest trusted proxy, counted from the right, as clients can send any
+// addresses on the left.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	var forwarded []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		for _, address := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(address))
+		}
+	}
+	if trustedProxies > 0 && len(forwarded) > 0 {
+		// Fewer addresses than proxies are all added by trusted proxies
+		index := len(forwarded) - trustedProxies
+		if index < 0 {
+			index = 0
+		}
+		if ip := net.ParseIP(forwarded[index]); ip != nil {
+			return ip.String()
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// RequiredScope returns the scope needed by a request: admin for API
+// keys and webhook subscriptions, export for pages of the main API larger
+// than MaxPublicLimit, or else public.
+func RequiredScope(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/keys") ||
+		strings.HasPrefix(r.URL.Path, "/subscriptions") {
+		return ScopeAdmin
+	}
+
+	if r.URL.Path == "/" {
+		limit := peekRequestParams(r).Get("limit")
+		if number, err := strconv.Atoi(limit); err == nil && number > MaxPublicLimit {
+			return ScopeExport
+		}
+	}
+	return ScopePublic
+}
+
+// peekRequestParams returns the parameters of a request like
+// GetRequestParams, leaving the body to be read again.
+func peekRequestParams(r *http.Request) url.Values {
+	if r.Method != http.MethodPost || r.Body == nil {
+		return r.URL.Query()
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		log.Println(err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	params := GetRequestParams(r)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return params
+}
+
+// Middleware checks the API key & scope of each request, then takes the
+// request from the rate limit of its API key, or of its IP address if it
+// has no valid API key. API keys are only looked up while the IP address
+// has requests left, so keys can't be guessed faster than requests
+// without a key are allowed. Preflight requests and health checks are
+// always let through.
+func (auth *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || isStringInArray(r.URL.Path, HealthPaths) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers",
+			"Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset")
+
+		client := "ip:" + ClientIP(r, auth.TrustedProxies)
+		limit := auth.IPRateLimit
+		scopes := auth.AnonymousScopes
+		now := time.Now()
+
+		// Check the IP address before looking up a key, so that unknown
+		// keys don't reach the database once the IP address is limited
+		sentKey := GetRequestAPIKey(r)
+		if sentKey != "" {
+			if rateLimit := auth.Limiter.Peek(client, limit, now); !rateLimit.Allowed {
+				writeRateLimitExceeded(w, rateLimit)
+				return
+			}
+		}
+
+		key, found, err := auth.findAPIKey(sentKey)
+		if found {
+			client, limit, scopes = fmt.Sprintf("key:%d", key.ID), key.RateLimit, key.Scopes
+			if limit == 0 {
+				limit = auth.KeyRateLimit
+			}
+		}
+
+		// Rate limit before answering, so that keys can't be guessed
+		rateLimit := auth.Limiter.Allow(client, limit, now)
+		if !rateLimit.Allowed {
+			writeRateLimitExceeded(w, rateLimit)
+			return
+		}
+		WriteRateLimitHeaders(w, rateLimit)
+
+		if err != nil {
+			log.Println("Finding API key failed:", err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3520,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+		if sentKey != "" && !found {
+			WriteAppError(w, http.StatusUnauthorized, AppError{
+				ErrorCode: 3521,
+				Message:   "invalid API key",
+				Details:   "The API key given is unknown or has been revoked.",
+			})
+			return
+		}
+
+		if scope := RequiredScope(r); !isStringInArray(scope, scopes) {
+			status := http.StatusForbidden
+			if !found {
+				status = http.StatusUnauthorized
+			}
+			WriteAppError(w, status, AppError{
+				ErrorCode: 3522,
+				Message:   "missing scope",
+				Details:   "This request needs an API key with the " + scope + " scope.",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// findAPIKey returns the API key of a key sent with a request, if it
+// is the admin key or a stored key that has not been revoked.
+func (auth *Authenticator) findAPIKey(key string) (APIKey, bool, error) {
+	if key == "" {
+		return APIKey{}, false, nil
+	}
+
+	hash := HashAPIKey(key)
+	if auth.AdminKeyHash != "" &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(auth.AdminKeyHash)) == 1 {
+		return APIKey{Name: "admin", Scopes: Scopes}, true, nil
+	}
+	return auth.Store.FindAPIKey(hash)
+}
+
+// writeRateLimitExceeded writes the 429 error of a request over its rate
+// limit.
+func writeRateLimitExceeded(w http.ResponseWriter, rateLimit RateLimit) {
+	WriteRateLimitHeaders(w, rateLimit)
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(rateLimit.RetryAfter)))
+	WriteAppError(w, http.StatusTooManyRequests, AppError{
+		ErrorCode: 3523,
+		Message:   "rate limit exceeded",
+		Details: fmt.Sprintf("Only %d requests per minute are allowed. "+
+			"Please try again later.", rateLimit.Limit),
+	})
+}
+
+// WriteRateLimitHeaders sets the X-RateLimit-Limit, X-RateLimit-Remaining
+// and X-RateLimit-Reset (seconds until the limit is full again) headers
+// of a limited request.
+func WriteRateLimitHeaders(w http.ResponseWriter, rateLimit RateLimit) {
+	if rateLimit.Limit <= 0 {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rateLimit.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(rateLimit.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(rateLimit.Reset)))
+}
+
+// ceilSeconds returns a duration in whole seconds, rounded up.
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// dbAPIKeyStore finds API keys in the api_key table.
+type dbAPIKeyStore struct{}
+
+func (dbAPIKeyStore) FindAPIKey(hash string) (APIKey, bool, error) {
+	db := database.DB()
+
+	var key APIKey
+	err := db.QueryRow(
+		`SELECT id, name, scopes, rate_limit FROM api_key
+		WHERE key_hash = $1 AND revoked_at IS NULL`, hash,
+	).Scan(&key.ID, &key.Name, pq.Array(&key.Scopes), &key.RateLimit)
+	if err == sql.ErrNoRows {
+		return key, false, nil
+	}
+	return key, err == nil, err
+}
+
+// CreateAPIKey creates an API key with the name, scopes (comma-separated,
+// defaults to public) and rate_limit (requests per minute, defaults to
+// RATE_LIMIT_KEY) parameters. The key is only shown in this response.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received CreateAPIKey request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	params := GetRequestParams(r)
+	key := APIKey{Name: strings.TrimSpace(params.Get("name"))}
+
+	var invalid []string
+	if key.Name == "" || len(key.Name) > 100 {
+		invalid = append(invalid, "name")
+	}
+	scopes := strings.Join(params["scopes"], ",")
+	if scopes == "" {
+		scopes = ScopePublic
+	}
+	var invalidScopes []string
+	key.Scopes, invalidScopes = ParseScopes(scopes)
+	if len(invalidScopes) > 0 || len(key.Scopes) == 0 {
+		invalid = append(invalid, "scopes")
+	}
+	if value := params.Get("rate_limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			invalid = append(invalid, "rate_limit")
+		}
+		key.RateLimit = limit
+	}
+	if len(invalid) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(invalid, ", ") + ".",
+		})
+		return
+	}
+
+	token, err := RandomToken(24)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3524,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	key.Key = apiKeyPrefix + token
+
+	// Setup the database
+	db := database.DB()
+
+	err = db.QueryRow(
+		`INSERT INTO api_key (name, key_hash, scopes, rate_limit)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at::TEXT`,
+		key.Name, HashAPIKey(key.Key), pq.Array(key.Scopes), key.RateLimit,
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3524,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// RevokeAPIKey revokes the API key of the id in the url, so that it can
+// no longer be used.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received RevokeAPIKey request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Setup the database
+	db := database.DB()
+
+	result, err := db.Exec(
+		`UPDATE api_key SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND revoked_at IS NULL`, mux.Vars(r)["id"],
+	)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3524,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	if revoked, _ := result.RowsAffected(); revoked == 0 {
+		WriteAppError(w, http.StatusNotFound, AppError{
+			ErrorCode: 3525,
+			Message:   "API key not found",
+			Details:   "There is no API key with this id, or it was already revoked.",
+		})
+		return
+	}
+
+	WriteJSON(w, map[string]string{"Message": "The API key has been revoked."})
+}
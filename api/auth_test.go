@@ -0,0 +1,224 @@
+// auth_test.go contains tests that test auth.go
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// memoryAPIKeyStore keeps API keys in memory by their hash.
+type memoryAPIKeyStore map[string]APIKey
+
+func (store memoryAPIKeyStore) FindAPIKey(hash string) (APIKey, bool, error) {
+	key, ok := store[hash]
+	return key, ok, nil
+}
+
+// TestHashAPIKey calls api.HashAPIKey and checks that keys are stored
+// as their SHA-256 hash.
+func TestHashAPIKey(t *testing.T) {
+	actual := HashAPIKey("abc")
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+
+	if actual != expected {
+		t.Fatalf(`TestHashAPIKey did not return %v got %v`, expected, actual)
+	}
+}
+
+// TestParseScopes calls api.ParseScopes and checks that scopes are
+// deduplicated and unknown scopes are found.
+func TestParseScopes(t *testing.T) {
+	scopes, invalid := ParseScopes(" Public,export,public,,write")
+
+	if strings.Join(scopes, ",") != "public,export" || strings.Join(invalid, ",") != "write" {
+		t.Fatalf(`TestParseScopes did not return public,export & write got %v & %v`,
+			scopes, invalid)
+	}
+}
+
+// TestRequiredScope calls api.RequiredScope and checks the scope needed
+// by API keys, subscriptions, large pages of outages and other requests.
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method, target, body string
+		expected             string
+	}{
+		{"GET", "/?suburb=Remuera", "", ScopePublic},
+		{"GET", "/?sort=outage_id&limit=500", "", ScopePublic},
+		{"GET", "/?sort=outage_id&limit=501", "", ScopeExport},
+		{"GET", "/?sort=outage_id&limit=ALL", "", ScopePublic},
+		{"POST", "/", `{"sort": "outage_id", "limit": 10000}`, ScopeExport},
+		{"GET", "/count?limit=10000", "", ScopePublic},
+		{"POST", "/keys", "", ScopeAdmin},
+		{"POST", "/subscriptions", `{"callback_url": "https://example.com"}`, ScopeAdmin},
+		{"DELETE", "/subscriptions/1", "", ScopeAdmin},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+		if actual := RequiredScope(r); actual != test.expected {
+			t.Fatalf(`TestRequiredScope did not return %v for %v %v got %v`,
+				test.expected, test.method, test.target, actual)
+		}
+	}
+
+	// The body can still be read as parameters
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"limit": 10}`))
+	RequiredScope(r)
+	if params := GetRequestParams(r); params.Get("limit") != "10" {
+		t.Fatalf(`TestRequiredScope did not leave the body got %v`, params)
+	}
+}
+
+// TestClientIP calls api.ClientIP and checks that X-Forwarded-For is read
+// from the right by the number of trusted proxies.
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		forwarded []string
+		proxies   int
+		expected  string
+	}{
+		{nil, 1, "192.0.2.1"},
+		{[]string{"203.0.113.7"}, 0, "192.0.2.1"},
+		{[]string{"203.0.113.7"}, 1, "203.0.113.7"},
+		{[]string{"10.0.0.1, 203.0.113.7"}, 1, "203.0.113.7"},
+		{[]string{"10.0.0.1", "203.0.113.7, 198.51.100.2"}, 2, "203.0.113.7"},
+		{[]string{"203.0.113.7"}, 3, "203.0.113.7"},
+		{[]string{"not an ip"}, 1, "192.0.2.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:4321"
+		r.Header["X-Forwarded-For"] = test.forwarded
+		if actual := ClientIP(r, test.proxies); actual != test.expected {
+			t.Fatalf(`TestClientIP did not return %v for %v got %v`,
+				test.expected, test.forwarded, actual)
+		}
+	}
+}
+
+// TestAuthenticatorMiddleware calls api.Authenticator.Middleware and
+// checks the responses of requests with and without valid API keys.
+func TestAuthenticatorMiddleware(t *testing.T) {
+	auth := &Authenticator{
+		Store: memoryAPIKeyStore{
+			HashAPIKey("wda_export"): {ID: 1, Name: "export", Scopes: []string{"public", "export"}},
+		},
+		Limiter:         NewRateLimiter(),
+		AnonymousScopes: []string{ScopePublic},
+		AdminKeyHash:    HashAPIKey("wda_admin"),
+		IPRateLimit:     60,
+		KeyRateLimit:    600,
+	}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		target, key string
+		expected    int
+	}{
+		{"/?suburb=Remuera", "", http.StatusOK},
+		{"/?sort=outage_id&limit=1000", "", http.StatusUnauthorized},
+		{"/?sort=outage_id&limit=1000", "wda_export", http.StatusOK},
+		{"/?sort=outage_id&limit=1000&api_key=wda_export", "", http.StatusOK},
+		{"/?suburb=Remuera", "wda_unknown", http.StatusUnauthorized},
+		{"/keys", "wda_export", http.StatusForbidden},
+		{"/keys", "wda_admin", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.target, nil)
+		if test.key != "" {
+			r.Header.Set("Authorization", "Bearer "+test.key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.expected {
+			t.Fatalf(`TestAuthenticatorMiddleware did not return %v for %v (%v) got %v`,
+				test.expected, test.target, test.key, w.Code)
+		}
+	}
+}
+
+// TestAuthenticatorMiddlewareRateLimit calls api.Authenticator.Middleware
+// and checks that requests over the limit of an IP address get a 429
+// with the Retry-After and X-RateLimit-* headers.
+func TestAuthenticatorMiddlewareRateLimit(t *testing.T) {
+	auth := &Authenticator{
+		Store:           memoryAPIKeyStore{},
+		Limiter:         NewRateLimiter(),
+		AnonymousScopes: []string{ScopePublic},
+		IPRateLimit:     2,
+	}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/count?get=suburb", nil)
+		r.RemoteAddr = "203.0.113.7:4321"
+		handler.ServeHTTP(w, r)
+	}
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" ||
+		w.Header().Get("X-RateLimit-Limit") != "2" ||
+		w.Header().Get("X-RateLimit-Remaining") != "0" ||
+		w.Header().Get("X-RateLimit-Reset") != "60" {
+		t.Fatalf(`TestAuthenticatorMiddlewareRateLimit did not return 429 got %v %v`,
+			w.Code, w.Header())
+	}
+
+	// Requests of other IP addresses are not limited
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/count?get=suburb", nil)
+	r.RemoteAddr = "203.0.113.8:4321"
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf(`TestAuthenticatorMiddlewareRateLimit did not allow another IP got %v`, w.Code)
+	}
+}
+
+// countingAPIKeyStore counts the API keys it is asked to find.
+type countingAPIKeyStore struct {
+	lookups int
+}
+
+func (store *countingAPIKeyStore) FindAPIKey(hash string) (APIKey, bool, error) {
+	store.lookups++
+	return APIKey{}, false, nil
+}
+
+// TestAuthenticatorMiddlewareKeyLookups calls
+// api.Authenticator.Middleware and checks that unknown API keys are taken
+// from the limit of the IP address, and aren't looked up once it is
+// reached.
+func TestAuthenticatorMiddlewareKeyLookups(t *testing.T) {
+	store := &countingAPIKeyStore{}
+	auth := &Authenticator{
+		Store:           store,
+		Limiter:         NewRateLimiter(),
+		AnonymousScopes: []string{ScopePublic},
+		IPRateLimit:     2,
+	}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 5; i++ {
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/count?get=suburb", nil)
+		r.RemoteAddr = "203.0.113.7:4321"
+		r.Header.Set("Authorization", "Bearer wda_guess")
+		handler.ServeHTTP(w, r)
+	}
+
+	if w.Code != http.StatusTooManyRequests || store.lookups != 2 {
+		t.Fatalf(`TestAuthenticatorMiddlewareKeyLookups did not return 429 after 2 lookups got %v after %v`,
+			w.Code, store.lookups)
+	}
+}
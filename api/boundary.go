@@ -0,0 +1,90 @@
+// boundary.go contains functions that import the boundary polygons of
+// areas such as local boards and wards, used to filter outages by area.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// ImportBoundaries upserts the boundaries of a kind (such as local_board
+// or ward) from a GeoJSON FeatureCollection file in WGS 84 (EPSG:4326).
+// The name of each boundary is read from the nameProperty of the feature.
+func ImportBoundaries(path, kind, nameProperty, region string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var collection GeoJSON
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return err
+	}
+
+	// Open database
+	db := database.DB()
+
+	for _, feature := range collection.Features {
+		var properties map[string]interface{}
+		json.Unmarshal(feature.Properties, &properties)
+
+		name := strings.TrimSpace(fmt.Sprint(properties[nameProperty]))
+		if properties[nameProperty] == nil || name == "" {
+			return fmt.Errorf("%s boundary has no %q property", kind, nameProperty)
+		}
+
+		featureJSON, _ := json.Marshal(feature)
+		polygon, err := ParseGeoJSONPolygon(featureJSON)
+		if err != nil {
+			return fmt.Errorf("%s boundary %s: %v", kind, name, err)
+		}
+
+		_, err = db.Exec(
+			`INSERT INTO boundary (kind, name, region, geom) 
+			VALUES ($1, $2, $3, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($4), 4326)))
+			ON CONFLICT (region, kind, name) DO UPDATE SET 
+			geom = excluded.geom`,
+			kind, name, region, polygon.JSON(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Imported %d %s boundaries.", len(collection.Features), kind)
+	return nil
+}
+
+// ImportConfiguredBoundaries imports the boundaries of each kind from the
+// file in the BOUNDARIES_<KIND> environmental variable, if set. The name
+// property defaults to "name" and can be changed with the
+// BOUNDARIES_<KIND>_NAME environmental variable.
+func ImportConfiguredBoundaries() {
+	for _, kind := range BoundaryKinds {
+		env := "BOUNDARIES_" + strings.ToUpper(kind)
+		path := os.Getenv(env)
+		if path == "" {
+			continue
+		}
+
+		nameProperty := os.Getenv(env + "_NAME")
+		if nameProperty == "" {
+			nameProperty = "name"
+		}
+
+		region := os.Getenv(env + "_REGION")
+		if region == "" {
+			region = DefaultRegion
+		}
+
+		if err := ImportBoundaries(path, kind, nameProperty, region); err != nil {
+			log.Println("Importing", kind, "boundaries failed:", err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// cache.go contains the in-process cache of API responses, which is
+// cleared after each ingest, and answers conditional
+// requests by the ETag and Last-Modified of the responses.
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheEntries is the largest number of responses cached.
+const DefaultCacheEntries = 1000
+
+// A CachedResponse struct maps a successful response of an API.
+type CachedResponse struct {
+	Header http.Header
+	Body   []byte
+	ETag   string
+}
+
+// A ResponseCache struct maps the cached responses of the filters of
+// each API. The cache is cleared after each ingest, which also sets the
+// Last-Modified time of all responses.
+type ResponseCache struct {
+	MaxEntries int
+
+	mutex      sync.RWMutex
+	entries    map[string]CachedResponse
+	modified   time.Time
+	generation int
+}
+
+// NewResponseCache returns an empty ResponseCache of up to maxEntries
+// responses, last modified now.
+func NewResponseCache(maxEntries int) *ResponseCache {
+	return &ResponseCache{
+		MaxEntries: maxEntries,
+		entries:    map[string]CachedResponse{},
+		modified:   time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// CacheKey returns the key of the response of a request: its path and
+// parameters (including the body of a POST request) sorted by name,
+// without the API key.
+func CacheKey(r *http.Request) string {
+	params := url.Values{}
+	for param, values := range peekRequestParams(r) {
+		if param != "api_key" {
+			params[param] = values
+		}
+	}
+	return r.URL.Path + "?" + params.Encode()
+}
+
+// MakeETag returns the strong ETag of the body of a response.
+func MakeETag(body []byte) string {
+	hash := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// Invalidate clears the cache after an ingest. Ingests that don't change
+// outages still clear it, as the jobs run after them update hot-spots and
+// recurring outages, and outages can end without being changed.
+func (cache *ResponseCache) Invalidate(report IngestReport) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries = map[string]CachedResponse{}
+	cache.modified = report.FinishedAt.UTC().Truncate(time.Second)
+	cache.generation++
+}
+
+// get returns the cached response of a key, the Last-Modified time and
+// the generation of the cache.
+func (cache *ResponseCache) get(key string) (CachedResponse, bool, time.Time, int) {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+	response, ok := cache.entries[key]
+	return response, ok, cache.modified, cache.generation
+}
+
+// set caches a response, unless the cache was cleared since the
+// response was made. An arbitrary entry is evicted when the cache is
+// full.
+func (cache *ResponseCache) set(key string, response CachedResponse, generation int) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if generation != cache.generation {
+		return
+	}
+
+	if len(cache.entries) >= cache.MaxEntries {
+		for evicted := range cache.entries {
+			delete(cache.entries, evicted)
+			break
+		}
+	}
+	cache.entries[key] = response
+}
+
+// Middleware answers GET and POST requests from the cache, or caches the
+// successful response of the API. Responses carry the ETag,
+// Last-Modified and Cache-Control (until the next ingest) headers, and
+// requests with a matching If-None-Match or If-Modified-Since get a 304.
+// Responses vary by API key, as its scopes decide which requests are
+// answered, so shared caches only keep responses of requests without one.
+func (cache *ResponseCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := CacheKey(r)
+		response, ok, modified, generation := cache.get(key)
+		if !ok {
+			recorder := newResponseRecorder()
+			next.ServeHTTP(recorder, r)
+
+			// Errors of this app are JSON objects, even with a 200 status
+			if recorder.status != http.StatusOK ||
+				bytes.HasPrefix(recorder.body.Bytes(), []byte(`{"Error Code"`)) {
+				recorder.writeTo(w)
+				return
+			}
+
+			response = CachedResponse{
+				Header: recorder.header,
+				Body:   recorder.body.Bytes(),
+				ETag:   MakeETag(recorder.body.Bytes()),
+			}
+			cache.set(key, response, generation)
+			w.Header().Set("X-Cache", "MISS")
+		} else {
+			w.Header().Set("X-Cache", "HIT")
+		}
+
+		for name, values := range response.Header {
+			w.Header()[name] = append([]string(nil), values...)
+		}
+		w.Header().Set("ETag", response.ETag)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.Header().Set("Cache-Control",
+			CacheControl(NextIngest(), time.Now(), GetRequestAPIKey(r) != ""))
+		w.Header().Set("Vary", "Authorization, X-API-Key")
+
+		if IsNotModified(r, response.ETag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(response.Body)
+	})
+}
+
+// CacheControl returns the Cache-Control header of a response that may
+// be cached until the next ingest, only by the client if it is private.
+func CacheControl(nextIngest, now time.Time, private bool) string {
+	maxAge := 0
+	if nextIngest.After(now) {
+		maxAge = ceilSeconds(nextIngest.Sub(now))
+	}
+	visibility := "public"
+	if private {
+		visibility = "private"
+	}
+	return fmt.Sprintf("%s, max-age=%d", visibility, maxAge)
+}
+
+// IsNotModified returns true if the If-None-Match header of a request
+// matches the ETag of a response or, without an If-None-Match header, if
+// the response was not modified since If-Modified-Since.
+func IsNotModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			if tag = strings.TrimSpace(tag); tag == "*" || tag == etag || tag == "W/"+etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.After(since)
+}
+
+// responseRecorder records the response of an API so that it can be
+// cached before it is written.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (recorder *responseRecorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *responseRecorder) Write(body []byte) (int, error) {
+	return recorder.body.Write(body)
+}
+
+func (recorder *responseRecorder) WriteHeader(status int) {
+	recorder.status = status
+}
+
+// writeTo writes the recorded response as is.
+func (recorder *responseRecorder) writeTo(w http.ResponseWriter) {
+	for name, values := range recorder.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(recorder.status)
+	w.Write(recorder.body.Bytes())
+}
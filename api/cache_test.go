@@ -0,0 +1,127 @@
+// cache_test.go contains tests that test cache.go
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCacheKey calls api.CacheKey and checks that requests with the same
+// parameters in any order, url or body share a key without the API key.
+func TestCacheKey(t *testing.T) {
+	expected := "/count?get=suburb&outage_type=Planned"
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/count?outage_type=Planned&get=suburb", nil),
+		httptest.NewRequest("GET", "/count?get=suburb&api_key=wda_x&outage_type=Planned", nil),
+		httptest.NewRequest("POST", "/count?get=suburb",
+			strings.NewReader(`{"outage_type": "Planned"}`)),
+	}
+
+	for _, r := range requests {
+		if actual := CacheKey(r); actual != expected {
+			t.Fatalf(`TestCacheKey did not return %v for %v got %v`, expected, r.URL, actual)
+		}
+	}
+}
+
+// TestCacheControl calls api.CacheControl and checks that responses may
+// be cached until the next ingest, privately for requests with an API key.
+func TestCacheControl(t *testing.T) {
+	now := time.Date(2022, 6, 22, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		nextIngest time.Time
+		private    bool
+		expected   string
+	}{
+		{now.Add(42*time.Minute + 500*time.Millisecond), false, "public, max-age=2521"},
+		{now.Add(42*time.Minute + 500*time.Millisecond), true, "private, max-age=2521"},
+		{now.Add(-time.Minute), false, "public, max-age=0"},
+		{time.Time{}, false, "public, max-age=0"},
+	}
+
+	for _, test := range tests {
+		if actual := CacheControl(test.nextIngest, now, test.private); actual != test.expected {
+			t.Fatalf(`TestCacheControl did not return %v for %v got %v`,
+				test.expected, test.nextIngest, actual)
+		}
+	}
+}
+
+// TestResponseCacheMiddleware calls api.ResponseCache.Middleware and
+// checks that responses are cached with an ETag until the next ingest,
+// that they vary by API key, and that conditional requests get a 304.
+func TestResponseCacheMiddleware(t *testing.T) {
+	cache := NewResponseCache(DefaultCacheEntries)
+	calls := 0
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		WriteJSON(w, []DBWaterOutage{{OutageID: calls}})
+	}))
+	get := func(header, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/?suburb=Remuera", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("", "")
+	second := get("", "")
+	etag := first.Header().Get("ETag")
+	if calls != 1 || etag == "" || second.Header().Get("ETag") != etag ||
+		second.Header().Get("X-Cache") != "HIT" ||
+		second.Body.String() != first.Body.String() ||
+		second.Header().Get("Content-Type") != "application/json" ||
+		second.Header().Get("Vary") != "Authorization, X-API-Key" ||
+		!strings.HasPrefix(second.Header().Get("Cache-Control"), "public, ") {
+		t.Fatalf(`TestResponseCacheMiddleware did not cache the response got %v calls, %v`,
+			calls, second.Header())
+	}
+
+	if w := get("If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Fatalf(`TestResponseCacheMiddleware did not return 304 got %v`, w.Code)
+	}
+	if w := get("If-None-Match", `"other"`); w.Code != http.StatusOK {
+		t.Fatalf(`TestResponseCacheMiddleware did not return 200 got %v`, w.Code)
+	}
+	if w := get("If-Modified-Since", first.Header().Get("Last-Modified")); w.Code != http.StatusNotModified {
+		t.Fatalf(`TestResponseCacheMiddleware did not return 304 got %v`, w.Code)
+	}
+	if w := get("X-API-Key", "wda_export"); !strings.HasPrefix(w.Header().Get("Cache-Control"), "private, ") {
+		t.Fatalf(`TestResponseCacheMiddleware did not return a private response got %v`, w.Header())
+	}
+
+	// Ingests without changes clear the cache too
+	cache.Invalidate(IngestReport{FinishedAt: time.Now().Add(time.Hour)})
+	if w := get("If-None-Match", etag); calls != 2 || w.Code != http.StatusOK ||
+		w.Header().Get("ETag") == etag {
+		t.Fatalf(`TestResponseCacheMiddleware did not clear the cache got %v calls`, calls)
+	}
+}
+
+// TestResponseCacheMiddlewareError calls api.ResponseCache.Middleware
+// and checks that errors are not cached.
+func TestResponseCacheMiddlewareError(t *testing.T) {
+	cache := NewResponseCache(DefaultCacheEntries)
+	calls := 0
+	handler := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		WriteJSON(w, AppError{ErrorCode: 3441, Message: "unknown error"})
+	}))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Header().Get("ETag") != "" {
+			t.Fatalf(`TestResponseCacheMiddlewareError did not skip the ETag got %v`, w.Header())
+		}
+	}
+	if calls != 2 {
+		t.Fatalf(`TestResponseCacheMiddlewareError did not return 2 calls got %v`, calls)
+	}
+}
@@ -0,0 +1,216 @@
+// calendar.go creates the controller function of the iCalendar (RFC 5545)
+// feed of planned outages.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+const (
+	// icalLineLength is the largest number of octets of a content line,
+	// excluding the line break.
+	icalLineLength = 75
+	// icalTimeFormat is the format of UTC date-times.
+	icalTimeFormat = "20060102T150405Z"
+	// CalendarPastDays is the number of days planned outages stay in the
+	// calendar after they started.
+	CalendarPastDays = 90
+)
+
+// A CalendarEvent struct maps a planned outage as a VEVENT. End is zero
+// if the end of the outage is unknown.
+type CalendarEvent struct {
+	UID       string
+	Sequence  int
+	Start     time.Time
+	End       time.Time
+	Summary   string
+	Location  string
+	Latitude  float64
+	Longitude float64
+}
+
+// EscapeICalText escapes the backslashes, semicolons, commas and line
+// breaks of a TEXT value.
+func EscapeICalText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`,
+	).Replace(text)
+}
+
+// FoldICalLine splits a content line into lines of at most 75 octets,
+// joined by a line break and a space, without splitting UTF-8 characters.
+func FoldICalLine(line string) string {
+	var folded strings.Builder
+	limit := icalLineLength
+
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		folded.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The leading space of continuation lines counts towards the limit
+		limit = icalLineLength - 1
+	}
+
+	folded.WriteString(line + "\r\n")
+	return folded.String()
+}
+
+// MakeCalendar returns an iCalendar feed of events named name, stamped
+// with the given time.
+func MakeCalendar(name string, events []CalendarEvent, stamp time.Time) string {
+	var calendar strings.Builder
+	write := func(line string) {
+		calendar.WriteString(FoldICalLine(line))
+	}
+
+	write("BEGIN:VCALENDAR")
+	write("VERSION:2.0")
+	write("PRODID:-//water-down-again//Planned water outages//EN")
+	write("CALSCALE:GREGORIAN")
+	write("METHOD:PUBLISH")
+	write("X-WR-CALNAME:" + EscapeICalText(name))
+
+	for _, event := range events {
+		write("BEGIN:VEVENT")
+		write("UID:" + event.UID)
+		write(fmt.Sprintf("SEQUENCE:%d", event.Sequence))
+		write("DTSTAMP:" + stamp.UTC().Format(icalTimeFormat))
+		write("DTSTART:" + event.Start.UTC().Format(icalTimeFormat))
+		if !event.End.IsZero() {
+			write("DTEND:" + event.End.UTC().Format(icalTimeFormat))
+		}
+		write("SUMMARY:" + EscapeICalText(event.Summary))
+		if event.Location != "" {
+			write("LOCATION:" + EscapeICalText(event.Location))
+		}
+		write(fmt.Sprintf("GEO:%f;%f", event.Latitude, event.Longitude))
+		write("STATUS:CONFIRMED")
+		write("TRANSP:TRANSPARENT")
+		write("END:VEVENT")
+	}
+
+	write("END:VCALENDAR")
+	return calendar.String()
+}
+
+// CalendarUID returns the stable UID of the event of an outage.
+func CalendarUID(region string, outageID int) string {
+	return fmt.Sprintf("%d.%s@water-down-again", outageID, region)
+}
+
+// calendarName returns the name of a calendar of the outages of the given
+// suburbs and streets.
+func calendarName(suburbs, streets []string) string {
+	places := append(append([]string{}, streets...), suburbs...)
+	if len(places) == 0 {
+		return "Planned water outages"
+	}
+	return "Planned water outages in " + strings.Join(places, ", ")
+}
+
+// GetCalendar returns an iCalendar feed of the planned outages matching
+// the same parameters as GetOutages (such as suburb and street) that
+// started in the last 90 days or later. The SEQUENCE of an outage is the
+// number of times its end date changed.
+func GetCalendar(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetCalendar request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := r.URL.Query()
+	query := &Query{IsCount: true}
+	filter := query.MakeWhereStringWith(params, "outage_type = 'Planned'",
+		"start_date IS NOT NULL",
+		fmt.Sprintf("start_date >= CURRENT_DATE - INTERVAL '%d days'", CalendarPastDays))
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	main := `SELECT outage_id, region, COALESCE(street, ''), COALESCE(suburb, ''),
+		COALESCE(raw_location, ''), start_date, end_date,
+		ST_X(location::geometry), ST_Y(location::geometry), (
+			SELECT count(*) FROM (
+				SELECT lag(id) OVER (ORDER BY id) IS NOT NULL AND end_date IS DISTINCT FROM
+				lag(end_date) OVER (ORDER BY id) AS changed FROM outage_snapshot
+				WHERE outage_snapshot.region = outage.region
+				AND outage_snapshot.outage_id = outage.outage_id
+			) AS changes WHERE changed
+		) FROM outage` + filter + ` ORDER BY start_date, outage_id`
+
+	// Setup the database
+	db := database.DB()
+
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	var events []CalendarEvent
+	for rows.Next() {
+		var outageID int
+		var regionName, street, suburb, rawLocation, start string
+		var end sql.NullString
+		event := CalendarEvent{}
+		if err := rows.Scan(&outageID, &regionName, &street, &suburb,
+			&rawLocation, &start, &end, &event.Longitude, &event.Latitude,
+			&event.Sequence); err != nil {
+			log.Println(err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3505,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+
+		region := GetRegion(regionName)
+		event.UID = CalendarUID(region.Name, outageID)
+		event.Start, _ = region.ParseOutageTime(start)
+		if end.Valid {
+			event.End, _ = region.ParseOutageTime(end.String)
+		}
+
+		place := strings.Trim(street+", "+suburb, ", ")
+		event.Summary = "Planned water outage"
+		if place != "" {
+			event.Summary += " — " + place
+		}
+		event.Location = rawLocation
+		if event.Location == "" {
+			event.Location = place
+		}
+		events = append(events, event)
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="outages.ics"`)
+	fmt.Fprint(w, MakeCalendar(
+		calendarName(params["suburb"], params["street"]), events, time.Now(),
+	))
+}
@@ -0,0 +1,75 @@
+// calendar_test.go contains tests that test calendar.go
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEscapeICalText calls api.EscapeICalText and checks that special
+// characters of TEXT values are escaped.
+func TestEscapeICalText(t *testing.T) {
+	actual := EscapeICalText("12 Queen St, Auckland; C:\\ line\nbreak")
+	expected := `12 Queen St\, Auckland\; C:\\ line\nbreak`
+
+	if actual != expected {
+		t.Fatalf(`TestEscapeICalText did not return %v got %v`, expected, actual)
+	}
+}
+
+// TestFoldICalLine calls api.FoldICalLine and checks that lines are
+// folded at 75 octets without splitting UTF-8 characters.
+func TestFoldICalLine(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("a", 66) + "—" + strings.Repeat("b", 80)
+	actual := FoldICalLine(line)
+
+	lines := strings.Split(strings.TrimSuffix(actual, "\r\n"), "\r\n")
+	for i, folded := range lines {
+		if len(folded) > 75 || (i > 0 && !strings.HasPrefix(folded, " ")) {
+			t.Fatalf(`TestFoldICalLine did not fold %q got %q`, line, actual)
+		}
+	}
+	if lines[0] != "SUMMARY:"+strings.Repeat("a", 66) {
+		t.Fatalf(`TestFoldICalLine split a character got %q`, lines[0])
+	}
+
+	unfolded := strings.Replace(strings.TrimSuffix(actual, "\r\n"), "\r\n ", "", -1)
+	if unfolded != line {
+		t.Fatalf(`TestFoldICalLine did not unfold to %q got %q`, line, unfolded)
+	}
+}
+
+// TestMakeCalendar calls api.MakeCalendar and checks the VEVENT of a
+// planned outage.
+func TestMakeCalendar(t *testing.T) {
+	auckland, _ := time.LoadLocation("Pacific/Auckland")
+	events := []CalendarEvent{{
+		UID:       CalendarUID("auckland", 123),
+		Sequence:  2,
+		Start:     time.Date(2022, 6, 22, 9, 0, 0, 0, auckland),
+		End:       time.Date(2022, 6, 22, 15, 30, 0, 0, auckland),
+		Summary:   "Planned water outage — Queen Street, Auckland Central",
+		Location:  "12 Queen St, Auckland Central",
+		Latitude:  -36.8485,
+		Longitude: 174.7633,
+	}}
+	stamp := time.Date(2022, 6, 20, 0, 0, 0, 0, time.UTC)
+
+	actual := MakeCalendar("Planned water outages", events, stamp)
+	expected := "BEGIN:VEVENT\r\n" +
+		"UID:123.auckland@water-down-again\r\n" +
+		"SEQUENCE:2\r\n" +
+		"DTSTAMP:20220620T000000Z\r\n" +
+		"DTSTART:20220621T210000Z\r\n" +
+		"DTEND:20220622T033000Z\r\n" +
+		"SUMMARY:Planned water outage — Queen Street\\, Auckland Central\r\n" +
+		"LOCATION:12 Queen St\\, Auckland Central\r\n" +
+		"GEO:-36.848500;174.763300\r\n"
+
+	if !strings.HasPrefix(actual, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n") ||
+		!strings.Contains(actual, expected) ||
+		!strings.HasSuffix(actual, "END:VEVENT\r\nEND:VCALENDAR\r\n") {
+		t.Fatalf(`TestMakeCalendar did not return %q got %q`, expected, actual)
+	}
+}
@@ -2,20 +2,26 @@
 package api
 
 import (
+	"database/sql"
 	"log"
 	"strings"
 	"unicode"
 
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
-
 	"github.com/axkeyz/water-down-again/database"
 	_ "github.com/lib/pq"
 )
 
 // CleanAddressName removes all numbers (unit/street numbers, postcodes) from
-// an address string and returns the address in title-case.
+// an address string and returns the address in title-case, by the address
+// rules of the default region.
 func CleanAddressName(address string, address_type string) string {
+	return GetRegion(DefaultRegion).CleanAddressName(address, address_type)
+}
+
+// CleanAddressName removes all numbers (unit/street numbers, postcodes) from
+// an address string and returns the address in title-case, by the address
+// rules of the region.
+func (region *Region) CleanAddressName(address string, address_type string) string {
 	location := strings.Split(strings.ToLower(address), " ")
 	var cleaned_location []string
 	var add_location bool
@@ -43,10 +49,11 @@ func CleanAddressName(address string, address_type string) string {
 		}
 
 		if address_type == "street" && first_word == i {
-			// Ignore first 'st' of streets
-			j = UnabbreviateAddressName(j, "suburb")
+			// The first word of a street is usually not the suffix, so
+			// 'st' is Saint rather than Street
+			j = region.UnabbreviateAddressName(j, "street_start")
 		} else {
-			j = UnabbreviateAddressName(j, address_type)
+			j = region.UnabbreviateAddressName(j, address_type)
 		}
 
 		if add_location {
@@ -59,26 +66,35 @@ func CleanAddressName(address string, address_type string) string {
 	return strings.TrimSpace(strings.Join(cleaned_location, " "))
 }
 
-// UnabbreviateAddressName replaces short-hands in addresses with the long form.
+// UnabbreviateAddressName replaces short-hands in addresses with the long form,
+// by the address rules of the default region.
 func UnabbreviateAddressName(address string, address_type string) string {
-	caser := cases.Title(language.English)
+	return GetRegion(DefaultRegion).UnabbreviateAddressName(address, address_type)
+}
+
+// UnabbreviateAddressName replaces short-hands in addresses with the long form,
+// by the address rules of the region. The address_type is either "street",
+// "street_start" (first word of a street) or "suburb".
+func (region *Region) UnabbreviateAddressName(address string, address_type string) string {
+	rules := region.AddressRules
 
 	// If address is an abbreviation, return the uncodensed form after checking the address type
-	if address_type == "street" {
-		if unabbreviated, ok := street_abbreviations[address]; ok {
-			return caser.String(unabbreviated)
-		}
-	} else {
-		if unabbreviated, ok := suburb_abbreviations[address]; ok {
-			return caser.String(unabbreviated)
-		}
+	if unabbreviated, ok := rules.Abbreviations(address_type)[strings.ToLower(address)]; ok {
+		return rules.TitleCase(unabbreviated)
 	}
 
-	return caser.String(address)
+	return rules.TitleCase(address)
 }
 
-// AddressToStreetSuburb attempts to return the street and suburb from the given address.
+// AddressToStreetSuburb attempts to return the street and suburb from the given
+// address in the default region.
 func AddressToStreetSuburb(address string) (string, string) {
+	return GetRegion(DefaultRegion).AddressToStreetSuburb(address)
+}
+
+// AddressToStreetSuburb attempts to return the street and suburb from the given
+// address in the region.
+func (region *Region) AddressToStreetSuburb(address string) (string, string) {
 	address = strings.ToLower(address)
 	address_slice := strings.Split(address, ",")
 	length := len(address_slice)
@@ -88,23 +104,25 @@ func AddressToStreetSuburb(address string) (string, string) {
 	suburb := "UNKNOWN"
 
 	if length >= 2 {
-		// Address is comma-separated, usually in the format: [unit/flat, ]street, suburb[, auckland]
+		// Address is comma-separated, usually in the format: [unit/flat, ]street, suburb[, city]
 		index := length
 		for i := 0; i < length; i++ {
-			if strings.Contains(address_slice[i], "auckland") &&
-				!strings.Contains(address_slice[i], "auckland central") &&
-				!strings.Contains(address_slice[i], "auckland cbd") {
+			if region.IsCityName(address_slice[i]) {
 				// Reassign index if there are extra commas after the suburb
 				index = i
 				break
 			}
 		}
+		if index < 2 {
+			// The city is given instead of the suburb
+			index = 2
+		}
 		// Save the street and suburb
 		street, suburb = address_slice[index-2], address_slice[index-1]
 	} else {
-		// Address is not comma-separated, usually in the format: street suburb[ auckland]
+		// Address is not comma-separated, usually in the format: street suburb[ city]
 		var position int
-		for _, i := range suburbs {
+		for _, i := range region.Suburbs {
 			// Find last occurence of suburb (i) to avoid road names that have the suburb name included
 			position = strings.LastIndex(address, i)
 			if position > 0 {
@@ -117,43 +135,97 @@ func AddressToStreetSuburb(address string) (string, string) {
 	}
 
 	// Return formatted version of street and suburb
-	return CleanAddressName(street, "street"), CleanAddressName(suburb, "suburb")
+	return region.CleanAddressName(street, "street"), region.CleanAddressName(suburb, "suburb")
+}
+
+// IsCityName returns true if a part of a comma-separated address is the
+// name of the city of the region rather than a suburb.
+func (region *Region) IsCityName(part string) bool {
+	for _, central := range region.CentralSuburbs {
+		if strings.Contains(part, central) {
+			return false
+		}
+	}
+
+	for _, city := range region.CityNames {
+		if strings.Contains(part, city) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParserVersion is the version of the address parser made up of
+// AddressToStreetSuburb and CleanAddressName. It must be increased
+// whenever the parser code changes its output, so that CleanupOutages
+// re-derives the street and suburb of older outages. The version saved
+// with each outage is the CurrentParserVersion of its region.
+const ParserVersion = 2
+
+// RederiveStreetSuburb returns the street and suburb of an outage from
+// its raw upstream location. Outages saved before the raw location was
+// kept have no raw location, so their saved street and suburb are
+// cleaned again instead.
+func RederiveStreetSuburb(region *Region,
+	rawLocation sql.NullString, street, suburb string) (string, string) {
+	if rawLocation.Valid && rawLocation.String != "" {
+		return region.AddressToStreetSuburb(rawLocation.String)
+	}
+
+	return region.CleanAddressName(strings.ToLower(street), "street"),
+		region.CleanAddressName(strings.ToLower(suburb), "suburb")
 }
 
-// CleanupOutages re-formats the all existing outages in the database
+// CleanupOutages re-derives the street and suburb of all outages in the
+// database that were saved by an older version of the address parser or
+// address rules of their region.
 func CleanupOutages() {
 	// Open database
-	db := database.SetupDB()
-	defer db.Close()
+	db := database.DB()
+
+	for _, name := range RegionNames() {
+		cleanupRegionOutages(db, Regions[name])
+	}
 
+	log.Println("Outages have been cleaned up.")
+}
+
+// cleanupRegionOutages re-derives the street and suburb of the outages of
+// a region that were saved by another version of its address parser.
+func cleanupRegionOutages(db *sql.DB, region *Region) {
 	// Prepare SQL Statement
-	query := `SELECT id, lower(street), lower(suburb) FROM outage`
-	rows, err := db.Query(query)
+	query := `SELECT id, raw_location, COALESCE(street, ''), 
+		COALESCE(suburb, '') FROM outage WHERE region = $1 AND parser_version <> $2`
+	version := region.CurrentParserVersion()
+	rows, err := db.Query(query, region.Name, version)
+	if err != nil {
+		log.Println("Cleanup outages failed:", err)
+		return
+	}
+	defer rows.Close()
 
+	var rawLocation sql.NullString
 	var suburb, street string
 	var id int
 
-	if err != nil {
-		log.Println("Cleanup outages failed:", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			// Get data in the row
-			err = rows.Scan(&id, &street, &suburb)
-			if err != nil {
-				log.Println("Cleanup outages failed:", err)
-			}
+	for rows.Next() {
+		// Get data in the row
+		err = rows.Scan(&id, &rawLocation, &street, &suburb)
+		if err != nil {
+			log.Println("Cleanup outages failed:", err)
+			continue
+		}
 
-			_, err := db.Exec(
-				"UPDATE outage SET street = $1, suburb = $2 where id = $3",
-				CleanAddressName(street, "street"), CleanAddressName(suburb, "suburb"), id,
-			)
+		street, suburb = RederiveStreetSuburb(region, rawLocation, street, suburb)
 
-			if err != nil {
-				log.Println("Cleanup outages failed:", err)
-			}
+		_, err := db.Exec(
+			`UPDATE outage SET street = $1, suburb = $2, 
+			parser_version = $3 where id = $4`,
+			street, suburb, version, id,
+		)
+
+		if err != nil {
+			log.Println("Cleanup outages failed:", err)
 		}
 	}
-
-	log.Println("Outages have been cleaned up.")
 }
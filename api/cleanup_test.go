@@ -1,6 +1,7 @@
 package api
 
 import (
+	"database/sql"
 	"testing"
 )
 
@@ -81,3 +82,34 @@ func TestCleanAddressName(t *testing.T) {
 		)
 	}
 }
+
+// TestRederiveStreetSuburb calls api.RederiveStreetSuburb and checks that
+// the raw location is preferred over the saved street and suburb.
+func TestRederiveStreetSuburb(t *testing.T) {
+	tests := []struct {
+		raw            sql.NullString
+		street, suburb string
+		expected       [2]string
+	}{
+		{
+			sql.NullString{String: "12 Queen St, Auckland CBD, Auckland", Valid: true},
+			"Wrong Street", "Wrong Suburb",
+			[2]string{"Queen Street", "Auckland Central"},
+		},
+		{
+			sql.NullString{}, "st meadowland st", "mt eden",
+			[2]string{"Saint Meadowland Street", "Mount Eden"},
+		},
+	}
+
+	for _, test := range tests {
+		street, suburb := RederiveStreetSuburb(
+			GetRegion(DefaultRegion), test.raw, test.street, test.suburb)
+		if street != test.expected[0] || suburb != test.expected[1] {
+			t.Fatalf(
+				`TestRederiveStreetSuburb did not return %s, %s got %s, %s`,
+				test.expected[0], test.expected[1], street, suburb,
+			)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// controller_utils.go contains utility functions shared by the controller
+// functions of this app.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/lib/pq"
+)
+
+// maxBodyBytes is the largest request body read as parameters.
+const maxBodyBytes = 5 << 20
+
+// OutageColumns is the SQL select list of a single outage, with column
+// names that match DBWaterOutageCol. The status of an outage is whether it
+// was still listed by the upstream API at the last ingest.
+const OutageColumns = `outage_id, region, street, suburb, 
+	st_astext(location) AS location, start_date, end_date, outage_type, 
+	COALESCE(raw_location, '') AS raw_location, recurring, active AS status`
+
+// WriteJSON JSON-encodes a value as the response.
+func WriteJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// WriteAppError JSON-encodes an AppError as the response with the given
+// HTTP status code.
+func WriteAppError(w http.ResponseWriter, status int, appError AppError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(appError)
+}
+
+// GetRequestParams returns the url parameters of a request combined with
+// the parameters in the body of a POST request, for filters too large for
+// a url such as GeoJSON polygons. The body is either form-encoded or a
+// JSON object of parameters. A JSON body that is a GeoJSON object is used
+// as the "within" parameter.
+func GetRequestParams(r *http.Request) url.Values {
+	params := r.URL.Query()
+	if r.Method != http.MethodPost || r.Body == nil {
+		return params
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes))
+	if err != nil {
+		log.Println(err)
+		return params
+	}
+
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			log.Println(err)
+		}
+		for key, values := range form {
+			params[key] = append(params[key], values...)
+		}
+		return params
+	}
+
+	// Otherwise, the body is JSON
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		log.Println(err)
+		return params
+	}
+
+	if _, ok := object["type"]; ok {
+		params.Set("within", string(body))
+		return params
+	}
+
+	for key, raw := range object {
+		var value interface{}
+		json.Unmarshal(raw, &value)
+
+		switch value := value.(type) {
+		case string:
+			params.Add(key, value)
+		case []interface{}:
+			for _, item := range value {
+				params.Add(key, fmt.Sprint(item))
+			}
+		case map[string]interface{}:
+			params.Add(key, string(raw))
+		case nil:
+		default:
+			params.Add(key, fmt.Sprint(value))
+		}
+	}
+	return params
+}
+
+// ScanOutages maps each row to a DBWaterOutage struct by the column
+// names of the rows.
+func ScanOutages(rows *sql.Rows) ([]DBWaterOutage, error) {
+	var outages []DBWaterOutage
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return outages, err
+	}
+
+	for rows.Next() {
+		outage := DBWaterOutage{}
+
+		// make references for the columns by calling DBWaterOutageCol
+		column := make([]interface{}, len(columns))
+		for i := range columns {
+			column[i] = DBWaterOutageCol(columns[i], &outage)
+		}
+
+		if err := rows.Scan(column...); err != nil {
+			return outages, err
+		}
+		outages = append(outages, outage)
+	}
+
+	return outages, rows.Err()
+}
+
+// FinishOutages formats the start and end dates of outages in the
+// timezone of their region.
+func FinishOutages(outages []DBWaterOutage) {
+	for i := range outages {
+		region := GetRegion(outages[i].Region)
+		outages[i].StartDate = region.FormatOutageTime(outages[i].StartDate)
+		outages[i].EndDate = region.FormatOutageTime(outages[i].EndDate)
+	}
+}
+
+// GetActiveOutageIDs returns the ids of the outages of each region that
+// were still listed by the upstream API at the last ingest.
+func GetActiveOutageIDs(db *sql.DB) (map[string][]int, error) {
+	active_outage_ids := make(map[string][]int)
+
+	rows, err := db.Query(`SELECT region, outage_id FROM outage WHERE active`)
+	if err != nil {
+		return active_outage_ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var region string
+		var outageID int
+		if err := rows.Scan(&region, &outageID); err != nil {
+			return active_outage_ids, err
+		}
+		active_outage_ids[region] = append(active_outage_ids[region], outageID)
+	}
+	return active_outage_ids, rows.Err()
+}
+
+// logQueryError logs a failed query with the query.
+func logQueryError(err error, query string) {
+	log.Println(err)
+	log.Println(query)
+}
+
+// IsInvalidParamsError returns true if a query failed on a value given in
+// the parameters, such as a malformed date, rather than in the database.
+func IsInvalidParamsError(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code.Class() == "22"
+}
+
+// writeQueryError writes the error of a failed query: a 400 if a value
+// given in the parameters was invalid, or else a 500 with the given code.
+func writeQueryError(w http.ResponseWriter, err error, code int64) {
+	if IsInvalidParamsError(err) {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	WriteAppError(w, http.StatusInternalServerError, AppError{
+		ErrorCode: code,
+		Message:   "unknown error",
+		Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+	})
+}
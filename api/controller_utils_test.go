@@ -0,0 +1,68 @@
+// controller_utils_test.go contains tests that test controller_utils.go
+package api
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// TestGetRequestParams calls api.GetRequestParams and checks that the
+// parameters in the url and body of a request are combined.
+func TestGetRequestParams(t *testing.T) {
+	polygon := `{"type":"Polygon","coordinates":[[[174.7,-36.9],[174.8,-36.9],[174.8,-36.8],[174.7,-36.9]]]}`
+	tests := []struct {
+		contentType, body, param string
+		expected                 []string
+	}{
+		{"application/json", `{"within":` + polygon + `,"suburb":["Remuera","Epsom"]}`,
+			"within", []string{polygon}},
+		{"application/json", `{"suburb":["Remuera","Epsom"],"outage_id":15899}`,
+			"suburb", []string{"Grey Lynn", "Remuera", "Epsom"}},
+		{"application/json", `{"outage_id":15899}`, "outage_id", []string{"15899"}},
+		{"application/json", polygon, "within", []string{polygon}},
+		{"application/x-www-form-urlencoded", "suburb=Remuera&outage_type=Planned",
+			"suburb", []string{"Grey Lynn", "Remuera"}},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/?suburb=Grey+Lynn", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+
+		actual := GetRequestParams(r)[test.param]
+		if strings.Join(actual, "|") != strings.Join(test.expected, "|") {
+			t.Fatalf(
+				`TestGetRequestParams did not return %v for %s got %v`,
+				test.expected, test.param, actual,
+			)
+		}
+	}
+}
+
+// TestIsInvalidParamsError calls api.IsInvalidParamsError and checks that
+// only data exceptions of a query are errors of the parameters.
+func TestIsInvalidParamsError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&pq.Error{Code: "22007"}, true},
+		{&pq.Error{Code: "22008"}, true},
+		{&pq.Error{Code: "42P01"}, false},
+		{&pq.Error{Code: "57014"}, false},
+		{errors.New("sql: database is closed"), false},
+	}
+
+	for _, test := range tests {
+		actual := IsInvalidParamsError(test.err)
+		if actual != test.expected {
+			t.Fatalf(
+				`TestIsInvalidParamsError did not return %v for %v got %v`,
+				test.expected, test.err, actual,
+			)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// count.go contains functions that count outages by the "get" parameters
+// of the count API, which are shared with the reports built on top of it.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// An InvalidParamsError lists the parameters that were invalid.
+type InvalidParamsError []string
+
+func (params InvalidParamsError) Error() string {
+	return strings.Join(params, ", ")
+}
+
+// populationSource is the outage table joined to the population of the
+// suburbs of outages, aliased as outage so filters still apply.
+const populationSource = `(SELECT outage.*, suburb_population.population,
+	suburb_population.dwellings FROM outage LEFT JOIN suburb_population
+	ON suburb_population.region = outage.region
+	AND lower(suburb_population.suburb) = lower(outage.suburb)) AS outage`
+
+// RateSQL returns the SQL select of a rate per 1000 people or dwellings
+// of a group of outages, with the denominator it is based on. The rate is
+// NULL if the denominator is unknown or 0.
+func RateSQL(rate string) string {
+	denominator := RateDenominators[rate]
+	return fmt.Sprintf(
+		`MAX(%s)::bigint AS %s,
+		(count(outage_id) * 1000.0 / NULLIF(MAX(%s), 0))::float AS %s`,
+		denominator, denominator, denominator, rate,
+	)
+}
+
+// MakeCountQuery generates the SQL query of the count API from the given
+// parameters. The "get" parameters group the counts by filterable
+// columns, and add the total hours, statistics of the hours of outages
+// (such as median_hours) or rates per 1000 people or dwellings.
+// Rates are only valid for counts grouped by suburb.
+func MakeCountQuery(params url.Values) (string, error) {
+	query, filter, order := MakeQuery(params, true)
+	invalid := query.InvalidParams
+
+	var grouped, selected, rates []string
+	for _, element := range params["get"] {
+		if IsFilterableParam(element) {
+			if strings.Contains(element, "start_date") && element != "start_date" ||
+				strings.Contains(element, "end_date") && element != "end_date" {
+				element = strings.Join(strings.Split(element, "_")[1:2], "_")
+			}
+			grouped = append(grouped, element)
+			selected = append(selected, element)
+		} else if element == "total_hours" {
+			selected = append(selected,
+				"COALESCE(SUM("+query.Hours+"), 0) total_hours",
+			)
+		} else if metric, ok := HourMetrics[element]; ok {
+			selected = append(selected,
+				fmt.Sprintf(metric+"::float AS %s", query.Hours, element),
+			)
+		} else if _, ok := RateDenominators[element]; ok {
+			rates = append(rates, element)
+		}
+	}
+
+	// Rates are joined to the population of suburbs
+	source := "outage"
+	if len(rates) > 0 {
+		if !isStringInArray("suburb", grouped) {
+			invalid = append(invalid, "get=rate_per_1000_* (needs get=suburb)")
+		}
+
+		var missing []string
+		for _, rate := range rates {
+			selected = append(selected, RateSQL(rate))
+			missing = append(missing, fmt.Sprintf(
+				"COALESCE(MAX(%s), 0) = 0", RateDenominators[rate]))
+		}
+		selected = append(selected,
+			"("+strings.Join(missing, " OR ")+") AS missing_denominator",
+		)
+		source = populationSource
+	}
+
+	if len(invalid) > 0 {
+		return "", InvalidParamsError(invalid)
+	}
+
+	// Create the GROUP BY part of an SQL query
+	group := "GROUP BY " + strings.Join(grouped, ", ")
+
+	if len(grouped) == 0 {
+		group = ""
+	}
+
+	// Create select string
+	var selects string
+	if len(selected) > 0 {
+		selects = strings.Join(selected, ", ") + ","
+	} else {
+		selects = ""
+	}
+
+	// Generate main query string
+	return fmt.Sprintf(
+		`SELECT %s count(outage_id) as total_outages FROM %s %s %s
+		%s`, selects, source, filter, group, order,
+	), nil
+}
+
+// GetOutageCounts returns the outages counted by the given parameters of
+// the count API. An InvalidParamsError is returned if any parameters are
+// invalid.
+func GetOutageCounts(db *sql.DB, params url.Values) ([]DBWaterOutage, error) {
+	main, err := MakeCountQuery(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assemble query and get data from database
+	rows, err := db.Query(main)
+	log.Println(main)
+
+	if err != nil {
+		logQueryError(err, main)
+		return nil, err
+	}
+	defer rows.Close()
+
+	outages, err := ScanOutages(rows)
+	if err != nil {
+		log.Println(err)
+	}
+	return outages, err
+}
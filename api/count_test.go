@@ -0,0 +1,76 @@
+// count_test.go contains tests that test count.go
+package api
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestMakeCountQueryRates calls api.MakeCountQuery and checks that rates
+// join the population of suburbs.
+func TestMakeCountQueryRates(t *testing.T) {
+	actual, err := MakeCountQuery(url.Values{
+		"get": {"suburb", "rate_per_1000_people"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"LEFT JOIN suburb_population",
+		"AS rate_per_1000_people",
+		"AS missing_denominator",
+		"GROUP BY suburb",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Fatalf(`TestMakeCountQueryRates did not contain %s, got %s`, expected, actual)
+		}
+	}
+}
+
+// TestMakeCountQueryRatesNeedSuburb calls api.MakeCountQuery and checks
+// that rates are rejected unless counts are grouped by suburb.
+func TestMakeCountQueryRatesNeedSuburb(t *testing.T) {
+	_, err := MakeCountQuery(url.Values{
+		"get": {"outage_type", "rate_per_1000_dwellings"},
+	})
+	if _, ok := err.(InvalidParamsError); !ok {
+		t.Fatalf(`TestMakeCountQueryRatesNeedSuburb did not return an InvalidParamsError, got %v`, err)
+	}
+}
+
+// TestMakeCountQueryWithoutRates calls api.MakeCountQuery and checks that
+// the population of suburbs is only joined for rates.
+func TestMakeCountQueryWithoutRates(t *testing.T) {
+	actual, err := MakeCountQuery(url.Values{"get": {"suburb"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(actual, "suburb_population") {
+		t.Fatalf(`TestMakeCountQueryWithoutRates joined the population, got %s`, actual)
+	}
+}
+
+// TestMakeCountQueryHourMetrics calls api.MakeCountQuery and checks that
+// statistics of hours use the selected duration model.
+func TestMakeCountQueryHourMetrics(t *testing.T) {
+	actual, err := MakeCountQuery(url.Values{
+		"get":            {"outage_type", "median_hours", "p90_hours", "stddev_hours"},
+		"duration_model": {"wall_clock"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"percentile_cont(0.5) WITHIN GROUP (ORDER BY outage_hours_wall_clock(start_date, end_date))::float AS median_hours",
+		"percentile_cont(0.9) WITHIN GROUP (ORDER BY outage_hours_wall_clock(start_date, end_date))::float AS p90_hours",
+		"stddev_samp(outage_hours_wall_clock(start_date, end_date))::float AS stddev_hours",
+		"GROUP BY outage_type",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Fatalf(`TestMakeCountQueryHourMetrics did not contain %s, got %s`, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,541 @@
+// digests.go contains the email digests of the outages matching a filter,
+// the job that sends them daily or weekly, and their controller
+// functions.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+const (
+	// DigestOutageLimit is the largest number of outages listed in a
+	// digest.
+	DigestOutageLimit = 20
+	// DigestPollInterval is the time between sending the digests that are
+	// due.
+	DigestPollInterval = 10 * time.Minute
+)
+
+// DigestFrequencies maps the frequencies of digests to the time between
+// them.
+var DigestFrequencies = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// A Digest struct maps an email address that is sent the outages matching
+// its filter daily or weekly, once the email address is confirmed. The
+// token, which is only emailed, confirms and unsubscribes the email
+// address.
+type Digest struct {
+	ID         int        `json:"id"`
+	Email      string     `json:"email"`
+	Frequency  string     `json:"frequency"`
+	Filter     url.Values `json:"filter"`
+	Confirmed  bool       `json:"confirmed"`
+	Token      string     `json:"-"`
+	LastSentAt time.Time  `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// A DigestData struct maps the content of a digest.
+type DigestData struct {
+	Frequency      string
+	Area           string
+	Since          string
+	TotalOutages   int
+	TotalHours     float64
+	Suburbs        []DBWaterOutage
+	Outages        []DBWaterOutage
+	MoreOutages    int
+	Upcoming       []DBWaterOutage
+	UnsubscribeURL string
+}
+
+// A DigestConfirmation struct maps the content of the email that confirms
+// the email address of a digest.
+type DigestConfirmation struct {
+	Frequency  string
+	Area       string
+	ConfirmURL string
+}
+
+// digestTemplate renders the subject and body of a digest.
+var digestTemplate = template.Must(template.New("digest").Parse(
+	`{{define "subject"}}Your {{.Frequency}} water outage digest{{with .Area}} for {{.}}{{end}}{{end}}` +
+		`{{define "body"}}Water outages{{with .Area}} in {{.}}{{end}} since {{.Since}}
+
+{{.TotalOutages}} outage{{if ne .TotalOutages 1}}s{{end}}, {{printf "%.1f" .TotalHours}} hours in total.
+{{if .Suburbs}}
+By suburb:
+{{range .Suburbs}}- {{.Suburb}}: {{.TotalOutages}} outage{{if ne .TotalOutages 1}}s{{end}}, {{printf "%.1f" .TotalHours}} hours
+{{end}}{{end}}{{if .Outages}}
+Outages:
+{{range .Outages}}- {{.OutageType}} outage — {{with .Street}}{{.}}, {{end}}{{.Suburb}}: {{.StartDate}}{{with .EndDate}} until {{.}}{{end}}
+{{end}}{{if .MoreOutages}}... and {{.MoreOutages}} more
+{{end}}{{end}}{{if .Upcoming}}
+Upcoming outages:
+{{range .Upcoming}}- {{.OutageType}} outage — {{with .Street}}{{.}}, {{end}}{{.Suburb}}: {{.StartDate}}{{with .EndDate}} until {{.}}{{end}}
+{{end}}{{end}}
+To unsubscribe, open {{.UnsubscribeURL}}
+{{end}}`,
+))
+
+// digestConfirmationTemplate renders the subject and body of the email
+// that confirms the email address of a digest.
+var digestConfirmationTemplate = template.Must(template.New("confirmation").Parse(
+	`{{define "subject"}}Confirm your {{.Frequency}} water outage digest{{with .Area}} for {{.}}{{end}}{{end}}` +
+		`{{define "body"}}You have been signed up to a {{.Frequency}} digest of water outages{{with .Area}} in {{.}}{{end}}.
+
+To start receiving it, open {{.ConfirmURL}}
+
+If you didn't sign up, ignore this email and no digests will be sent.
+{{end}}`,
+))
+
+// RenderDigest returns the subject and body of a digest.
+func RenderDigest(data DigestData) (subject, body string, err error) {
+	var subjectText, bodyText strings.Builder
+	if err = digestTemplate.ExecuteTemplate(&subjectText, "subject", data); err != nil {
+		return
+	}
+	err = digestTemplate.ExecuteTemplate(&bodyText, "body", data)
+	return subjectText.String(), bodyText.String(), err
+}
+
+// RenderDigestConfirmation returns the subject and body of the email that
+// confirms the email address of a digest.
+func RenderDigestConfirmation(data DigestConfirmation) (subject, body string, err error) {
+	var subjectText, bodyText strings.Builder
+	if err = digestConfirmationTemplate.ExecuteTemplate(&subjectText, "subject", data); err != nil {
+		return
+	}
+	err = digestConfirmationTemplate.ExecuteTemplate(&bodyText, "body", data)
+	return subjectText.String(), bodyText.String(), err
+}
+
+// DescribeFilter returns the streets and suburbs of a filter, such as
+// "Remuera Road, Remuera", or "" if there are none.
+func DescribeFilter(filter url.Values) string {
+	places := append(append([]string{}, filter["street"]...), filter["suburb"]...)
+	return strings.Join(places, ", ")
+}
+
+// MakeDigestParams returns the count API parameters of the outages of a
+// filter that started between since and now, counted by suburb.
+func MakeDigestParams(filter url.Values, since, now time.Time) url.Values {
+	params := MakeUpcomingParams(filter, since)
+	location := GetRegion(filter.Get("region")).Location()
+	params.Set("before_start_date", now.In(location).Format("2006-01-02T15:04:05"))
+	params["get"] = []string{"suburb", "total_hours"}
+	return params
+}
+
+// MakeUpcomingParams returns the parameters of the outages of a filter
+// that start after now, such as planned outages announced ahead.
+func MakeUpcomingParams(filter url.Values, now time.Time) url.Values {
+	params := url.Values{}
+	for param, values := range filter {
+		params[param] = values
+	}
+
+	location := GetRegion(filter.Get("region")).Location()
+	params.Set("after_start_date", now.In(location).Format("2006-01-02T15:04:05"))
+	return params
+}
+
+// IsEmailAddress returns true if a string is a single plain email
+// address.
+func IsEmailAddress(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+// publicURL returns the URL this app is reached at, read from the
+// PUBLIC_URL environmental variable.
+func publicURL() string {
+	if value := os.Getenv("PUBLIC_URL"); value != "" {
+		return strings.TrimSuffix(value, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// CheckPublicURL returns an error if emails are sent over SMTP but the
+// PUBLIC_URL environmental variable is not set, as the links in them
+// would point to localhost.
+func CheckPublicURL(mailer Mailer) error {
+	if _, ok := mailer.(SMTPMailer); ok && os.Getenv("PUBLIC_URL") == "" {
+		return errors.New("PUBLIC_URL must be set to send emails over SMTP")
+	}
+	return nil
+}
+
+// MakeDigest returns the content of a digest of the outages that started
+// since it was last sent (or created) until now, and of the outages that
+// start after now.
+func MakeDigest(db *sql.DB, digest Digest, now time.Time) (DigestData, error) {
+	since := digest.LastSentAt
+	if since.IsZero() {
+		since = digest.CreatedAt
+	}
+	params := MakeDigestParams(digest.Filter, since, now)
+	location := GetRegion(digest.Filter.Get("region")).Location()
+
+	data := DigestData{
+		Frequency: digest.Frequency,
+		Area:      DescribeFilter(digest.Filter),
+		Since:     since.In(location).Format("Mon 2 Jan 2006 15:04"),
+		UnsubscribeURL: publicURL() + "/digests/unsubscribe?token=" +
+			url.QueryEscape(digest.Token),
+	}
+
+	// Summarise the outages by the count API
+	suburbs, err := GetOutageCounts(db, params)
+	if err != nil {
+		return data, err
+	}
+	for _, suburb := range suburbs {
+		data.TotalOutages += suburb.TotalOutages
+		data.TotalHours += suburb.TotalHours
+	}
+	sort.SliceStable(suburbs, func(i, j int) bool {
+		return suburbs[i].TotalOutages > suburbs[j].TotalOutages
+	})
+	data.Suburbs = suburbs
+
+	// List the first outages
+	outages, err := listDigestOutages(db, params)
+	if err != nil {
+		return data, err
+	}
+	data.Outages = outages
+	if data.TotalOutages > len(outages) {
+		data.MoreOutages = data.TotalOutages - len(outages)
+	}
+
+	// List the first upcoming outages, apart from those that started
+	data.Upcoming, err = listDigestOutages(db, MakeUpcomingParams(digest.Filter, now))
+	return data, err
+}
+
+// listDigestOutages returns the first outages matching params by start
+// date, with their dates formatted for a digest.
+func listDigestOutages(db *sql.DB, params url.Values) ([]DBWaterOutage, error) {
+	query := &Query{IsCount: true}
+	main := `SELECT ` + OutageColumns + ` FROM outage` +
+		query.MakeWhereString(params) +
+		fmt.Sprintf(` ORDER BY start_date, outage_id LIMIT %d`, DigestOutageLimit)
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		return nil, err
+	}
+	defer rows.Close()
+
+	outages, err := ScanOutages(rows)
+	if err != nil {
+		return outages, err
+	}
+	for i := range outages {
+		region := GetRegion(outages[i].Region)
+		for _, date := range []*string{&outages[i].StartDate, &outages[i].EndDate} {
+			if local, ok := region.ParseOutageTime(*date); ok {
+				*date = local.Format("Mon 2 Jan 15:04")
+			}
+		}
+	}
+	return outages, nil
+}
+
+// SendDigest emails a digest, and saves when it was sent.
+func SendDigest(db *sql.DB, mailer Mailer, digest Digest, now time.Time) error {
+	data, err := MakeDigest(db, digest, now)
+	if err != nil {
+		return err
+	}
+
+	subject, body, err := RenderDigest(data)
+	if err != nil {
+		return err
+	}
+
+	if err := mailer.Send(MailMessage{
+		To: digest.Email, Subject: subject, Body: body,
+	}); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(
+		`UPDATE digest_subscription SET last_sent_at = $2 WHERE id = $1`,
+		digest.ID, now,
+	)
+	return err
+}
+
+// SendDueDigests sends the confirmed digests whose daily or weekly period
+// has passed since they were last sent (or created).
+func SendDueDigests() {
+	// Open database
+	db := database.DB()
+
+	rows, err := db.Query(
+		`SELECT id, email, frequency, filter, token, last_sent_at, created_at
+		FROM digest_subscription WHERE confirmed_at IS NOT NULL ORDER BY id`,
+	)
+	if err != nil {
+		log.Println("Getting digests failed:", err)
+		return
+	}
+
+	var digests []Digest
+	for rows.Next() {
+		var digest Digest
+		var filter string
+		var lastSentAt sql.NullTime
+		if err := rows.Scan(&digest.ID, &digest.Email, &digest.Frequency,
+			&filter, &digest.Token, &lastSentAt, &digest.CreatedAt); err != nil {
+			log.Println("Getting digests failed:", err)
+			rows.Close()
+			return
+		}
+		digest.LastSentAt = lastSentAt.Time
+		if digest.Filter, err = url.ParseQuery(filter); err != nil {
+			log.Println("Getting digest", digest.ID, "failed:", err)
+			continue
+		}
+		digests = append(digests, digest)
+	}
+	rows.Close()
+
+	mailer := NewMailer()
+	if err := CheckPublicURL(mailer); err != nil {
+		log.Println("Sending digests failed:", err)
+		return
+	}
+	now, sent := time.Now(), 0
+	for _, digest := range digests {
+		if !IsDigestDue(digest, now) {
+			continue
+		}
+		if err := SendDigest(db, mailer, digest, now); err != nil {
+			log.Println("Sending digest", digest.ID, "failed:", err)
+			continue
+		}
+		sent++
+	}
+
+	log.Printf("Digests have been sent (%d sent).", sent)
+}
+
+// RunDigests sends the digests that are due every DigestPollInterval, and
+// never returns. Digests are sent apart from ingests so a slow SMTP server
+// doesn't hold them up.
+func RunDigests() {
+	for {
+		SendDueDigests()
+		<-time.After(DigestPollInterval)
+	}
+}
+
+// IsDigestDue returns true if the period of a digest has passed since it
+// was last sent (or created).
+func IsDigestDue(digest Digest, now time.Time) bool {
+	last := digest.LastSentAt
+	if last.IsZero() {
+		last = digest.CreatedAt
+	}
+	return !now.Before(last.Add(DigestFrequencies[digest.Frequency]))
+}
+
+// CreateDigest registers an email address that is sent a daily or weekly
+// (the frequency parameter, defaults to weekly) digest of the outages
+// matching the suburb, street, outage_type, region and
+// longitude/latitude/radius parameters. The email address is sent a link
+// to /digests/confirm, and no digests are sent until it is opened.
+func CreateDigest(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received CreateDigest request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := GetRequestParams(r)
+	digest := Digest{
+		Email:     strings.TrimSpace(params.Get("email")),
+		Frequency: strings.ToLower(params.Get("frequency")),
+	}
+	if digest.Frequency == "" {
+		digest.Frequency = "weekly"
+	}
+
+	var invalid []string
+	if !IsEmailAddress(digest.Email) {
+		invalid = append(invalid, "email")
+	}
+	if _, ok := DigestFrequencies[digest.Frequency]; !ok {
+		invalid = append(invalid, "frequency")
+	}
+	filter, invalidFilters := MakeSubscriptionFilter(params)
+	digest.Filter = filter
+	if invalid = append(invalid, invalidFilters...); len(invalid) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(invalid, ", ") + ".",
+		})
+		return
+	}
+
+	token, err := RandomToken(32)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3515,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	digest.Token = token
+
+	// Setup the database
+	db := database.DB()
+
+	err = db.QueryRow(
+		`INSERT INTO digest_subscription (email, frequency, filter, token)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		digest.Email, digest.Frequency, filter.Encode(), digest.Token,
+	).Scan(&digest.ID, &digest.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3515,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	// Email the confirmation link, or forget the digest if it can't be
+	// sent so the email address can sign up again
+	mailer := NewMailer()
+	subject, body, err := RenderDigestConfirmation(DigestConfirmation{
+		Frequency: digest.Frequency,
+		Area:      DescribeFilter(digest.Filter),
+		ConfirmURL: publicURL() + "/digests/confirm?token=" +
+			url.QueryEscape(digest.Token),
+	})
+	if err == nil {
+		err = CheckPublicURL(mailer)
+	}
+	if err == nil {
+		err = mailer.Send(MailMessage{To: digest.Email, Subject: subject, Body: body})
+	}
+	if err != nil {
+		log.Println("Sending digest confirmation", digest.ID, "failed:", err)
+		if _, err := db.Exec(
+			`DELETE FROM digest_subscription WHERE id = $1`, digest.ID,
+		); err != nil {
+			log.Println(err)
+		}
+		WriteAppError(w, http.StatusBadGateway, AppError{
+			ErrorCode: 3518,
+			Message:   "confirmation not sent",
+			Details:   "The email confirming this digest could not be sent. Please try again later.",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(digest)
+}
+
+// ConfirmDigest confirms the digest of the token parameter, as linked to
+// from the email sent when it was created, so it starts being sent.
+func ConfirmDigest(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received ConfirmDigest request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Setup the database
+	db := database.DB()
+
+	result, err := db.Exec(
+		`UPDATE digest_subscription SET confirmed_at = COALESCE(confirmed_at, CURRENT_TIMESTAMP)
+		WHERE token = $1`,
+		r.URL.Query().Get("token"),
+	)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3519,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		WriteAppError(w, http.StatusNotFound, AppError{
+			ErrorCode: 3517,
+			Message:   "digest not found",
+			Details:   "There is no digest with this token, or it was already unsubscribed.",
+		})
+		return
+	}
+
+	WriteJSON(w, map[string]string{"Message": "Your digest has been confirmed."})
+}
+
+// UnsubscribeDigest deletes the digest of the token parameter, as linked
+// to from each digest.
+func UnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received UnsubscribeDigest request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Setup the database
+	db := database.DB()
+
+	result, err := db.Exec(
+		`DELETE FROM digest_subscription WHERE token = $1`,
+		r.URL.Query().Get("token"),
+	)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3516,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		WriteAppError(w, http.StatusNotFound, AppError{
+			ErrorCode: 3517,
+			Message:   "digest not found",
+			Details:   "There is no digest with this token, or it was already unsubscribed.",
+		})
+		return
+	}
+
+	WriteJSON(w, map[string]string{"Message": "You have been unsubscribed."})
+}
@@ -0,0 +1,177 @@
+// digests_test.go contains tests that test digests.go
+package api
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRenderDigest calls api.RenderDigest and checks the subject and the
+// summary, suburbs, outages and upcoming outages of the body.
+func TestRenderDigest(t *testing.T) {
+	data := DigestData{
+		Frequency:    "weekly",
+		Area:         "Remuera",
+		Since:        "Wed 15 Jun 2022 09:00",
+		TotalOutages: 3,
+		TotalHours:   7.25,
+		Suburbs: []DBWaterOutage{
+			{Suburb: "Remuera", TotalOutages: 3, TotalHours: 7.25},
+		},
+		Outages: []DBWaterOutage{
+			{OutageType: "Planned", Street: "Remuera Road", Suburb: "Remuera",
+				StartDate: "Mon 20 Jun 09:00", EndDate: "Mon 20 Jun 15:00"},
+			{OutageType: "Unplanned", Suburb: "Remuera", StartDate: "Tue 21 Jun 10:00"},
+		},
+		MoreOutages: 1,
+		Upcoming: []DBWaterOutage{
+			{OutageType: "Planned", Suburb: "Remuera", StartDate: "Mon 4 Jul 09:00"},
+		},
+		UnsubscribeURL: "https://example.com/digests/unsubscribe?token=abc",
+	}
+
+	subject, body, err := RenderDigest(data)
+	if err != nil || subject != "Your weekly water outage digest for Remuera" {
+		t.Fatalf(`TestRenderDigest did not return the subject got %v (%v)`, subject, err)
+	}
+
+	expected := []string{
+		"Water outages in Remuera since Wed 15 Jun 2022 09:00\n",
+		"3 outages, 7.2 hours in total.\n",
+		"- Remuera: 3 outages, 7.2 hours\n",
+		"- Planned outage — Remuera Road, Remuera: Mon 20 Jun 09:00 until Mon 20 Jun 15:00\n",
+		"- Unplanned outage — Remuera: Tue 21 Jun 10:00\n",
+		"... and 1 more\n",
+		"Upcoming outages:\n- Planned outage — Remuera: Mon 4 Jul 09:00\n",
+		"To unsubscribe, open https://example.com/digests/unsubscribe?token=abc\n",
+	}
+	for _, part := range expected {
+		if !strings.Contains(body, part) {
+			t.Fatalf(`TestRenderDigest did not return %q got %q`, part, body)
+		}
+	}
+}
+
+// TestMakeDigestParams calls api.MakeDigestParams and checks that the
+// outages since the last digest until now are counted by suburb in local
+// time.
+func TestMakeDigestParams(t *testing.T) {
+	filter := url.Values{"suburb": {"Remuera"}}
+	since := time.Date(2022, 6, 14, 21, 0, 0, 0, time.UTC)
+	now := since.AddDate(0, 0, 7)
+
+	actual := MakeDigestParams(filter, since, now)
+	expected := "after_start_date=2022-06-15T09%3A00%3A00&before_start_date=2022-06-22T09%3A00%3A00" +
+		"&get=suburb&get=total_hours&suburb=Remuera"
+
+	if actual.Encode() != expected || len(filter) != 1 {
+		t.Fatalf(`TestMakeDigestParams did not return %v got %v`, expected, actual.Encode())
+	}
+}
+
+// TestMakeUpcomingParams calls api.MakeUpcomingParams and checks that the
+// outages starting after now are matched in local time.
+func TestMakeUpcomingParams(t *testing.T) {
+	filter := url.Values{"suburb": {"Remuera"}}
+	now := time.Date(2022, 6, 21, 21, 0, 0, 0, time.UTC)
+
+	actual := MakeUpcomingParams(filter, now)
+	expected := "after_start_date=2022-06-22T09%3A00%3A00&suburb=Remuera"
+
+	if actual.Encode() != expected || len(filter) != 1 {
+		t.Fatalf(`TestMakeUpcomingParams did not return %v got %v`, expected, actual.Encode())
+	}
+}
+
+// TestRenderDigestConfirmation calls api.RenderDigestConfirmation and
+// checks the subject and the confirmation link of the body.
+func TestRenderDigestConfirmation(t *testing.T) {
+	subject, body, err := RenderDigestConfirmation(DigestConfirmation{
+		Frequency:  "daily",
+		Area:       "Remuera",
+		ConfirmURL: "https://example.com/digests/confirm?token=abc",
+	})
+
+	expected := "Confirm your daily water outage digest for Remuera"
+	if err != nil || subject != expected {
+		t.Fatalf(`TestRenderDigestConfirmation did not return %v got %v (%v)`,
+			expected, subject, err)
+	}
+	if !strings.Contains(body, "To start receiving it, open https://example.com/digests/confirm?token=abc\n") {
+		t.Fatalf(`TestRenderDigestConfirmation did not return the link got %q`, body)
+	}
+}
+
+// TestIsDigestDue calls api.IsDigestDue and checks that digests are due
+// once their period has passed since they were last sent or created.
+func TestIsDigestDue(t *testing.T) {
+	now := time.Date(2022, 6, 22, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		digest   Digest
+		expected bool
+	}{
+		{Digest{Frequency: "daily", CreatedAt: now.Add(-25 * time.Hour)}, true},
+		{Digest{Frequency: "weekly", CreatedAt: now.Add(-25 * time.Hour)}, false},
+		{Digest{Frequency: "weekly", CreatedAt: now.AddDate(0, 0, -30),
+			LastSentAt: now.AddDate(0, 0, -3)}, false},
+		{Digest{Frequency: "weekly", CreatedAt: now.AddDate(0, 0, -30),
+			LastSentAt: now.AddDate(0, 0, -7)}, true},
+	}
+
+	for _, test := range tests {
+		if actual := IsDigestDue(test.digest, now); actual != test.expected {
+			t.Fatalf(`TestIsDigestDue did not return %v for %+v got %v`,
+				test.expected, test.digest, actual)
+		}
+	}
+}
+
+// TestIsEmailAddress calls api.IsEmailAddress and checks that only plain
+// email addresses are valid.
+func TestIsEmailAddress(t *testing.T) {
+	tests := map[string]bool{
+		"ops@example.com":       true,
+		"Ops <ops@example.com>": false,
+		"ops":                   false,
+		"":                      false,
+	}
+
+	for email, expected := range tests {
+		if actual := IsEmailAddress(email); actual != expected {
+			t.Fatalf(`TestIsEmailAddress did not return %v for %q got %v`,
+				expected, email, actual)
+		}
+	}
+}
+
+// TestCheckPublicURL calls api.CheckPublicURL and checks that only SMTP
+// mailers need the PUBLIC_URL environmental variable.
+func TestCheckPublicURL(t *testing.T) {
+	previous := os.Getenv("PUBLIC_URL")
+	defer os.Setenv("PUBLIC_URL", previous)
+
+	tests := []struct {
+		mailer    Mailer
+		publicURL string
+		expected  bool
+	}{
+		{SMTPMailer{Host: "localhost"}, "", false},
+		{SMTPMailer{Host: "localhost"}, "https://example.com", true},
+		{LogMailer{}, "", true},
+		{&FileMailer{Path: "digests.txt"}, "", true},
+	}
+
+	for _, test := range tests {
+		os.Setenv("PUBLIC_URL", test.publicURL)
+		actual := CheckPublicURL(test.mailer) == nil
+		if actual != test.expected {
+			t.Fatalf(
+				`TestCheckPublicURL did not return %v for %T with %q got %v`,
+				test.expected, test.mailer, test.publicURL, actual,
+			)
+		}
+	}
+}
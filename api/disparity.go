@@ -0,0 +1,347 @@
+// disparity.go creates the controller function of the disparity report,
+// which compares the outage rate & hours of each suburb with the rest of
+// its region.
+package api
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// areaParams are the filter parameters that only count outages in part
+// of a region, so suburbs without outages are not added to reports.
+var areaParams = []string{
+	"suburb", "street", "location", "search", "longitude", "latitude",
+	"bbox", "within", "local_board", "ward",
+}
+
+// A SuburbDisparity struct maps the outage rate & hours of a suburb
+// compared with the rate & hours of its region.
+type SuburbDisparity struct {
+	Suburb          string  `json:"suburb"`
+	Rank            int     `json:"rank"`
+	HoursRank       int     `json:"hours_rank"`
+	Outages         int     `json:"outages"`
+	ExpectedOutages float64 `json:"expected_outages"`
+	Denominator     int64   `json:"denominator"`
+	Rate            float64 `json:"rate_per_1000"`
+	RateLower       float64 `json:"rate_per_1000_lower"`
+	RateUpper       float64 `json:"rate_per_1000_upper"`
+	RateRatio       float64 `json:"rate_ratio"`
+	PValue          float64 `json:"p_value"`
+	AdjustedPValue  float64 `json:"adjusted_p_value"`
+	Significant     bool    `json:"significant"`
+	TotalHours      float64 `json:"total_hours"`
+	HoursRate       float64 `json:"hours_per_1000"`
+	HoursRatio      float64 `json:"hours_ratio"`
+}
+
+// A ChiSquareResult struct maps a chi-square test.
+type ChiSquareResult struct {
+	Statistic        float64 `json:"statistic"`
+	DegreesOfFreedom int     `json:"degrees_of_freedom"`
+	PValue           float64 `json:"p_value"`
+}
+
+// A DisparityReport struct maps the comparison of the suburbs of a
+// region. Rates are per 1000 people or dwellings (the denominator).
+// Suburbs with outages but without a known denominator are listed in
+// MissingDenominator and left out of the comparison.
+type DisparityReport struct {
+	Region             string            `json:"region"`
+	Denominator        string            `json:"denominator"`
+	Confidence         float64           `json:"confidence"`
+	TotalOutages       int               `json:"total_outages"`
+	TotalHours         float64           `json:"total_hours"`
+	TotalDenominator   int64             `json:"total_denominator"`
+	RegionalRate       float64           `json:"regional_rate_per_1000"`
+	RegionalHoursRate  float64           `json:"regional_hours_per_1000"`
+	ChiSquare          ChiSquareResult   `json:"chi_square"`
+	Suburbs            []SuburbDisparity `json:"suburbs"`
+	MissingDenominator []string          `json:"missing_denominator"`
+}
+
+// MakeDisparityReport compares the counts of the count API (grouped by
+// suburb, with total hours and a rate) with the rates expected if every
+// person or dwelling of the region had the same risk of an outage. The
+// populations are suburbs without outages that are added with a count of
+// 0. Each suburb is tested with a Poisson test, whose p-value is
+// Bonferroni-adjusted for the number of suburbs, and all suburbs are
+// tested together with a chi-square test.
+func MakeDisparityReport(counts []DBWaterOutage, populations []SuburbPopulation,
+	denominator string, confidence float64) DisparityReport {
+	report := DisparityReport{
+		Denominator:        denominator,
+		Confidence:         confidence,
+		Suburbs:            []SuburbDisparity{},
+		MissingDenominator: []string{},
+	}
+
+	// Map each suburb to its count & denominator
+	seen := map[string]bool{}
+	for _, count := range counts {
+		seen[strings.ToLower(count.Suburb)] = true
+
+		value := count.Population
+		if denominator == "dwellings" {
+			value = count.Dwellings
+		}
+		if value == nil || *value <= 0 {
+			report.MissingDenominator = append(report.MissingDenominator, count.Suburb)
+			continue
+		}
+
+		report.Suburbs = append(report.Suburbs, SuburbDisparity{
+			Suburb:      count.Suburb,
+			Outages:     count.TotalOutages,
+			Denominator: *value,
+			TotalHours:  count.TotalHours,
+		})
+	}
+
+	for _, population := range populations {
+		value := population.Population
+		if denominator == "dwellings" {
+			value = population.Dwellings
+		}
+		if seen[strings.ToLower(population.Suburb)] || !value.Valid || value.Int64 <= 0 {
+			continue
+		}
+		report.Suburbs = append(report.Suburbs, SuburbDisparity{
+			Suburb:      population.Suburb,
+			Denominator: value.Int64,
+		})
+	}
+
+	// Regional rates
+	for _, suburb := range report.Suburbs {
+		report.TotalOutages += suburb.Outages
+		report.TotalHours += suburb.TotalHours
+		report.TotalDenominator += suburb.Denominator
+	}
+	if report.TotalDenominator == 0 {
+		return report
+	}
+	total := float64(report.TotalDenominator)
+	report.RegionalRate = float64(report.TotalOutages) * 1000 / total
+	report.RegionalHoursRate = report.TotalHours * 1000 / total
+
+	// Compare each suburb with the region
+	observed := make([]float64, len(report.Suburbs))
+	expected := make([]float64, len(report.Suburbs))
+	for i := range report.Suburbs {
+		suburb := &report.Suburbs[i]
+		share := float64(suburb.Denominator) / total
+		per1000 := 1000 / float64(suburb.Denominator)
+
+		suburb.ExpectedOutages = float64(report.TotalOutages) * share
+		suburb.Rate = float64(suburb.Outages) * per1000
+		lower, upper := PoissonCI(suburb.Outages, confidence)
+		suburb.RateLower, suburb.RateUpper = lower*per1000, upper*per1000
+		suburb.HoursRate = suburb.TotalHours * per1000
+
+		if report.RegionalRate > 0 {
+			suburb.RateRatio = suburb.Rate / report.RegionalRate
+		}
+		if report.RegionalHoursRate > 0 {
+			suburb.HoursRatio = suburb.HoursRate / report.RegionalHoursRate
+		}
+
+		suburb.PValue = PoissonTest(suburb.Outages, suburb.ExpectedOutages)
+		suburb.AdjustedPValue = suburb.PValue * float64(len(report.Suburbs))
+		if suburb.AdjustedPValue > 1 {
+			suburb.AdjustedPValue = 1
+		}
+		suburb.Significant = suburb.AdjustedPValue < 1-confidence
+
+		observed[i], expected[i] = float64(suburb.Outages), suburb.ExpectedOutages
+	}
+
+	report.ChiSquare.Statistic, report.ChiSquare.DegreesOfFreedom,
+		report.ChiSquare.PValue = ChiSquareTest(observed, expected)
+
+	// Rank suburbs by hours, then by rate (highest first), with equal
+	// values sharing a rank
+	rankSuburbs(report.Suburbs, func(s SuburbDisparity) float64 { return s.HoursRate },
+		func(s *SuburbDisparity, rank int) { s.HoursRank = rank })
+	rankSuburbs(report.Suburbs, func(s SuburbDisparity) float64 { return s.Rate },
+		func(s *SuburbDisparity, rank int) { s.Rank = rank })
+
+	return report
+}
+
+// rankSuburbs sorts suburbs by a value (highest first) and sets their
+// rank, with equal values sharing a rank.
+func rankSuburbs(suburbs []SuburbDisparity, value func(SuburbDisparity) float64,
+	setRank func(*SuburbDisparity, int)) {
+	sort.SliceStable(suburbs, func(i, j int) bool {
+		if value(suburbs[i]) != value(suburbs[j]) {
+			return value(suburbs[i]) > value(suburbs[j])
+		}
+		return suburbs[i].Suburb < suburbs[j].Suburb
+	})
+
+	rank := 0
+	for i := range suburbs {
+		if i == 0 || value(suburbs[i]) != value(suburbs[i-1]) {
+			rank = i + 1
+		}
+		setRank(&suburbs[i], rank)
+	}
+}
+
+// disparityTemplate renders a DisparityReport as an HTML page.
+var disparityTemplate = template.Must(template.New("disparity").Funcs(template.FuncMap{
+	"percent": func(value float64) string {
+		return strconv.FormatFloat(value*100, 'f', -1, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Outage disparity report: {{.Region}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; text-align: right; }
+th:nth-child(2), td:nth-child(2) { text-align: left; }
+tr.significant { background: #fdecea; }
+</style>
+</head>
+<body>
+<h1>Outage disparity report: {{.Region}}</h1>
+<p>
+{{.TotalOutages}} outages and {{printf "%.1f" .TotalHours}} hours in a region of
+{{.TotalDenominator}} {{.Denominator}}: {{printf "%.2f" .RegionalRate}} outages and
+{{printf "%.1f" .RegionalHoursRate}} hours per 1000 {{.Denominator}}.
+</p>
+<p>
+Chi-square test against the same risk for every suburb:
+&chi;&sup2; = {{printf "%.2f" .ChiSquare.Statistic}},
+df = {{.ChiSquare.DegreesOfFreedom}}, p = {{printf "%.4g" .ChiSquare.PValue}}.
+Highlighted suburbs differ from the region at a {{percent .Confidence}}% confidence
+level (Bonferroni-adjusted Poisson test).
+</p>
+<table>
+<thead>
+<tr>
+<th>Rank</th><th>Suburb</th><th>Outages</th><th>Expected</th><th>{{.Denominator}}</th>
+<th>Rate per 1000</th><th>Confidence interval</th><th>Rate ratio</th>
+<th>Adjusted p</th><th>Hours</th><th>Hours per 1000</th><th>Hours rank</th>
+</tr>
+</thead>
+<tbody>
+{{range .Suburbs}}<tr{{if .Significant}} class="significant"{{end}}>
+<td>{{.Rank}}</td><td>{{.Suburb}}</td><td>{{.Outages}}</td>
+<td>{{printf "%.1f" .ExpectedOutages}}</td><td>{{.Denominator}}</td>
+<td>{{printf "%.2f" .Rate}}</td>
+<td>{{printf "%.2f" .RateLower}} &ndash; {{printf "%.2f" .RateUpper}}</td>
+<td>{{printf "%.2f" .RateRatio}}</td><td>{{printf "%.3g" .AdjustedPValue}}</td>
+<td>{{printf "%.1f" .TotalHours}}</td><td>{{printf "%.1f" .HoursRate}}</td>
+<td>{{.HoursRank}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+{{if .MissingDenominator}}<p>Suburbs without a known number of {{.Denominator}}:
+{{range $i, $suburb := .MissingDenominator}}{{if $i}}, {{end}}{{$suburb}}{{end}}.</p>{{end}}
+</body>
+</html>
+`))
+
+// GetDisparityReport JSON-encodes the disparity report of the suburbs of
+// a region, or renders it as HTML if the format parameter is html.
+func GetDisparityReport(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetDisparityReport request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := GetRequestParams(r)
+	region := GetRegion(strings.ToLower(params.Get("region")))
+
+	denominator := params.Get("per")
+	if denominator == "" {
+		denominator = "people"
+	}
+	confidence := 0.95
+	if value := params.Get("confidence"); value != "" {
+		confidence, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if (denominator != "people" && denominator != "dwellings") ||
+		confidence <= 0 || confidence >= 1 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3475,
+			Message:   "invalid report",
+			Details:   "The per parameter must be people or dwellings, and the confidence between 0 and 1.",
+		})
+		return
+	}
+
+	// Count the outages of each suburb of the region
+	countParams := url.Values{}
+	for key, values := range params {
+		if key != "get" && key != "per" && key != "confidence" && key != "format" {
+			countParams[key] = values
+		}
+	}
+	countParams.Set("region", region.Name)
+	countParams["get"] = []string{"suburb", "total_hours", "rate_per_1000_" + denominator}
+
+	// Setup the database
+	db := database.DB()
+
+	counts, err := GetOutageCounts(db, countParams)
+	if invalid, ok := err.(InvalidParamsError); ok {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid: " + invalid.Error() + ".",
+		})
+		return
+	}
+
+	// Suburbs without outages are only added if the whole region is counted
+	var populations []SuburbPopulation
+	if err == nil {
+		wholeRegion := true
+		for _, param := range areaParams {
+			if _, ok := params[param]; ok {
+				wholeRegion = false
+			}
+		}
+		if wholeRegion {
+			populations, err = GetSuburbPopulations(db, region.Name)
+		}
+	}
+
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3476,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	report := MakeDisparityReport(counts, populations, denominator, confidence)
+	report.Region = region.Name
+
+	if params.Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := disparityTemplate.Execute(w, report); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	WriteJSON(w, report)
+}
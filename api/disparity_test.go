@@ -0,0 +1,75 @@
+// disparity_test.go contains tests that test disparity.go
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// TestMakeDisparityReport calls api.MakeDisparityReport and checks the
+// regional rate, the comparison & ranks of suburbs, and that suburbs
+// without a population are listed separately.
+func TestMakeDisparityReport(t *testing.T) {
+	population := func(value int64) *int64 { return &value }
+
+	counts := []DBWaterOutage{
+		{Suburb: "Ponsonby", TotalOutages: 30, TotalHours: 60, Population: population(1000)},
+		{Suburb: "Remuera", TotalOutages: 10, TotalHours: 40, Population: population(1000)},
+		{Suburb: "Unknown", TotalOutages: 5},
+	}
+	populations := []SuburbPopulation{
+		{Suburb: "Ponsonby", Population: sql.NullInt64{Int64: 1000, Valid: true}},
+		{Suburb: "Parnell", Population: sql.NullInt64{Int64: 2000, Valid: true}},
+		{Suburb: "Epsom"},
+	}
+
+	actual := MakeDisparityReport(counts, populations, "people", 0.95)
+
+	if actual.TotalOutages != 40 || actual.TotalDenominator != 4000 || actual.RegionalRate != 10 {
+		t.Fatalf(`TestMakeDisparityReport did not return 40 outages of 4000 people got %+v`, actual)
+	}
+	if strings.Join(actual.MissingDenominator, ",") != "Unknown" {
+		t.Fatalf(`TestMakeDisparityReport did not return missing Unknown got %v`,
+			actual.MissingDenominator)
+	}
+	if actual.ChiSquare.DegreesOfFreedom != 2 {
+		t.Fatalf(`TestMakeDisparityReport did not return 2 degrees of freedom got %v`,
+			actual.ChiSquare.DegreesOfFreedom)
+	}
+
+	var suburbs []string
+	for _, suburb := range actual.Suburbs {
+		suburbs = append(suburbs, suburb.Suburb)
+	}
+	if strings.Join(suburbs, ",") != "Ponsonby,Remuera,Parnell" {
+		t.Fatalf(`TestMakeDisparityReport did not rank Ponsonby, Remuera, Parnell got %v`, suburbs)
+	}
+
+	ponsonby := actual.Suburbs[0]
+	if ponsonby.Rank != 1 || ponsonby.HoursRank != 1 || ponsonby.ExpectedOutages != 10 ||
+		ponsonby.RateRatio != 3 || !ponsonby.Significant ||
+		ponsonby.RateLower >= 30 || ponsonby.RateUpper <= 30 {
+		t.Fatalf(`TestMakeDisparityReport did not compare Ponsonby got %+v`, ponsonby)
+	}
+	if parnell := actual.Suburbs[2]; parnell.Rank != 3 || parnell.Outages != 0 {
+		t.Fatalf(`TestMakeDisparityReport did not add Parnell without outages got %+v`, parnell)
+	}
+}
+
+// TestDisparityTemplate renders a report with api.disparityTemplate and
+// checks that suburbs are escaped.
+func TestDisparityTemplate(t *testing.T) {
+	report := MakeDisparityReport([]DBWaterOutage{{
+		Suburb: "O'Neills <Bay>", TotalOutages: 1, Population: new(int64),
+	}}, nil, "people", 0.95)
+
+	var html bytes.Buffer
+	if err := disparityTemplate.Execute(&html, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), "O&#39;Neills &lt;Bay&gt;") {
+		t.Fatalf(`TestDisparityTemplate did not escape the suburb, got %s`, html.String())
+	}
+}
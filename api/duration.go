@@ -0,0 +1,121 @@
+// duration.go contains the models that count the hours of an outage,
+// selected by the duration_model parameter. Each model is an SQL function
+// created by the database migrations.
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A DurationModel struct maps a named way of counting the hours of an
+// outage.
+type DurationModel struct {
+	Name        string
+	Description string
+}
+
+// DurationModels lists all duration models.
+var DurationModels = []DurationModel{
+	{
+		Name:        "wall_clock",
+		Description: "Hours between the start and end date.",
+	},
+	{
+		Name: "business_hours",
+		Description: "Hours between the start and end date that fall on weekdays " +
+			"between " + BusinessDayStart + " and " + BusinessDayEnd + " (local time).",
+	},
+	{
+		Name: "planned_factor",
+		Description: "Planned outages that last multiple days count a number of hours " +
+			"per day (planned_daily_hours, or the default of their region), as planned " +
+			"work usually only takes place during the day. Other outages count their " +
+			"wall-clock hours.",
+	},
+}
+
+// DefaultDurationModel is the duration model used if none is given.
+const DefaultDurationModel = "planned_factor"
+
+// BusinessDayStart and BusinessDayEnd are the local times between which
+// hours count towards the business_hours model.
+const (
+	BusinessDayStart = "08:00"
+	BusinessDayEnd   = "17:00"
+)
+
+// IsDurationModel returns true if a duration model exists.
+func IsDurationModel(name string) bool {
+	for _, model := range DurationModels {
+		if model.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DurationHoursSQL returns an SQL expression with the hours of an outage
+// by the given duration model. For the planned_factor model, a negative
+// plannedDailyHours uses the PlannedDailyHours of the region of each
+// outage.
+func DurationHoursSQL(model string, plannedDailyHours float64) string {
+	switch model {
+	case "wall_clock":
+		return "outage_hours_wall_clock(start_date, end_date)"
+	case "business_hours":
+		return fmt.Sprintf(
+			"outage_hours_business(start_date, end_date, '%s', '%s')",
+			BusinessDayStart, BusinessDayEnd,
+		)
+	}
+
+	factor := strconv.FormatFloat(plannedDailyHours, 'g', -1, 64)
+	if plannedDailyHours < 0 {
+		var factors []string
+		for _, name := range RegionNames() {
+			if hours := Regions[name].PlannedDailyHours; hours > 0 {
+				factors = append(factors, fmt.Sprintf("WHEN '%s' THEN %g", name, hours))
+			}
+		}
+
+		factor = "0"
+		if len(factors) > 0 {
+			factor = "CASE region " + strings.Join(factors, " ") + " ELSE 0 END"
+		}
+	}
+
+	return fmt.Sprintf(
+		"outage_hours_planned_factor(start_date, end_date, outage_type, %s)", factor,
+	)
+}
+
+// SetDurationModel sets the SQL expression of the hours of an outage to
+// *Query.Hours from the duration_model and planned_daily_hours
+// parameters. Unless the query is a count, the hours are added to
+// *Query.Selects as duration_hours.
+func (query *Query) SetDurationModel(model, plannedDailyHours string) {
+	if model == "" {
+		model = DefaultDurationModel
+	}
+	if !IsDurationModel(model) {
+		query.InvalidParams = append(query.InvalidParams, "duration_model")
+		model = DefaultDurationModel
+	}
+
+	hours := -1.0
+	if plannedDailyHours != "" {
+		var err error
+		hours, err = strconv.ParseFloat(plannedDailyHours, 64)
+		if err != nil || hours < 0 || hours > 24 {
+			query.InvalidParams = append(query.InvalidParams, "planned_daily_hours")
+			hours = -1
+		}
+	}
+
+	query.Hours = DurationHoursSQL(model, hours)
+	if !query.IsCount {
+		query.Selects = append(query.Selects, query.Hours+" AS duration_hours")
+	}
+}
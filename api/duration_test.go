@@ -0,0 +1,52 @@
+// duration_test.go contains tests that test duration.go
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDurationHoursSQL calls api.DurationHoursSQL and checks that the
+// planned daily hours are only applied to regions that set them, unless
+// they are given.
+func TestDurationHoursSQL(t *testing.T) {
+	actual := DurationHoursSQL("planned_factor", -1)
+	if !strings.Contains(actual, "WHEN 'auckland' THEN 2.85") ||
+		strings.Contains(actual, "'wellington'") {
+		t.Fatalf(`TestDurationHoursSQL did not return the Auckland factor only, got %s`, actual)
+	}
+
+	actual = DurationHoursSQL("planned_factor", 8)
+	if actual != "outage_hours_planned_factor(start_date, end_date, outage_type, 8)" {
+		t.Fatalf(`TestDurationHoursSQL did not use the given factor, got %s`, actual)
+	}
+
+	actual = DurationHoursSQL("business_hours", -1)
+	if actual != "outage_hours_business(start_date, end_date, '08:00', '17:00')" {
+		t.Fatalf(`TestDurationHoursSQL did not return business hours, got %s`, actual)
+	}
+}
+
+// TestSetDurationModel calls api.Query.SetDurationModel and checks that
+// the hours are selected unless the query is a count, and that invalid
+// parameters are rejected.
+func TestSetDurationModel(t *testing.T) {
+	query := &Query{}
+	query.SetDurationModel("wall_clock", "")
+	if query.Hours != "outage_hours_wall_clock(start_date, end_date)" ||
+		len(query.Selects) != 1 || !strings.HasSuffix(query.Selects[0], " AS duration_hours") {
+		t.Fatalf(`TestSetDurationModel did not select the wall-clock hours, got %v`, query.Selects)
+	}
+
+	query = &Query{IsCount: true}
+	query.SetDurationModel("", "")
+	if !strings.HasPrefix(query.Hours, "outage_hours_planned_factor(") || len(query.Selects) != 0 {
+		t.Fatalf(`TestSetDurationModel did not default to planned_factor, got %s`, query.Hours)
+	}
+
+	query = &Query{IsCount: true}
+	query.SetDurationModel("fortnightly", "25")
+	if strings.Join(query.InvalidParams, ",") != "duration_model,planned_daily_hours" {
+		t.Fatalf(`TestSetDurationModel did not reject the parameters, got %v`, query.InvalidParams)
+	}
+}
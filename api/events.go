@@ -0,0 +1,188 @@
+// events.go contains the functions that record the events of outages
+// that were created, updated or resolved by an ingest.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// The types of outage events.
+const (
+	// OutageCreated is the event of an outage first listed by the
+	// upstream API.
+	OutageCreated = "outage.created"
+	// OutageUpdated is the event of an outage whose dates changed, such
+	// as an extended planned outage.
+	OutageUpdated = "outage.updated"
+	// OutageResolved is the event of an outage no longer listed by the
+	// upstream API.
+	OutageResolved = "outage.resolved"
+)
+
+// An OutageEvent struct maps an outage that was created, updated or
+// resolved. The ID orders the events, and is 0 until the event is saved.
+type OutageEvent struct {
+	ID        int64  `json:"id"`
+	Type      string `json:"type"`
+	Region    string `json:"region"`
+	OutageID  int    `json:"outage_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// An OutageEventPayload struct maps an event with its outage, as sent to
+// webhooks and streams.
+type OutageEventPayload struct {
+	OutageEvent
+	Outage DBWaterOutage `json:"outage"`
+}
+
+// resolveOutagesQuery saves whether each outage of a region is still
+// listed by the upstream API, and returns the outages that changed.
+const resolveOutagesQuery = `UPDATE outage SET active = (outage_id = ANY($2))
+	WHERE region = $1 AND active IS DISTINCT FROM (outage_id = ANY($2))
+	RETURNING outage_id, active`
+
+// ResolveOutages saves which outages of a region are still listed by the
+// upstream API, and returns an outage.resolved event of each outage that
+// no longer is.
+func ResolveOutages(db *sql.DB, region string, ids []int64) ([]OutageEvent, error) {
+	var events []OutageEvent
+	if ids == nil {
+		ids = []int64{}
+	}
+
+	rows, err := db.Query(resolveOutagesQuery, region, pq.Array(ids))
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var outageID int
+		var active bool
+		if err := rows.Scan(&outageID, &active); err != nil {
+			return events, err
+		}
+		if !active {
+			events = append(events, OutageEvent{
+				Type: OutageResolved, Region: region, OutageID: outageID,
+			})
+		}
+	}
+	return events, rows.Err()
+}
+
+// SaveOutageEvents saves events to the outage_event table, and returns
+// them with their ID and creation time.
+func SaveOutageEvents(db *sql.DB, events []OutageEvent) ([]OutageEvent, error) {
+	if len(events) == 0 {
+		return events, nil
+	}
+
+	values := make([]string, len(events))
+	for i, event := range events {
+		values[i] = fmt.Sprintf("('%s', %d, '%s')",
+			EscapeSQLString(event.Region), event.OutageID, EscapeSQLString(event.Type))
+	}
+
+	rows, err := db.Query(`INSERT INTO outage_event (region, outage_id, event_type)
+		VALUES ` + strings.Join(values, ", ") + ` RETURNING id, created_at`)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	saved := make([]OutageEvent, 0, len(events))
+	for i := 0; rows.Next(); i++ {
+		event := events[i]
+		if err := rows.Scan(&event.ID, &event.CreatedAt); err != nil {
+			return saved, err
+		}
+		saved = append(saved, event)
+	}
+	return saved, rows.Err()
+}
+
+// eventOutagesCondition returns an SQL condition of the outages of
+// events.
+func eventOutagesCondition(events []OutageEvent) string {
+	keys := make([]string, len(events))
+	for i, event := range events {
+		keys[i] = fmt.Sprintf("('%s', %d)", EscapeSQLString(event.Region), event.OutageID)
+	}
+	return "(region, outage_id) IN (" + strings.Join(keys, ", ") + ")"
+}
+
+// MatchOutageEvents returns the events whose outage matches the filter
+// parameters (the same as GetOutages), in order, with their outages.
+func MatchOutageEvents(db *sql.DB, events []OutageEvent,
+	filter url.Values) ([]OutageEventPayload, error) {
+	var payloads []OutageEventPayload
+	if len(events) == 0 {
+		return payloads, nil
+	}
+
+	query := &Query{IsCount: true}
+	where := query.MakeWhereStringWith(filter, eventOutagesCondition(events))
+	main := "SELECT " + OutageColumns + " FROM outage" + where
+
+	rows, err := db.Query(main)
+	if err != nil {
+		return payloads, err
+	}
+	defer rows.Close()
+
+	matched, err := ScanOutages(rows)
+	if err != nil {
+		return payloads, err
+	}
+
+	outages := make(map[string]DBWaterOutage)
+	for _, outage := range matched {
+		region := GetRegion(outage.Region)
+		outage.StartDate = region.FormatOutageTime(outage.StartDate)
+		outage.EndDate = region.FormatOutageTime(outage.EndDate)
+		outages[fmt.Sprintf("%s/%d", outage.Region, outage.OutageID)] = outage
+	}
+
+	for _, event := range events {
+		outage, ok := outages[fmt.Sprintf("%s/%d", event.Region, event.OutageID)]
+		if !ok {
+			continue
+		}
+		outage.Status = event.Type != OutageResolved
+		payloads = append(payloads, OutageEventPayload{OutageEvent: event, Outage: outage})
+	}
+	return payloads, nil
+}
+
+// GetOutageEvents returns up to limit events saved after the event with
+// the given ID, in order.
+func GetOutageEvents(db *sql.DB, afterID int64, limit int) ([]OutageEvent, error) {
+	var events []OutageEvent
+
+	rows, err := db.Query(
+		`SELECT id, event_type, region, outage_id, created_at FROM outage_event
+		WHERE id > $1 ORDER BY id LIMIT $2`,
+		afterID, limit,
+	)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event OutageEvent
+		if err := rows.Scan(&event.ID, &event.Type, &event.Region,
+			&event.OutageID, &event.CreatedAt); err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,385 @@
+// feeds.go creates the controller functions of the Atom and RSS feeds of
+// the newest ingested outages, and of the resource of a single outage that the
+// feeds link to.
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultFeedLimit is the number of outages of a feed, unless the
+	// limit parameter is given.
+	DefaultFeedLimit = 50
+	// MaxFeedLimit is the largest number of outages of a feed.
+	MaxFeedLimit = 200
+	// feedTitle is the title of the feeds.
+	feedTitle = "My water is down again!"
+)
+
+// A FeedItem struct maps an outage as an entry of a feed. Published is
+// when the outage was first ingested and Updated is when its dates last
+// changed.
+type FeedItem struct {
+	ID        string
+	Title     string
+	Link      string
+	Summary   string
+	Start     time.Time
+	Published time.Time
+	Updated   time.Time
+}
+
+// An AtomFeed struct maps an Atom (RFC 4287) feed.
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  AtomAuthor  `xml:"author"`
+	Links   []AtomLink  `xml:"link"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// An AtomAuthor struct maps the author of an Atom feed.
+type AtomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// An AtomLink struct maps a link of an Atom feed or entry.
+type AtomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// An AtomEntry struct maps an entry of an Atom feed.
+type AtomEntry struct {
+	Title     string   `xml:"title"`
+	ID        string   `xml:"id"`
+	Published string   `xml:"published"`
+	Updated   string   `xml:"updated"`
+	Link      AtomLink `xml:"link"`
+	Summary   string   `xml:"summary"`
+}
+
+// An RSSFeed struct maps an RSS 2.0 feed.
+type RSSFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel RSSChannel `xml:"channel"`
+}
+
+// An RSSChannel struct maps the channel of an RSS feed.
+type RSSChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []RSSItem `xml:"item"`
+}
+
+// An RSSItem struct maps an item of an RSS feed.
+type RSSItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        RSSGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+// An RSSGUID struct maps the unique id of an RSS item.
+type RSSGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// MakeFeedItem returns the feed entry of an outage, with a link to the
+// outage resource under baseURL. The dates of the outage are RFC 3339
+// timestamps, as formatted by FinishOutages. CreatedAt and UpdatedAt are
+// when the outage was first ingested and last snapshotted, and default to
+// the start of the outage.
+func MakeFeedItem(outage DBWaterOutage, baseURL string) FeedItem {
+	place := strings.Trim(outage.Street+", "+outage.Suburb, ", ")
+	outageType := outage.OutageType
+	if outageType == "" {
+		outageType = "Water"
+	}
+
+	item := FeedItem{
+		ID: fmt.Sprintf("tag:water-down-again,2022:outage/%s/%d",
+			outage.Region, outage.OutageID),
+		Title: outageType + " outage",
+		Link: fmt.Sprintf("%s/outages/%d?region=%s", baseURL, outage.OutageID,
+			url.QueryEscape(outage.Region)),
+	}
+	if place != "" {
+		item.Title += " — " + place
+	}
+	item.Start, _ = time.Parse(time.RFC3339, outage.StartDate)
+	item.Published = item.Start
+	if created, err := time.Parse(time.RFC3339, outage.CreatedAt); err == nil {
+		item.Published = created
+	}
+	item.Updated = item.Published
+	if updated, err := time.Parse(time.RFC3339, outage.UpdatedAt); err == nil &&
+		updated.After(item.Published) {
+		item.Updated = updated
+	}
+
+	location := outage.RawLocation
+	if location == "" {
+		location = place
+	}
+	item.Summary = fmt.Sprintf("%s outage at %s from %s", outageType, location,
+		item.Start.Format("Mon 2 Jan 2006 15:04"))
+	if end, err := time.Parse(time.RFC3339, outage.EndDate); err == nil {
+		item.Summary += " until " + end.Format("Mon 2 Jan 2006 15:04")
+	}
+	item.Summary += "."
+	return item
+}
+
+// feedUpdated returns the last update of the feed items, or now if there
+// are no items.
+func feedUpdated(items []FeedItem) time.Time {
+	updated := time.Time{}
+	for _, item := range items {
+		if item.Updated.After(updated) {
+			updated = item.Updated
+		}
+	}
+	if updated.IsZero() {
+		return time.Now()
+	}
+	return updated
+}
+
+// MakeAtomFeed returns an Atom feed of items, whose own address is
+// selfURL.
+func MakeAtomFeed(items []FeedItem, baseURL, selfURL string) AtomFeed {
+	feed := AtomFeed{
+		Title:   feedTitle,
+		ID:      "tag:water-down-again,2022:feed",
+		Updated: feedUpdated(items).Format(time.RFC3339),
+		Author:  AtomAuthor{Name: feedTitle},
+		Links: []AtomLink{
+			{Rel: "self", Href: selfURL},
+			{Rel: "alternate", Href: baseURL + "/"},
+		},
+	}
+
+	for _, item := range items {
+		feed.Entries = append(feed.Entries, AtomEntry{
+			Title:     item.Title,
+			ID:        item.ID,
+			Published: item.Published.Format(time.RFC3339),
+			Updated:   item.Updated.Format(time.RFC3339),
+			Link:      AtomLink{Rel: "alternate", Href: item.Link},
+			Summary:   item.Summary,
+		})
+	}
+	return feed
+}
+
+// MakeRSSFeed returns an RSS 2.0 feed of items.
+func MakeRSSFeed(items []FeedItem, baseURL string) RSSFeed {
+	feed := RSSFeed{
+		Version: "2.0",
+		Channel: RSSChannel{
+			Title:         feedTitle,
+			Link:          baseURL + "/",
+			Description:   "The newest water outages.",
+			LastBuildDate: feedUpdated(items).Format(time.RFC1123Z),
+		},
+	}
+
+	for _, item := range items {
+		feed.Channel.Items = append(feed.Channel.Items, RSSItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        RSSGUID{Value: item.ID},
+			PubDate:     item.Published.Format(time.RFC1123Z),
+			Description: item.Summary,
+		})
+	}
+	return feed
+}
+
+// writeXML XML-encodes a feed as the response with the given content type.
+func writeXML(w http.ResponseWriter, contentType string, feed interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		log.Println(err)
+	}
+}
+
+// GetFeed returns an Atom feed (/feed.atom) or RSS feed (/feed.rss) of the
+// newest outages by when they were first ingested, matching the same parameters as
+// GetOutages. The number of outages is set by the limit parameter.
+func GetFeed(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetFeed request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := r.URL.Query()
+	limit := DefaultFeedLimit
+	if value := params.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > MaxFeedLimit {
+			WriteAppError(w, http.StatusBadRequest, AppError{
+				ErrorCode: 3440,
+				Message:   "invalid parameters",
+				Details:   "Parameters given for this API were invalid: limit.",
+			})
+			return
+		}
+	}
+
+	query := &Query{IsCount: true}
+	filter := query.MakeWhereStringWith(params, "start_date IS NOT NULL")
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	// Outages ingested before first_ingested_at was recorded fall back to
+	// their first snapshot. Both are saved in the time zone of the database,
+	// unlike the dates of outages, so are given as UTC timestamps
+	main := `SELECT ` + OutageColumns + `,
+		COALESCE(to_char(COALESCE(first_ingested_at, first_recorded_at)::timestamptz
+			AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), '') AS created_at,
+		COALESCE(to_char(last_recorded_at::timestamptz
+			AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), '') AS updated_at
+		FROM outage LEFT JOIN LATERAL (
+			SELECT MIN(recorded_at) AS first_recorded_at,
+			MAX(recorded_at) AS last_recorded_at FROM outage_snapshot
+			WHERE outage_snapshot.region = outage.region
+			AND outage_snapshot.outage_id = outage.outage_id
+		) snapshots ON true` + filter + fmt.Sprintf(
+		` ORDER BY COALESCE(first_ingested_at, first_recorded_at) DESC NULLS LAST,
+		start_date DESC, outage_id DESC LIMIT %d`, limit)
+
+	// Setup the database
+	db := database.DB()
+
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	outages, err := ScanOutages(rows)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3510,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	baseURL := publicURL()
+	items := make([]FeedItem, len(outages))
+	for i, outage := range outages {
+		region := GetRegion(outage.Region)
+		outage.StartDate = region.FormatOutageTime(outage.StartDate)
+		outage.EndDate = region.FormatOutageTime(outage.EndDate)
+		outage.CreatedAt = region.FormatIngestTime(outage.CreatedAt)
+		outage.UpdatedAt = region.FormatIngestTime(outage.UpdatedAt)
+		items[i] = MakeFeedItem(outage, baseURL)
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".rss") {
+		writeXML(w, "application/rss+xml; charset=utf-8", MakeRSSFeed(items, baseURL))
+		return
+	}
+	writeXML(w, "application/atom+xml; charset=utf-8",
+		MakeAtomFeed(items, baseURL, baseURL+r.URL.RequestURI()))
+}
+
+// GetOutageByID JSON-encodes a single outage by its id and the region
+// parameter (defaults to auckland).
+func GetOutageByID(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetOutageByID request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	outageID, err := strconv.Atoi(mux.Vars(r)["id"])
+	regionName := strings.ToLower(r.URL.Query().Get("region"))
+	if regionName == "" {
+		regionName = DefaultRegion
+	}
+	if err != nil || Regions[regionName] == nil {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid: id/region.",
+		})
+		return
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	rows, err := db.Query(`SELECT `+OutageColumns+` FROM outage
+		WHERE region = $1 AND outage_id = $2`, regionName, outageID)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3511,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	outages, err := ScanOutages(rows)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3511,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	if len(outages) == 0 {
+		WriteAppError(w, http.StatusNotFound, AppError{
+			ErrorCode: 3512,
+			Message:   "outage not found",
+			Details:   "There is no outage with this id in this region.",
+		})
+		return
+	}
+
+	FinishOutages(outages)
+	WriteJSON(w, outages[0])
+}
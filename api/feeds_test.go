@@ -0,0 +1,94 @@
+// feeds_test.go contains tests that test feeds.go
+package api
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// TestMakeFeedItem calls api.MakeFeedItem and checks the title, link,
+// summary and dates of the entry of an outage.
+func TestMakeFeedItem(t *testing.T) {
+	outage := DBWaterOutage{
+		OutageID:    123,
+		Region:      "auckland",
+		Street:      "Queen Street",
+		Suburb:      "Auckland Central",
+		RawLocation: "12 Queen St, Auckland Central",
+		OutageType:  "Unplanned",
+		StartDate:   "2022-06-22T09:00:00+12:00",
+		EndDate:     "2022-06-22T15:30:00+12:00",
+		CreatedAt:   "2022-06-20T08:00:00+12:00",
+		UpdatedAt:   "2022-06-22T12:00:00+12:00",
+	}
+
+	actual := MakeFeedItem(outage, "https://example.com")
+	expected := FeedItem{
+		ID:      "tag:water-down-again,2022:outage/auckland/123",
+		Title:   "Unplanned outage — Queen Street, Auckland Central",
+		Link:    "https://example.com/outages/123?region=auckland",
+		Summary: "Unplanned outage at 12 Queen St, Auckland Central from Wed 22 Jun 2022 09:00 until Wed 22 Jun 2022 15:30.",
+	}
+
+	if actual.ID != expected.ID || actual.Title != expected.Title ||
+		actual.Link != expected.Link || actual.Summary != expected.Summary ||
+		actual.Start.Unix() != 1655845200 || actual.Published.Unix() != 1655668800 ||
+		actual.Updated.Unix() != 1655856000 {
+		t.Fatalf(`TestMakeFeedItem did not return %+v got %+v`, expected, actual)
+	}
+}
+
+// TestMakeAtomFeed calls api.MakeAtomFeed and checks that the feed is
+// encoded as Atom with an entry per item, updated by its latest snapshot.
+func TestMakeAtomFeed(t *testing.T) {
+	item := MakeFeedItem(DBWaterOutage{
+		OutageID: 1, Region: "auckland", Suburb: "Remuera",
+		OutageType: "Planned", StartDate: "2022-06-22T09:00:00+12:00",
+		UpdatedAt: "2022-06-22T10:30:00+12:00",
+	}, "https://example.com")
+
+	encoded, err := xml.Marshal(MakeAtomFeed(
+		[]FeedItem{item}, "https://example.com", "https://example.com/feed.atom",
+	))
+	actual := string(encoded)
+	expected := []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom">`,
+		`<updated>2022-06-22T10:30:00+12:00</updated>`,
+		`<published>2022-06-22T09:00:00+12:00</published>`,
+		`<link rel="self" href="https://example.com/feed.atom"></link>`,
+		`<title>Planned outage — Remuera</title>`,
+		`<link rel="alternate" href="https://example.com/outages/1?region=auckland"></link>`,
+	}
+
+	for _, part := range expected {
+		if err != nil || !strings.Contains(actual, part) {
+			t.Fatalf(`TestMakeAtomFeed did not return %v got %v (%v)`, part, actual, err)
+		}
+	}
+}
+
+// TestMakeRSSFeed calls api.MakeRSSFeed and checks that the feed is
+// encoded as RSS 2.0 with an item per item.
+func TestMakeRSSFeed(t *testing.T) {
+	item := MakeFeedItem(DBWaterOutage{
+		OutageID: 1, Region: "wellington", Street: "Cuba Street",
+		OutageType: "Unplanned", StartDate: "2022-06-22T09:00:00+12:00",
+	}, "https://example.com")
+
+	encoded, err := xml.Marshal(MakeRSSFeed([]FeedItem{item}, "https://example.com"))
+	actual := string(encoded)
+	expected := []string{
+		`<rss version="2.0"><channel>`,
+		`<title>Unplanned outage — Cuba Street</title>`,
+		`<link>https://example.com/outages/1?region=wellington</link>`,
+		`<guid isPermaLink="false">tag:water-down-again,2022:outage/wellington/1</guid>`,
+		`<pubDate>Wed, 22 Jun 2022 09:00:00 +1200</pubDate>`,
+	}
+
+	for _, part := range expected {
+		if err != nil || !strings.Contains(actual, part) {
+			t.Fatalf(`TestMakeRSSFeed did not return %v got %v (%v)`, part, actual, err)
+		}
+	}
+}
@@ -6,17 +6,24 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
 // MakeFilterQuery generates an SQL WHERE string and a string containing
 // ORDER BY, LIMIT and OFFSET statements based on given parameters.
 func MakeFilterQuery(r *http.Request, isCount bool) (where string, sort string) {
-	// Get url params
-	params := r.URL.Query()
+	_, where, sort = MakeQuery(r.URL.Query(), isCount)
+	return
+}
 
+// MakeQuery generates the same strings as MakeFilterQuery from the given
+// parameters, and returns the query object with any extra Selects and
+// InvalidParams found on the way.
+func MakeQuery(params url.Values, isCount bool) (
+	query *Query, where string, sort string) {
 	// Set up query object
-	query := new(Query)
+	query = new(Query)
 	query.IsCount = isCount
 
 	// Make strings from params and query objects
@@ -25,6 +32,9 @@ func MakeFilterQuery(r *http.Request, isCount bool) (where string, sort string)
 	// if parameters exist
 	where = query.MakeWhereString(params)
 
+	// The distance sort needs a radius filter
+	query.CheckDistanceSort()
+
 	return
 }
 
@@ -35,19 +45,39 @@ func MakeFilterQuery(r *http.Request, isCount bool) (where string, sort string)
 //		" ORDER BY outage_id LIMIT 50 OFFSET 0"
 // where outage_id can be replaced by the sort, while 50
 // and 0 can be replaced by limit and offset parameters.
+// Sorts by columns that are not sortable, and limits and
+// offsets that are not whole numbers, are InvalidParams.
 func (query *Query) MakeOrderbyPaginationString(
 	params url.Values) string {
 	if query.IsCount {
 		return ""
 	}
 
+	// Get parameters for pagination, which must be whole
+	// numbers of at least 0
+	limit, offset := 50, 0
+	if value := params.Get("limit"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			query.InvalidParams = append(query.InvalidParams, "limit")
+		} else {
+			limit = number
+		}
+	}
+	if value := params.Get("offset"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			query.InvalidParams = append(query.InvalidParams, "offset")
+		} else {
+			offset = number
+		}
+	}
+
 	if values := params["sort"]; len(values) > 0 {
 		// Get parameters for sorting
 		orderby := query.MakeOrderbyString(values)
-
 		pagination := query.MakePaginationString(
-			params["limit"][0], params["offset"][0],
-		)
+			strconv.Itoa(limit), strconv.Itoa(offset))
 
 		// Combine
 		return fmt.Sprintf(" %s %s", orderby, pagination)
@@ -73,11 +103,28 @@ func (query *Query) MakeWhereString(
 	return
 }
 
+// MakeWhereStringWith combines the Wheres into a single SQL
+// WHERE statement like MakeWhereString, and adds the given
+// conditions that must always be met.
+func (query *Query) MakeWhereStringWith(
+	params url.Values, conditions ...string) string {
+	if filter := strings.TrimPrefix(
+		query.MakeWhereString(params), " WHERE "); filter != "" {
+		conditions = append(conditions, "("+filter+")")
+	}
+
+	if len(conditions) > 0 {
+		return " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return ""
+}
+
 // SetWheres adds all SQL Wheres of the equivalent
 // string for valid API parameters.
 func (query *Query) SetWheres(params url.Values) {
 	query.SetSearchWhere(params["search"])
 	query.SetOutageTypeWhere(params.Get("outage_type"))
+	query.SetRegionWhere(params.Get("region"))
 	query.SetOutageIDWhere(params.Get("outage_id"))
 
 	query.SetDateWheres(params)
@@ -87,6 +134,16 @@ func (query *Query) SetWheres(params url.Values) {
 		params.Get("longitude"), params.Get("latitude"),
 		params.Get("radius"),
 	)
+	query.SetBoundingBoxWhere(params.Get("bbox"))
+	query.SetWithinWhere(params.Get("within"))
+
+	for _, kind := range BoundaryKinds {
+		query.SetBoundaryWhere(kind, params[kind])
+	}
+
+	query.SetDurationModel(
+		params.Get("duration_model"), params.Get("planned_daily_hours"),
+	)
 }
 
 // SetDateWheres adds a SQL WHERE condition for all date
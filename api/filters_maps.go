@@ -5,11 +5,28 @@ package api
 var FilterableParams = []string{
 	"suburb", "street", "outage_type", "search",
 	"before_start_date", "before_end_date", "after_end_date",
-	"after_start_date", "location", "outage_id",
+	"after_start_date", "location", "outage_id", "region",
 }
 
 var FilterableCountParams = []string{
 	"total_hours", "total_outages",
+	"rate_per_1000_people", "rate_per_1000_dwellings",
+	"avg_hours", "median_hours", "p90_hours", "max_hours", "min_hours",
+	"stddev_hours",
+}
+
+var HourMetrics = map[string]string{
+	"avg_hours":    "AVG(%s)",
+	"median_hours": "percentile_cont(0.5) WITHIN GROUP (ORDER BY %s)",
+	"p90_hours":    "percentile_cont(0.9) WITHIN GROUP (ORDER BY %s)",
+	"max_hours":    "MAX(%s)",
+	"min_hours":    "MIN(%s)",
+	"stddev_hours": "stddev_samp(%s)",
+}
+
+var RateDenominators = map[string]string{
+	"rate_per_1000_people":    "population",
+	"rate_per_1000_dwellings": "dwellings",
 }
 
 var SQLSigns = map[string]string{
@@ -22,3 +39,20 @@ var DateColumns = []string{
 	"before_end_date", "after_end_date",
 	"before_start_date", "after_start_date",
 }
+
+var BoundaryKinds = []string{
+	"local_board", "ward",
+}
+
+var SortableColumns = []string{
+	"outage_id", "region", "street", "suburb", "start_date", "end_date",
+	"outage_type", "raw_location", "recurring", "status", "distance",
+}
+
+var GroupableColumns = []string{
+	"suburb", "street", "outage_type", "region",
+}
+
+var TimeSeriesIntervals = []string{
+	"day", "week", "month", "year",
+}
@@ -8,11 +8,15 @@ import (
 )
 
 type Query struct {
-	Selects  []string
-	Wheres   []string
-	Orderbys []string
-	GroupBy  []string
-	IsCount  bool
+	Selects       []string
+	Wheres        []string
+	Orderbys      []string
+	GroupBy       []string
+	IsCount       bool
+	InvalidParams []string
+	// Hours is the SQL expression with the hours of an outage by the
+	// selected duration model.
+	Hours string
 }
 
 // SetSearchWhere adds a SQL WHERE that filters database
@@ -42,9 +46,9 @@ func (query *Query) SetAddressWhere(address string) {
 			OR lower(street) LIKE lower('%%%s%%') 
 			OR lower(suburb) LIKE lower('%%%s%%')
 			OR lower(street) LIKE lower('%%%s%%'))`,
-			address, address,
-			CleanAddressName(address, "suburb"),
-			CleanAddressName(address, "street"),
+			EscapeSQLString(address), EscapeSQLString(address),
+			EscapeSQLString(CleanAddressName(address, "suburb")),
+			EscapeSQLString(CleanAddressName(address, "street")),
 		),
 	)
 }
@@ -61,8 +65,8 @@ func (query *Query) SetAddressOfTypeWhere(
 		query.Wheres, fmt.Sprintf(
 			`(lower(%s) LIKE lower('%%%s%%')
 			OR lower(%s) LIKE lower('%%%s%%'))`,
-			addressType, addressName, addressType,
-			CleanAddressName(addressName, addressType),
+			addressType, EscapeSQLString(addressName), addressType,
+			EscapeSQLString(CleanAddressName(addressName, addressType)),
 		),
 	)
 }
@@ -70,22 +74,139 @@ func (query *Query) SetAddressOfTypeWhere(
 // SetLocationRadiusWhere adds a SQL WHERE statement that
 // filters database records of a radius circle (in m) around
 // a longitude and latitude. The SQL WHERE statement is added
-// to *Query.Wheres.
+// to *Query.Wheres. Unless the query is a count, the distance
+// (in m) to the longitude and latitude is added to
+// *Query.Selects as distance_m.
 func (query *Query) SetLocationRadiusWhere(
 	longitude, latitude, radius string,
 ) {
 	if longitude != "" && latitude != "" && radius != "" {
+		lon, lonErr := ParseCoordinate(longitude, 180)
+		lat, latErr := ParseCoordinate(latitude, 90)
+		metres, radiusErr := ParseDistance(radius)
+		if lonErr != nil || latErr != nil || radiusErr != nil {
+			query.InvalidParams = append(
+				query.InvalidParams, "longitude/latitude/radius")
+			return
+		}
+
 		query.Wheres = append(
-			query.Wheres,
-			fmt.Sprintf(
-				`ST_DWithin(location, 
-				ST_SetSRID(ST_Point(%s, %s), 4326), %s)`,
-				longitude, latitude, radius,
-			),
+			query.Wheres, query.MakeLocationRadiusWhere(lon, lat, metres),
 		)
 	}
 }
 
+// MakeLocationRadiusWhere returns a SQL WHERE statement that
+// filters database records of a radius circle (in m) around
+// a longitude and latitude, without adding it to
+// *Query.Wheres. Unless the query is a count, the distance
+// (in m) to the longitude and latitude is added to
+// *Query.Selects as distance_m.
+func (query *Query) MakeLocationRadiusWhere(
+	longitude, latitude, metres float64,
+) string {
+	point := fmt.Sprintf(
+		"ST_SetSRID(ST_Point(%f, %f), 4326)::geography", longitude, latitude,
+	)
+
+	if !query.IsCount {
+		query.Selects = append(query.Selects, fmt.Sprintf(
+			"ST_Distance(location, %s) AS distance_m", point,
+		))
+	}
+	return fmt.Sprintf(`ST_DWithin(location, %s, %f)`, point, metres)
+}
+
+// SetBoundingBoxWhere adds a SQL WHERE statement that filters
+// database records inside a bounding box, given in the format
+// "minLon,minLat,maxLon,maxLat" such as the viewport of a map.
+// The SQL WHERE statement is added to *Query.Wheres.
+func (query *Query) SetBoundingBoxWhere(bbox string) {
+	if bbox == "" {
+		return
+	}
+
+	corners := strings.Split(bbox, ",")
+	values := make([]float64, len(corners))
+	for i, corner := range corners {
+		// Corners alternate between longitudes and latitudes
+		limit := 180.0
+		if i%2 == 1 {
+			limit = 90
+		}
+		value, err := ParseCoordinate(corner, limit)
+		if err != nil {
+			values = nil
+			break
+		}
+		values[i] = value
+	}
+
+	if len(values) != 4 || values[0] > values[2] || values[1] > values[3] {
+		query.InvalidParams = append(query.InvalidParams, "bbox")
+		return
+	}
+
+	query.Wheres = append(
+		query.Wheres,
+		fmt.Sprintf(
+			"location::geometry && ST_MakeEnvelope(%f, %f, %f, %f, 4326)",
+			values[0], values[1], values[2], values[3],
+		),
+	)
+}
+
+// SetWithinWhere adds a SQL WHERE statement that filters
+// database records inside a GeoJSON polygon. The SQL WHERE
+// statement is added to *Query.Wheres.
+func (query *Query) SetWithinWhere(geojson string) {
+	if geojson == "" {
+		return
+	}
+
+	polygon, err := ParseGeoJSONPolygon([]byte(geojson))
+	if err != nil {
+		query.InvalidParams = append(query.InvalidParams, "within")
+		return
+	}
+
+	query.Wheres = append(
+		query.Wheres,
+		fmt.Sprintf(
+			`ST_Intersects(location::geometry, 
+			ST_SetSRID(ST_GeomFromGeoJSON('%s'), 4326))`,
+			polygon.JSON(),
+		),
+	)
+}
+
+// SetBoundaryWhere adds a SQL WHERE statement that filters
+// database records inside any of the stored boundaries of a
+// kind (such as local_board or ward) with the given names.
+// The SQL WHERE statement is added to *Query.Wheres.
+func (query *Query) SetBoundaryWhere(kind string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+
+	var escaped []string
+	for _, name := range names {
+		escaped = append(escaped,
+			fmt.Sprintf("lower('%s')", EscapeSQLString(name)))
+	}
+
+	query.Wheres = append(
+		query.Wheres,
+		fmt.Sprintf(
+			`EXISTS (SELECT 1 FROM boundary WHERE boundary.kind = '%s' 
+			AND boundary.region = outage.region
+			AND lower(boundary.name) IN (%s) 
+			AND ST_Intersects(boundary.geom, outage.location::geometry))`,
+			kind, strings.Join(escaped, ", "),
+		),
+	)
+}
+
 // SetOutageTypeWhere adds a SQL WHERE that filters database
 // records by the outage_type column. The SQL WHERE statement
 // is added to *Query.Wheres.
@@ -95,6 +216,15 @@ func (query *Query) SetOutageTypeWhere(outageType string) {
 	)
 }
 
+// SetRegionWhere adds a SQL WHERE that filters database
+// records by the region column. The SQL WHERE statement
+// is added to *Query.Wheres.
+func (query *Query) SetRegionWhere(region string) {
+	query.SetSignedWhere(
+		"region = ", strings.ToLower(region),
+	)
+}
+
 // SetOutageIDWhere adds a SQL WHERE that filters database
 // records by the outage_id column. The SQL WHERE statement
 // is added to *Query.Wheres.
@@ -117,7 +247,7 @@ func (query *Query) SetSignedWhere(signedColumn, value string) {
 	if value != "" {
 		query.Wheres = append(
 			query.Wheres,
-			fmt.Sprintf("%s '%s'", signedColumn, value),
+			fmt.Sprintf("%s '%s'", signedColumn, EscapeSQLString(value)),
 		)
 	}
 }
@@ -133,9 +263,54 @@ func (query *Query) MakeOrderbyString(
 }
 
 // SetOrderbyFields adds strings in the format "column_name asc/desc"
-// to the orderbys field.
+// to the orderbys field. The "distance" sort is replaced by the
+// distance_m column. "sort" is added to *Query.InvalidParams if
+// a column is not sortable or the direction is not asc or desc.
 func (query *Query) SetOrderbysField(orderbys []string) {
-	query.Orderbys = append(query.Orderbys, orderbys...)
+	for _, orderby := range orderbys {
+		field := strings.Fields(orderby)
+		if len(field) == 0 || len(field) > 2 ||
+			!isStringInArray(field[0], SortableColumns) {
+			query.InvalidParams = append(query.InvalidParams, "sort")
+			continue
+		}
+		if field[0] == "distance" {
+			field[0] = "distance_m"
+		}
+		if len(field) == 2 {
+			direction := strings.ToLower(field[1])
+			if direction != "asc" && direction != "desc" {
+				query.InvalidParams = append(query.InvalidParams, "sort")
+				continue
+			}
+			field[1] = direction
+		}
+		query.Orderbys = append(query.Orderbys, strings.Join(field, " "))
+	}
+}
+
+// CheckDistanceSort adds "sort" to *Query.InvalidParams if
+// the query is sorted by distance without a radius filter to
+// measure the distance from.
+func (query *Query) CheckDistanceSort() {
+	for _, orderby := range query.Orderbys {
+		if field := strings.Fields(orderby); len(field) > 0 &&
+			field[0] == "distance_m" {
+			for _, selected := range query.Selects {
+				if strings.HasSuffix(selected, "AS distance_m") {
+					return
+				}
+			}
+			query.InvalidParams = append(query.InvalidParams, "sort")
+			return
+		}
+	}
+}
+
+// MakeSelectString returns an SQL select list of the given
+// columns followed by the *Query.Selects.
+func (query *Query) MakeSelectString(columns ...string) string {
+	return strings.Join(append(columns, query.Selects...), ", ")
 }
 
 // MakeOrderbyStringFromFields makes a single SQL ORDER BY
@@ -1,6 +1,9 @@
 package api
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 // TestSetSearchWhere tests SetSearchWhere and checks if the input
 // is served as a correct WHERE string.
@@ -29,3 +32,123 @@ func TestSetSearchWhere(t *testing.T) {
 		}
 	}
 }
+
+// TestSetLocationRadiusWhere tests SetLocationRadiusWhere and checks if
+// the distance is selected unless the query is a count.
+func TestSetLocationRadiusWhere(t *testing.T) {
+	query := Query{}
+	query.SetLocationRadiusWhere("174.762415", "-36.855109", "2km")
+
+	if len(query.Wheres) != 1 || !strings.Contains(query.Wheres[0], "2000.000000") {
+		t.Fatalf(`TestSetLocationRadiusWhere did not add a 2000 m radius, got %v`, query.Wheres)
+	}
+	if len(query.Selects) != 1 || !strings.HasSuffix(query.Selects[0], "AS distance_m") {
+		t.Fatalf(`TestSetLocationRadiusWhere did not select distance_m, got %v`, query.Selects)
+	}
+
+	count := Query{IsCount: true}
+	count.SetLocationRadiusWhere("174.762415", "-36.855109", "2000")
+	if len(count.Selects) != 0 {
+		t.Fatalf(`TestSetLocationRadiusWhere selected distance_m for a count`)
+	}
+
+	for _, location := range [][3]string{
+		{"174.762415); DROP TABLE outage; --", "-36.855109", "2000"},
+		{"NaN", "-36.855109", "2000"},
+		{"174.762415", "Inf", "2000"},
+		{"174.762415", "-91", "2000"},
+		{"174.762415", "-36.855109", "NaN"},
+		{"174.762415", "-36.855109", "1e400"},
+	} {
+		invalid := Query{}
+		invalid.SetLocationRadiusWhere(location[0], location[1], location[2])
+		if len(invalid.Wheres) != 0 || len(invalid.InvalidParams) != 1 {
+			t.Fatalf(`TestSetLocationRadiusWhere did not reject %v`, location)
+		}
+	}
+}
+
+// TestSetBoundingBoxWhere tests SetBoundingBoxWhere and checks if valid
+// bounding boxes are added to the Wheres and invalid ones are rejected.
+func TestSetBoundingBoxWhere(t *testing.T) {
+	query := Query{}
+	query.SetBoundingBoxWhere("174.7,-36.9,174.8,-36.8")
+
+	expected := "location::geometry && ST_MakeEnvelope(174.700000, -36.900000, 174.800000, -36.800000, 4326)"
+	if len(query.Wheres) != 1 || query.Wheres[0] != expected {
+		t.Fatalf(`TestSetBoundingBoxWhere did not return %s got %v`, expected, query.Wheres)
+	}
+
+	for _, bbox := range []string{
+		"174.7,-36.9,174.8", "174.8,-36.9,174.7,-36.8", "a,b,c,d",
+		"NaN,-36.9,174.8,-36.8", "-Inf,-36.9,174.8,-36.8", "174.7,-36.9,174.8,Inf",
+	} {
+		invalid := Query{}
+		invalid.SetBoundingBoxWhere(bbox)
+		if len(invalid.Wheres) != 0 || len(invalid.InvalidParams) != 1 {
+			t.Fatalf(`TestSetBoundingBoxWhere did not reject %s`, bbox)
+		}
+	}
+}
+
+// TestCheckDistanceSort tests CheckDistanceSort and checks if sorting by
+// distance is invalid without a radius filter.
+func TestCheckDistanceSort(t *testing.T) {
+	query := Query{}
+	query.SetOrderbysField([]string{"distance"})
+	query.CheckDistanceSort()
+	if len(query.InvalidParams) != 1 || query.InvalidParams[0] != "sort" {
+		t.Fatalf(`TestCheckDistanceSort did not return [sort] got %v`, query.InvalidParams)
+	}
+
+	radius := Query{}
+	radius.SetOrderbysField([]string{"distance desc"})
+	radius.SetLocationRadiusWhere("174.762415", "-36.855109", "2km")
+	radius.CheckDistanceSort()
+	if len(radius.InvalidParams) != 0 {
+		t.Fatalf(`TestCheckDistanceSort did not return [] got %v`, radius.InvalidParams)
+	}
+}
+
+// TestSetOrderbysField tests SetOrderbysField and checks if the distance
+// sort is replaced by the distance_m column, and if sorts by columns that
+// are not sortable are invalid.
+func TestSetOrderbysField(t *testing.T) {
+	query := Query{}
+	query.SetOrderbysField([]string{"distance", "distance desc", "start_date"})
+
+	expected := " ORDER BY distance_m, distance_m desc, start_date"
+	if actual := query.MakeOrderbyStringFromFields(); actual != expected {
+		t.Fatalf(`TestSetOrderbysField did not return %s got %s`, expected, actual)
+	}
+
+	for _, orderby := range []string{
+		"outage_id; DROP TABLE outage", "random()", "suburb sideways",
+		"suburb desc nulls", "",
+	} {
+		invalid := Query{}
+		invalid.SetOrderbysField([]string{orderby})
+		if len(invalid.Orderbys) != 0 || len(invalid.InvalidParams) != 1 {
+			t.Fatalf(`TestSetOrderbysField did not return [sort] for %q got %v`,
+				orderby, invalid.InvalidParams)
+		}
+	}
+}
+
+// TestSetBoundaryWhere tests SetBoundaryWhere and checks if the names of
+// the boundaries are escaped and combined into a single Where.
+func TestSetBoundaryWhere(t *testing.T) {
+	query := Query{}
+	query.SetBoundaryWhere("local_board", []string{"Albert-Eden", "Ōrākei's"})
+
+	if len(query.Wheres) != 1 ||
+		!strings.Contains(query.Wheres[0], "boundary.kind = 'local_board'") ||
+		!strings.Contains(query.Wheres[0], "IN (lower('Albert-Eden'), lower('Ōrākei''s'))") {
+		t.Fatalf(`TestSetBoundaryWhere did not return a local_board Where, got %v`, query.Wheres)
+	}
+
+	query.SetBoundaryWhere("ward", nil)
+	if len(query.Wheres) != 1 {
+		t.Fatalf(`TestSetBoundaryWhere added a Where without names`)
+	}
+}
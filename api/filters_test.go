@@ -0,0 +1,49 @@
+// filters_test.go contains tests that test filters.go
+package api
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestMakeOrderbyPaginationString calls api.MakeOrderbyPaginationString
+// and checks the order by, limit and offset of the sort, limit and offset
+// parameters, and that limits and offsets must be whole numbers.
+func TestMakeOrderbyPaginationString(t *testing.T) {
+	tests := []struct {
+		params   string
+		expected string
+		invalid  []string
+	}{
+		{"", " ORDER BY outage_id LIMIT 50 OFFSET 0", nil},
+		{"sort=start_date+DESC&limit=10&offset=20",
+			"  ORDER BY start_date desc LIMIT 10 OFFSET 20", nil},
+		{"sort=suburb&sort=outage_id", "  ORDER BY suburb, outage_id LIMIT 50 OFFSET 0", nil},
+		{"sort=outage_id&limit=ALL", "", []string{"limit"}},
+		{"sort=outage_id&limit=-1&offset=1.5", "", []string{"limit", "offset"}},
+		{"sort=outage_id&offset=0%3BDROP+TABLE+outage", "", []string{"offset"}},
+		{"limit=10", " ORDER BY outage_id LIMIT 50 OFFSET 0", nil},
+		{"sort=(SELECT+1)", "", []string{"sort"}},
+	}
+
+	for _, test := range tests {
+		params, _ := url.ParseQuery(test.params)
+		query := new(Query)
+		actual := query.MakeOrderbyPaginationString(params)
+
+		invalid := strings.Join(query.InvalidParams, ", ")
+		if invalid != strings.Join(test.invalid, ", ") {
+			t.Fatalf(
+				`TestMakeOrderbyPaginationString did not return %v for %s got %v`,
+				test.invalid, test.params, query.InvalidParams,
+			)
+		}
+		if len(test.invalid) == 0 && actual != test.expected {
+			t.Fatalf(
+				`TestMakeOrderbyPaginationString did not return %q for %s got %q`,
+				test.expected, test.params, actual,
+			)
+		}
+	}
+}
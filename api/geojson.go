@@ -0,0 +1,105 @@
+// geojson.go contains functions that read GeoJSON polygons used to
+// filter outages by area.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// A GeoJSON struct maps a GeoJSON object, which is either a geometry, a
+// feature or a feature collection.
+type GeoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates,omitempty"`
+	Geometry    *GeoJSON        `json:"geometry,omitempty"`
+	Features    []GeoJSON       `json:"features,omitempty"`
+	Properties  json.RawMessage `json:"properties,omitempty"`
+}
+
+// A MultiPolygon struct maps a GeoJSON MultiPolygon geometry.
+type MultiPolygon struct {
+	Type        string           `json:"type"`
+	Coordinates [][][][2]float64 `json:"coordinates"`
+}
+
+// ParseGeoJSONPolygon reads a GeoJSON Polygon or MultiPolygon geometry,
+// or a feature (collection) of them, and returns all polygons as a single
+// MultiPolygon. As the MultiPolygon only contains numbers, it is safe to
+// use in an SQL query once JSON-encoded.
+func ParseGeoJSONPolygon(data []byte) (MultiPolygon, error) {
+	var object GeoJSON
+	if err := json.Unmarshal(data, &object); err != nil {
+		return MultiPolygon{}, err
+	}
+
+	multiPolygon := MultiPolygon{Type: "MultiPolygon"}
+	if err := multiPolygon.add(object); err != nil {
+		return multiPolygon, err
+	}
+
+	if len(multiPolygon.Coordinates) == 0 {
+		return multiPolygon, errors.New("no polygons found")
+	}
+	return multiPolygon, nil
+}
+
+// add adds the polygons of a GeoJSON object to the MultiPolygon.
+func (multiPolygon *MultiPolygon) add(object GeoJSON) error {
+	switch object.Type {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(object.Coordinates, &polygon); err != nil {
+			return err
+		}
+		return multiPolygon.addPolygon(polygon)
+	case "MultiPolygon":
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(object.Coordinates, &polygons); err != nil {
+			return err
+		}
+		for _, polygon := range polygons {
+			if err := multiPolygon.addPolygon(polygon); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Feature":
+		if object.Geometry == nil {
+			return errors.New("feature has no geometry")
+		}
+		return multiPolygon.add(*object.Geometry)
+	case "FeatureCollection":
+		for _, feature := range object.Features {
+			if err := multiPolygon.add(feature); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New("unsupported GeoJSON type " + object.Type)
+	}
+}
+
+// addPolygon adds a polygon to the MultiPolygon after checking that its
+// rings are closed.
+func (multiPolygon *MultiPolygon) addPolygon(polygon [][][2]float64) error {
+	if len(polygon) == 0 {
+		return errors.New("polygon has no rings")
+	}
+
+	for _, ring := range polygon {
+		if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+			return errors.New("polygon rings must be closed with at least 4 positions")
+		}
+	}
+
+	multiPolygon.Coordinates = append(multiPolygon.Coordinates, polygon)
+	return nil
+}
+
+// JSON returns the MultiPolygon as a GeoJSON string.
+func (multiPolygon MultiPolygon) JSON() string {
+	data, _ := json.Marshal(multiPolygon)
+	return string(data)
+}
@@ -0,0 +1,46 @@
+// geojson_test.go contains tests that test geojson.go
+package api
+
+import "testing"
+
+// TestParseGeoJSONPolygon calls api.ParseGeoJSONPolygon and checks that
+// polygons, multipolygons and features are read into a MultiPolygon.
+func TestParseGeoJSONPolygon(t *testing.T) {
+	square := `[[[174.7,-36.9],[174.8,-36.9],[174.8,-36.8],[174.7,-36.8],[174.7,-36.9]]]`
+	tests := map[string]int{
+		`{"type":"Polygon","coordinates":` + square + `}`:                                               1,
+		`{"type":"MultiPolygon","coordinates":[` + square + `,` + square + `]}`:                         2,
+		`{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":` + square + `}}`: 1,
+		`{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon","coordinates":` +
+			square + `}},{"type":"Feature","geometry":{"type":"Polygon","coordinates":` + square + `}}]}`: 2,
+	}
+
+	for test, expected := range tests {
+		polygon, err := ParseGeoJSONPolygon([]byte(test))
+		if err != nil || len(polygon.Coordinates) != expected {
+			t.Fatalf(
+				`TestParseGeoJSONPolygon did not return %d polygons got %d, %v`,
+				expected, len(polygon.Coordinates), err,
+			)
+		}
+	}
+
+	expected := `{"type":"MultiPolygon","coordinates":[` + square + `]}`
+	polygon, _ := ParseGeoJSONPolygon([]byte(`{"type":"Polygon","coordinates":` + square + `}`))
+	if polygon.JSON() != expected {
+		t.Fatalf(`TestParseGeoJSONPolygon did not return %s got %s`, expected, polygon.JSON())
+	}
+
+	invalid := []string{
+		`{"type":"Point","coordinates":[174.7,-36.9]}`,
+		`{"type":"Polygon","coordinates":[[[174.7,-36.9],[174.8,-36.9],[174.8,-36.8]]]}`,
+		`{"type":"Polygon","coordinates":[[["174.7'); DROP TABLE outage; --",-36.9]]]}`,
+		`{"type":"FeatureCollection","features":[]}`,
+		`not json`,
+	}
+	for _, test := range invalid {
+		if _, err := ParseGeoJSONPolygon([]byte(test)); err == nil {
+			t.Fatalf(`TestParseGeoJSONPolygon did not reject %s`, test)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// grid.go creates the controller function that counts outages per cell
+// of a square or hexagon grid, such as for heatmaps.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// MinGridCell is the smallest cell size (in m) of a grid.
+const MinGridCell = 100.0
+
+// MaxGridCells is the largest number of cells with outages returned by
+// the grid API.
+const MaxGridCells = 10000
+
+// A GridCell struct maps the counts of outages in a cell of a grid.
+type GridCell struct {
+	I            int     `json:"i"`
+	J            int     `json:"j"`
+	TotalOutages int     `json:"total_outages"`
+	TotalHours   float64 `json:"total_hours"`
+}
+
+// A GridFeature struct maps a cell of a grid as a GeoJSON feature.
+type GridFeature struct {
+	Type       string          `json:"type"`
+	Geometry   json.RawMessage `json:"geometry"`
+	Properties GridCell        `json:"properties"`
+}
+
+// A GridFeatureCollection struct maps a grid as a GeoJSON feature
+// collection.
+type GridFeatureCollection struct {
+	Type     string        `json:"type"`
+	Cell     float64       `json:"cell"`
+	Shape    string        `json:"shape"`
+	Features []GridFeature `json:"features"`
+}
+
+// GetGridParams returns the cell size (in m) and shape (square or hex) of
+// a grid from the cell and shape parameters. The defaults are 500 m
+// squares.
+func GetGridParams(cellParam, shapeParam string) (cell float64, shape string, err error) {
+	cell, shape = 500, "square"
+
+	if cellParam != "" {
+		if cell, err = ParseDistance(cellParam); err != nil {
+			return
+		}
+		if cell < MinGridCell {
+			err = fmt.Errorf("cell must be at least %gm", MinGridCell)
+			return
+		}
+	}
+
+	if shapeParam != "" {
+		shape = strings.ToLower(shapeParam)
+		if shape != "square" && shape != "hex" {
+			err = fmt.Errorf("unknown shape %q", shapeParam)
+		}
+	}
+	return
+}
+
+// MakeCellPolygon returns the WKT polygon of a cell of a grid centred on
+// the origin. Hexagons have flat tops and sides of the cell size.
+func MakeCellPolygon(cell float64, shape string) string {
+	var corners [][2]float64
+	if shape == "hex" {
+		height := cell * math.Sqrt(3) / 2
+		corners = [][2]float64{
+			{-cell, 0}, {-cell / 2, -height}, {cell / 2, -height},
+			{cell, 0}, {cell / 2, height}, {-cell / 2, height}, {-cell, 0},
+		}
+	} else {
+		half := cell / 2
+		corners = [][2]float64{
+			{-half, -half}, {half, -half}, {half, half}, {-half, half}, {-half, -half},
+		}
+	}
+
+	points := make([]string, len(corners))
+	for i, corner := range corners {
+		points[i] = fmt.Sprintf("%f %f", corner[0], corner[1])
+	}
+	return "POLYGON((" + strings.Join(points, ", ") + "))"
+}
+
+// MakeGridCellSQL returns the SQL select of the indices (i, j) and the
+// centre (x, y) of the cell of a grid that contains the point (x, y) of
+// the points table, so that each point is counted in exactly one cell.
+// Columns of hexagons are 1.5 cells apart, and odd columns are shifted up
+// by half a hexagon, so the cell is the nearest centre of the two columns
+// the point is between.
+func MakeGridCellSQL(cell float64, shape string) string {
+	if shape != "hex" {
+		return fmt.Sprintf(
+			`SELECT floor(points.x / %[1]f)::int AS i, floor(points.y / %[1]f)::int AS j,
+			(floor(points.x / %[1]f) + 0.5) * %[1]f AS x,
+			(floor(points.y / %[1]f) + 0.5) * %[1]f AS y`,
+			cell,
+		)
+	}
+
+	width, height := 1.5*cell, cell*math.Sqrt(3)
+	return fmt.Sprintf(
+		`SELECT i, j, x, y FROM (
+			SELECT i, j, i * %[1]f AS x, j * %[2]f + (i %% 2 <> 0)::int * %[2]f / 2 AS y
+			FROM (
+				SELECT i, round((points.y - (i %% 2 <> 0)::int * %[2]f / 2) / %[2]f)::int AS j
+				FROM (VALUES (floor(points.x / %[1]f)::int), 
+				(floor(points.x / %[1]f)::int + 1)) AS columns (i)
+			) candidates
+		) centres
+		ORDER BY (points.x - x) ^ 2 + (points.y - y) ^ 2 LIMIT 1`,
+		width, height,
+	)
+}
+
+// MakeGridQuery returns an SQL query that counts the outages matching the
+// SQL WHERE string per cell of a grid, with their total hours by the SQL
+// expression of hours. Only cells with outages are returned, up to
+// MaxGridCells + 1 of them so that larger grids can be rejected.
+func MakeGridQuery(where, hours string, cell float64, shape string) string {
+	return fmt.Sprintf(
+		`WITH points AS (
+			SELECT ST_X(geom) AS x, ST_Y(geom) AS y, hours FROM (
+				SELECT ST_Transform(location::geometry, %d) AS geom, 
+				%s AS hours FROM outage %s
+			) outage
+		), cells AS (
+			SELECT cell.i, cell.j, cell.x, cell.y, points.hours 
+			FROM points CROSS JOIN LATERAL (%s) AS cell
+		)
+		SELECT ST_AsGeoJSON(ST_Transform(ST_Translate(
+			ST_GeomFromText('%s', %d), MIN(x), MIN(y)), 4326)), i, j, 
+		count(*) AS total_outages, COALESCE(SUM(hours), 0) AS total_hours
+		FROM cells GROUP BY i, j LIMIT %d`,
+		MetricSRID, hours, where, MakeGridCellSQL(cell, shape),
+		MakeCellPolygon(cell, shape), MetricSRID, MaxGridCells+1,
+	)
+}
+
+// CountGridOutages JSON-encodes the counts and total hours of outages per
+// cell of a grid as a GeoJSON feature collection.
+func CountGridOutages(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received CountGridOutages request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	params := GetRequestParams(r)
+	cell, shape, err := GetGridParams(params.Get("cell"), params.Get("shape"))
+	if err != nil {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3460,
+			Message:   "invalid grid",
+			Details:   "The grid parameters were invalid: " + err.Error() + ".",
+		})
+		return
+	}
+
+	query, filter, _ := MakeQuery(params, true)
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	main := MakeGridQuery(filter, query.Hours, cell, shape)
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	grid := GridFeatureCollection{
+		Type: "FeatureCollection", Cell: cell, Shape: shape,
+		Features: []GridFeature{},
+	}
+	for rows.Next() {
+		var geometry string
+		feature := GridFeature{Type: "Feature"}
+
+		err = rows.Scan(&geometry, &feature.Properties.I, &feature.Properties.J,
+			&feature.Properties.TotalOutages, &feature.Properties.TotalHours)
+		if err != nil {
+			log.Println(err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3461,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+
+		feature.Geometry = json.RawMessage(geometry)
+		grid.Features = append(grid.Features, feature)
+	}
+
+	if len(grid.Features) > MaxGridCells {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3462,
+			Message:   "too many cells",
+			Details: fmt.Sprintf("The grid has more than %d cells with outages. "+
+				"Please use a larger cell or narrow down the outages.", MaxGridCells),
+		})
+		return
+	}
+
+	WriteJSON(w, grid)
+}
@@ -0,0 +1,71 @@
+// grid_test.go contains tests that test grid.go
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestGetGridParams calls api.GetGridParams and checks the cell size and
+// shape of valid and invalid parameters.
+func TestGetGridParams(t *testing.T) {
+	tests := map[[2]string]struct {
+		cell  float64
+		shape string
+	}{
+		{"", ""}:           {500, "square"},
+		{"1km", "hex"}:     {1000, "hex"},
+		{"250m", "Square"}: {250, "square"},
+	}
+
+	for test, expected := range tests {
+		cell, shape, err := GetGridParams(test[0], test[1])
+		if err != nil || cell != expected.cell || shape != expected.shape {
+			t.Fatalf(
+				`TestGetGridParams did not return %v, %s got %v, %s, %v`,
+				expected.cell, expected.shape, cell, shape, err,
+			)
+		}
+	}
+
+	for _, test := range [][2]string{{"10m", ""}, {"wide", ""}, {"", "triangle"}} {
+		if _, _, err := GetGridParams(test[0], test[1]); err == nil {
+			t.Fatalf(`TestGetGridParams did not reject %v`, test)
+		}
+	}
+}
+
+// TestMakeGridQuery calls api.MakeGridQuery and checks that the grid of
+// the given shape and cell size is used with the filter.
+func TestMakeGridQuery(t *testing.T) {
+	actual := MakeGridQuery(
+		" WHERE outage_type = 'Planned'", DurationHoursSQL("wall_clock", -1), 1000, "hex",
+	)
+
+	for _, expected := range []string{
+		"floor(points.x / 1500.000000)", "FROM outage  WHERE outage_type = 'Planned'",
+		"ST_Transform(location::geometry, 2193)", "LIMIT 10001",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Fatalf(`TestMakeGridQuery did not contain %s, got %s`, expected, actual)
+		}
+	}
+}
+
+// TestMakeCellPolygon calls api.MakeCellPolygon and checks the corners of
+// square and hexagon cells.
+func TestMakeCellPolygon(t *testing.T) {
+	tests := map[string]string{
+		"square": "POLYGON((-50.000000 -50.000000, 50.000000 -50.000000, " +
+			"50.000000 50.000000, -50.000000 50.000000, -50.000000 -50.000000))",
+		"hex": "POLYGON((-100.000000 0.000000, -50.000000 -86.602540, " +
+			"50.000000 -86.602540, 100.000000 0.000000, 50.000000 86.602540, " +
+			"-50.000000 86.602540, -100.000000 0.000000))",
+	}
+
+	for shape, expected := range tests {
+		if actual := MakeCellPolygon(100, shape); actual != expected {
+			t.Fatalf(`TestMakeCellPolygon did not return %s got %s`, expected, actual)
+		}
+	}
+}
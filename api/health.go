@@ -0,0 +1,176 @@
+// health.go contains the liveness and readiness checks of this app, used
+// by the Docker health check.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// DefaultIngestStaleAfter is the age of the last successful ingest after
+// which this app is degraded.
+const DefaultIngestStaleAfter = 2 * IngestInterval
+
+// HealthPaths are the paths of the health checks, which are neither
+// authenticated nor rate limited.
+var HealthPaths = []string{"/healthz", "/readyz"}
+
+// healthTimeout is the time the database has to answer a readiness check.
+const healthTimeout = 2 * time.Second
+
+// The statuses of health checks, from best to worst.
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+)
+
+// healthRanks orders the statuses of health checks from best to worst.
+var healthRanks = map[string]int{HealthOK: 0, HealthDegraded: 1, HealthUnavailable: 2}
+
+// A HealthCheck struct maps the status of a dependency of this app.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+// A HealthReport struct maps the worst status of the health checks, and
+// the checks themselves.
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// MakeHealthReport returns the report of health checks, whose status is
+// the worst status of the checks.
+func MakeHealthReport(checks []HealthCheck) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: checks}
+	for _, check := range checks {
+		if healthRanks[check.Status] > healthRanks[report.Status] {
+			report.Status = check.Status
+		}
+	}
+	return report
+}
+
+// GetIngestStaleAfter returns the age of the last successful ingest after
+// which this app is degraded, read from the INGEST_STALE_AFTER
+// environmental variable (such as 3h, defaults to 2h).
+func GetIngestStaleAfter() time.Duration {
+	if value := os.Getenv("INGEST_STALE_AFTER"); value != "" {
+		if staleAfter, err := ParseWindow(value); err == nil {
+			return staleAfter
+		}
+		log.Println("Invalid INGEST_STALE_AFTER:", value)
+	}
+	return DefaultIngestStaleAfter
+}
+
+// CheckDatabase returns whether the database can be reached, and whether
+// its schema version is the version expected by this build.
+func CheckDatabase(ctx context.Context) []HealthCheck {
+	db := database.DB()
+	if err := db.PingContext(ctx); err != nil {
+		return []HealthCheck{
+			{Name: "database", Status: HealthUnavailable, Details: err.Error()},
+			{Name: "schema", Status: HealthUnavailable, Details: "The database can't be reached."},
+		}
+	}
+
+	version, err := database.CurrentSchemaVersion(ctx, db)
+	if err != nil {
+		return []HealthCheck{
+			{Name: "database", Status: HealthOK},
+			{Name: "schema", Status: HealthUnavailable, Details: err.Error()},
+		}
+	}
+	return []HealthCheck{{Name: "database", Status: HealthOK}, CheckSchema(version)}
+}
+
+// CheckSchema returns whether a schema version is the version expected by
+// this build.
+func CheckSchema(version int) HealthCheck {
+	check := HealthCheck{Name: "schema", Status: HealthOK}
+	if version != database.SchemaVersion {
+		check.Status = HealthUnavailable
+		check.Details = fmt.Sprintf("The schema version is %d, expected %d.",
+			version, database.SchemaVersion)
+	}
+	return check
+}
+
+// CheckIngest returns whether the last successful ingest is older than
+// staleAfter. Without a successful ingest, its age is counted from when
+// this app started.
+func CheckIngest(lastSuccess, started time.Time, staleAfter time.Duration,
+	now time.Time) HealthCheck {
+	check := HealthCheck{Name: "ingest", Status: HealthOK}
+
+	since := lastSuccess
+	if since.IsZero() {
+		since = started
+		check.Details = "There has been no successful ingest yet."
+	} else {
+		check.Details = "The last successful ingest finished at " +
+			since.Format(time.RFC3339) + "."
+	}
+
+	if now.Sub(since) > staleAfter {
+		check.Status = HealthDegraded
+	}
+	return check
+}
+
+// GetHealthz reports that this app is alive.
+func GetHealthz(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, HealthReport{Status: HealthOK, Checks: []HealthCheck{}})
+}
+
+// GetReadyz reports whether this app is ready: the database can be
+// reached and has the expected schema version, and the outages were
+// successfully ingested recently. The status is 503 if this app is
+// unavailable, or 200 if it is ok or degraded.
+func GetReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	checks := append(CheckDatabase(ctx), CheckIngest(
+		LastSuccessfulIngest(), processStart, GetIngestStaleAfter(), time.Now(),
+	))
+	report := MakeHealthReport(checks)
+
+	if report.Status == HealthUnavailable {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	WriteJSON(w, report)
+}
+
+// RunHealthCheck requests the readiness check of a running server, and
+// returns the exit code of the Docker health check: 0 if it is ok or
+// degraded, or else 1.
+func RunHealthCheck(url string) int {
+	client := http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer response.Body.Close()
+
+	fmt.Println("Readiness check returned", response.Status)
+	if response.StatusCode != http.StatusOK {
+		return 1
+	}
+	return 0
+}
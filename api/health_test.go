@@ -0,0 +1,81 @@
+// health_test.go contains tests that test health.go
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// TestCheckIngest calls api.CheckIngest and checks that ingests older
+// than the threshold are degraded, counting from the start of this app
+// if there has been no successful ingest.
+func TestCheckIngest(t *testing.T) {
+	now := time.Date(2022, 6, 22, 9, 0, 0, 0, time.UTC)
+	started := now.Add(-3 * time.Hour)
+	tests := []struct {
+		lastSuccess, started time.Time
+		expected             string
+	}{
+		{now.Add(-30 * time.Minute), started, HealthOK},
+		{now.Add(-150 * time.Minute), started, HealthDegraded},
+		{time.Time{}, now.Add(-10 * time.Minute), HealthOK},
+		{time.Time{}, started, HealthDegraded},
+	}
+
+	for _, test := range tests {
+		actual := CheckIngest(test.lastSuccess, test.started, 2*time.Hour, now)
+		if actual.Status != test.expected || actual.Name != "ingest" {
+			t.Fatalf(`TestCheckIngest did not return %v for %v got %+v`,
+				test.expected, test.lastSuccess, actual)
+		}
+	}
+}
+
+// TestCheckSchema calls api.CheckSchema and checks that only the schema
+// version of this build is ok.
+func TestCheckSchema(t *testing.T) {
+	if actual := CheckSchema(database.SchemaVersion); actual.Status != HealthOK {
+		t.Fatalf(`TestCheckSchema did not return %v got %+v`, HealthOK, actual)
+	}
+	if actual := CheckSchema(database.SchemaVersion - 1); actual.Status != HealthUnavailable {
+		t.Fatalf(`TestCheckSchema did not return %v got %+v`, HealthUnavailable, actual)
+	}
+}
+
+// TestMakeHealthReport calls api.MakeHealthReport and checks that its
+// status is the worst status of the checks.
+func TestMakeHealthReport(t *testing.T) {
+	tests := map[string][]HealthCheck{
+		HealthOK:          {{Status: HealthOK}, {Status: HealthOK}},
+		HealthDegraded:    {{Status: HealthOK}, {Status: HealthDegraded}},
+		HealthUnavailable: {{Status: HealthUnavailable}, {Status: HealthDegraded}},
+	}
+
+	for expected, checks := range tests {
+		if actual := MakeHealthReport(checks); actual.Status != expected {
+			t.Fatalf(`TestMakeHealthReport did not return %v got %v`, expected, actual.Status)
+		}
+	}
+}
+
+// TestHealthPathsSkipAuth calls api.Authenticator.Middleware and checks
+// that health checks need no API key even when all other requests do.
+func TestHealthPathsSkipAuth(t *testing.T) {
+	auth := &Authenticator{
+		Store: memoryAPIKeyStore{}, Limiter: NewRateLimiter(),
+		AnonymousScopes: []string{}, IPRateLimit: 1,
+	}
+	handler := auth.Middleware(http.HandlerFunc(GetHealthz))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf(`TestHealthPathsSkipAuth did not return 200 got %v`, w.Code)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// hotspots.go contains the job that clusters outages at the same place,
+// and the controller function for the resulting hot-spots.
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+	"github.com/lib/pq"
+)
+
+// A Hotspot struct maps a cluster of outages at the same place, such as a
+// pipe that keeps failing.
+type Hotspot struct {
+	Region          string   `json:"region"`
+	ClusterID       int      `json:"cluster_id"`
+	Longitude       float64  `json:"longitude"`
+	Latitude        float64  `json:"latitude"`
+	TotalOutages    int      `json:"total_outages"`
+	TotalHours      float64  `json:"total_hours"`
+	FirstOccurrence string   `json:"first_occurrence"`
+	LastOccurrence  string   `json:"last_occurrence"`
+	Streets         []string `json:"streets"`
+}
+
+// GetHotspotSettings returns the DBSCAN settings used to cluster outages:
+// the largest distance (in m) between neighbouring outages of a cluster
+// and the least number of outages in a cluster. They are read from the
+// HOTSPOT_EPS and HOTSPOT_MIN_POINTS environmental variables, and default
+// to 50 m and 3 outages.
+func GetHotspotSettings() (eps float64, minPoints int) {
+	eps, minPoints = 50, 3
+
+	if value, err := ParseDistance(os.Getenv("HOTSPOT_EPS")); err == nil {
+		eps = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("HOTSPOT_MIN_POINTS")); err == nil && value > 0 {
+		minPoints = value
+	}
+	return
+}
+
+// A ClusterMember struct maps an outage to the cluster id it was saved
+// with and the cluster it is in after clustering again, where -1 is no
+// cluster.
+type ClusterMember struct {
+	ID       int
+	Region   string
+	Previous int
+	Cluster  int
+}
+
+// A clusterOverlap struct maps the number of outages of a new cluster
+// that were in a previous cluster.
+type clusterOverlap struct {
+	cluster, previous, outages int
+}
+
+// MatchClusterIDs returns the cluster id of each outage, keyed by the id
+// of the outage. Each new cluster of a region keeps the id of the previous
+// cluster it shares the most outages with, unless that id was kept by a
+// cluster that shares more. Other new clusters get ids after the largest
+// previous id of the region, so ids are stable between runs.
+func MatchClusterIDs(members []ClusterMember) map[int]int {
+	regions := map[string][]ClusterMember{}
+	for _, member := range members {
+		regions[member.Region] = append(regions[member.Region], member)
+	}
+
+	ids := map[int]int{}
+	for _, members := range regions {
+		counts := map[[2]int]int{}
+		nextID := 0
+		for _, member := range members {
+			if member.Cluster >= 0 && member.Previous >= 0 {
+				counts[[2]int{member.Cluster, member.Previous}]++
+			}
+			if member.Previous >= nextID {
+				nextID = member.Previous + 1
+			}
+		}
+
+		overlaps := make([]clusterOverlap, 0, len(counts))
+		for pair, outages := range counts {
+			overlaps = append(overlaps, clusterOverlap{pair[0], pair[1], outages})
+		}
+		sort.Slice(overlaps, func(i, j int) bool {
+			a, b := overlaps[i], overlaps[j]
+			if a.outages != b.outages {
+				return a.outages > b.outages
+			} else if a.cluster != b.cluster {
+				return a.cluster < b.cluster
+			}
+			return a.previous < b.previous
+		})
+
+		// Keep the previous ids of the largest overlaps first
+		matched, used := map[int]int{}, map[int]bool{}
+		for _, overlap := range overlaps {
+			if _, ok := matched[overlap.cluster]; !ok && !used[overlap.previous] {
+				matched[overlap.cluster] = overlap.previous
+				used[overlap.previous] = true
+			}
+		}
+
+		// Number the remaining clusters in order
+		var clusters []int
+		for _, member := range members {
+			if _, ok := matched[member.Cluster]; !ok && member.Cluster >= 0 {
+				matched[member.Cluster] = -1
+				clusters = append(clusters, member.Cluster)
+			}
+		}
+		sort.Ints(clusters)
+		for _, cluster := range clusters {
+			matched[cluster] = nextID
+			nextID++
+		}
+
+		for _, member := range members {
+			if member.Cluster >= 0 {
+				ids[member.ID] = matched[member.Cluster]
+			} else {
+				ids[member.ID] = -1
+			}
+		}
+	}
+	return ids
+}
+
+// ClusterOutages clusters the outages of each region across all history
+// with DBSCAN, and saves the cluster id of each outage. Outages that are
+// not part of a cluster have no cluster id. Clusters keep the ids of the
+// clusters they overlap from the previous run.
+func ClusterOutages() {
+	eps, minPoints := GetHotspotSettings()
+
+	// Open database
+	db := database.DB()
+
+	rows, err := db.Query(
+		`SELECT id, region, COALESCE(cluster_id, -1), COALESCE(ST_ClusterDBSCAN(
+			ST_Transform(location::geometry, $1), eps := $2, minpoints := $3
+		) OVER (PARTITION BY region), -1) FROM outage`,
+		MetricSRID, eps, minPoints,
+	)
+	if err != nil {
+		log.Println("Clustering outages failed:", err)
+		return
+	}
+	defer rows.Close()
+
+	var members []ClusterMember
+	for rows.Next() {
+		var member ClusterMember
+		err = rows.Scan(&member.ID, &member.Region, &member.Previous, &member.Cluster)
+		if err != nil {
+			log.Println("Clustering outages failed:", err)
+			return
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("Clustering outages failed:", err)
+		return
+	}
+
+	// Save the cluster ids that changed, or no cluster id for noise
+	var changed, clusterIDs, noise []int
+	ids := MatchClusterIDs(members)
+	for _, member := range members {
+		if id := ids[member.ID]; id == member.Previous {
+			continue
+		} else if id < 0 {
+			noise = append(noise, member.ID)
+		} else {
+			changed = append(changed, member.ID)
+			clusterIDs = append(clusterIDs, id)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Clustering outages failed:", err)
+		return
+	}
+	if _, err = tx.Exec(
+		`UPDATE outage SET cluster_id = NULL WHERE id = ANY($1)`, pq.Array(noise),
+	); err == nil {
+		_, err = tx.Exec(
+			`UPDATE outage SET cluster_id = clusters.cluster_id 
+			FROM unnest($1::int[], $2::int[]) AS clusters (id, cluster_id) 
+			WHERE outage.id = clusters.id`,
+			pq.Array(changed), pq.Array(clusterIDs),
+		)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Println("Clustering outages failed:", err)
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("Clustering outages failed:", err)
+		return
+	}
+
+	log.Println("Outages have been clustered.")
+}
+
+// GetHotspots JSON-encodes the clusters of outages, with the busiest
+// first. The outages counted in each cluster can be filtered by the same
+// parameters as GetOutages.
+func GetHotspots(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetHotspots request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	query := &Query{IsCount: true}
+	filter := query.MakeWhereStringWith(r.URL.Query(), "cluster_id IS NOT NULL")
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	main := `SELECT region, cluster_id, ST_X(centroid), ST_Y(centroid), 
+		total_outages, total_hours, first_occurrence, last_occurrence, streets 
+		FROM (
+			SELECT region, cluster_id, 
+			ST_Centroid(ST_Collect(location::geometry)) AS centroid, 
+			count(*) AS total_outages, COALESCE(SUM(` + query.Hours + `), 0) AS total_hours, 
+			MIN(start_date) AS first_occurrence, MAX(start_date) AS last_occurrence, 
+			array_agg(DISTINCT street) FILTER (WHERE street IS NOT NULL) AS streets 
+			FROM outage` + filter + ` GROUP BY region, cluster_id
+		) hotspots ORDER BY total_outages DESC, total_hours DESC LIMIT 100`
+
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		writeQueryError(w, err, 3465)
+		return
+	}
+	defer rows.Close()
+
+	hotspots := []Hotspot{}
+	for rows.Next() {
+		var hotspot Hotspot
+		var first, last sql.NullString
+		err = rows.Scan(&hotspot.Region, &hotspot.ClusterID, &hotspot.Longitude,
+			&hotspot.Latitude, &hotspot.TotalOutages, &hotspot.TotalHours,
+			&first, &last, pq.Array(&hotspot.Streets))
+		if err != nil {
+			log.Println(err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3465,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+
+		region := GetRegion(hotspot.Region)
+		hotspot.FirstOccurrence = region.FormatOutageTime(first.String)
+		hotspot.LastOccurrence = region.FormatOutageTime(last.String)
+		hotspots = append(hotspots, hotspot)
+	}
+
+	WriteJSON(w, hotspots)
+}
@@ -0,0 +1,58 @@
+// hotspots_test.go contains tests that test hotspots.go
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestGetHotspotSettings calls api.GetHotspotSettings and checks that the
+// environmental variables are used, or the defaults if they are invalid.
+func TestGetHotspotSettings(t *testing.T) {
+	tests := []struct {
+		eps, minPoints string
+		expectedEps    float64
+		expectedMin    int
+	}{
+		{"", "", 50, 3},
+		{"100m", "5", 100, 5},
+		{"0.2km", "2", 200, 2},
+		{"near", "-1", 50, 3},
+	}
+
+	for _, test := range tests {
+		t.Setenv("HOTSPOT_EPS", test.eps)
+		t.Setenv("HOTSPOT_MIN_POINTS", test.minPoints)
+
+		eps, minPoints := GetHotspotSettings()
+		if eps != test.expectedEps || minPoints != test.expectedMin {
+			t.Fatalf(
+				`TestGetHotspotSettings did not return %v, %d got %v, %d`,
+				test.expectedEps, test.expectedMin, eps, minPoints,
+			)
+		}
+	}
+}
+
+// TestMatchClusterIDs calls api.MatchClusterIDs and checks that clusters
+// keep the ids of the previous clusters they overlap most, and that new
+// clusters get ids after the largest previous id of their region.
+func TestMatchClusterIDs(t *testing.T) {
+	members := []ClusterMember{
+		// Previous clusters 4 and 7 are now numbered 1 and 0
+		{1, "auckland", 4, 1}, {2, "auckland", 4, 1},
+		{3, "auckland", 7, 0}, {4, "auckland", 7, 0},
+		// Cluster 2 also overlaps cluster 7, but less than cluster 0 does
+		{5, "auckland", 7, 2}, {6, "auckland", -1, 2},
+		// An outage that is no longer clustered, and a new cluster
+		{7, "auckland", 4, -1}, {8, "auckland", -1, 3},
+		{9, "wellington", -1, 0}, {10, "wellington", -1, 0},
+	}
+
+	expected := map[int]int{
+		1: 4, 2: 4, 3: 7, 4: 7, 5: 8, 6: 8, 7: -1, 8: 9, 9: 0, 10: 0,
+	}
+	if actual := MatchClusterIDs(members); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf(`TestMatchClusterIDs did not return %v got %v`, expected, actual)
+	}
+}
@@ -0,0 +1,96 @@
+// ingest.go contains the hourly ingest of the outage APIs, and the jobs
+// that run after each ingest such as clustering outages.
+package api
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// An IngestReport struct maps the result of an ingest of the outage APIs
+// of all enabled regions.
+type IngestReport struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Fetched is the number of outages listed by the API of each region.
+	Fetched map[string]int
+	// Changed is the number of outages that were created, updated or
+	// resolved, and Events are their events.
+	Changed int64
+	Events  []OutageEvent
+	// Errors maps the regions whose outages could not be fetched or
+	// saved to the error.
+	Errors map[string]error
+}
+
+// IngestInterval is the wait between the end of an ingest and the start
+// of the next one.
+const IngestInterval = time.Hour
+
+// An IngestJob runs after each ingest.
+type IngestJob func(report IngestReport)
+
+var (
+	ingestJobs  []IngestJob
+	ingestMutex sync.RWMutex
+	lastIngest  IngestReport
+	hasIngested bool
+	nextIngest  time.Time
+	// lastSuccess is when the last ingest without errors finished, and
+	// processStart when this app started.
+	lastSuccess  time.Time
+	processStart = time.Now()
+)
+
+// OnIngest adds a job that runs after each ingest, in the order the jobs
+// were added.
+func OnIngest(job IngestJob) {
+	ingestJobs = append(ingestJobs, job)
+}
+
+// Ingest retrieves the latest outages of all enabled regions into the
+// database, then runs the ingest jobs.
+func Ingest() IngestReport {
+	report := UpdateOutages()
+
+	for _, job := range ingestJobs {
+		job(report)
+	}
+
+	ingestMutex.Lock()
+	lastIngest, hasIngested = report, true
+	if len(report.Errors) == 0 {
+		lastSuccess = report.FinishedAt
+	}
+	nextIngest = time.Now().Add(IngestInterval)
+	ingestMutex.Unlock()
+
+	log.Printf("Ingest finished in %v with %d created, updated or resolved outages.",
+		report.FinishedAt.Sub(report.StartedAt), report.Changed)
+	return report
+}
+
+// LastIngest returns the report of the last ingest, and false if there
+// has been none yet.
+func LastIngest() (IngestReport, bool) {
+	ingestMutex.RLock()
+	defer ingestMutex.RUnlock()
+	return lastIngest, hasIngested
+}
+
+// NextIngest returns the time the next ingest is scheduled to start, or
+// the zero time if there has been no ingest yet.
+func NextIngest() time.Time {
+	ingestMutex.RLock()
+	defer ingestMutex.RUnlock()
+	return nextIngest
+}
+
+// LastSuccessfulIngest returns when the last ingest without errors
+// finished, or the zero time if there has been none yet.
+func LastSuccessfulIngest() time.Time {
+	ingestMutex.RLock()
+	defer ingestMutex.RUnlock()
+	return lastSuccess
+}
@@ -18,6 +18,18 @@ var suburb_abbreviations = map[string]string{
 	"mt": "Mount", "pt": "Point", "st": "Saint", "cbd": "Central",
 }
 
+// street_start_abbreviations maps common abbreviations at the start of a street name to their
+// uncondensed counterpart.
+var street_start_abbreviations = map[string]string{
+	"mt": "Mount", "pt": "Point", "st": "Saint", "cbd": "Central", "gt": "Great",
+}
+
+// auckland_casing_exceptions is a slice of words in Auckland street and suburb names that do not
+// follow title-case.
+var auckland_casing_exceptions = []string{
+	"MacKelvie", "MacKenzie", "MacDonald", "MacMurray", "MacLaurin",
+}
+
 // suburbs is a slice of most Auckland suburbs.
 var suburbs = []string{
 	"woodhill forest", "wiri", "windsor park", "whitford", "whenuapai", "wharehine", "whangaripo",
@@ -0,0 +1,31 @@
+// location_regions.go contains slices of suburbs of regions other than Auckland.
+package api
+
+// wellington_suburbs is a slice of most Wellington, Hutt Valley and Porirua suburbs.
+var wellington_suburbs = []string{
+	"wellington central", "mount victoria", "mount cook", "oriental bay", "aro valley", "te aro", "thorndon",
+	"pipitea", "kelburn", "northland", "wadestown", "wilton", "crofton downs", "ngaio", "khandallah",
+	"broadmeadows", "johnsonville", "churton park", "glenside", "grenada village", "grenada north", "paparangi",
+	"newlands", "woodridge", "horokiwi", "tawa", "linden", "takapu valley", "karori", "makara", "brooklyn",
+	"vogeltown", "mornington", "kingston", "southgate", "island bay", "owhiro bay", "berhampore", "newtown",
+	"houghton bay", "lyall bay", "kilbirnie", "rongotai", "miramar", "maupuia", "seatoun", "breaker bay",
+	"karaka bays", "strathmore park", "hataitai", "roseneath", "melrose", "highbury", "lower hutt central",
+	"petone", "alicetown", "korokoro", "maungaraki", "normandale", "belmont", "eastbourne", "wainuiomata",
+	"naenae", "taita", "stokes valley", "avalon", "epuni", "waterloo", "woburn", "boulcott", "moera",
+	"upper hutt central", "trentham", "silverstream", "heretaunga", "totara park", "wallaceville",
+	"porirua central", "titahi bay", "whitby", "paremata", "plimmerton", "cannons creek", "waitangirua",
+	"ascot park", "aotea", "pukerua bay", "elsdon", "takapuwahia", "mana",
+}
+
+// christchurch_suburbs is a slice of most Christchurch suburbs.
+var christchurch_suburbs = []string{
+	"christchurch central", "upper riccarton", "riccarton", "addington", "sydenham", "spreydon", "hoon hay",
+	"halswell", "hornby", "sockburn", "wigram", "hei hei", "islington", "templeton", "avonhead", "ilam",
+	"fendalton", "merivale", "strowan", "papanui", "st albans", "mairehau", "shirley", "dallington", "burwood",
+	"avondale", "aranui", "bexley", "north new brighton", "south new brighton", "new brighton", "southshore",
+	"parklands", "waimairi beach", "linwood", "phillipstown", "woolston", "opawa", "st martins", "beckenham",
+	"cashmere", "somerfield", "huntsbury", "mount pleasant", "redcliffs", "sumner", "heathcote valley",
+	"ferrymead", "bromley", "richmond", "edgeware", "bryndwr", "burnside", "harewood", "bishopdale", "northcote",
+	"casebrook", "redwood", "belfast", "northwood", "marshland", "lyttelton", "waltham", "hillsborough",
+	"cracroft", "westmorland", "aidanfield", "middleton", "yaldhurst",
+}
@@ -0,0 +1,184 @@
+// mailer.go contains the mailers that send emails such as digests, over
+// SMTP or to a file or the log for testing.
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMailTimeout is the time an SMTP server is given to send an
+// email, from dialing it to quitting.
+const DefaultMailTimeout = 30 * time.Second
+
+// A MailMessage struct maps a plain text email.
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// A Mailer sends emails.
+type Mailer interface {
+	Send(message MailMessage) error
+}
+
+// An SMTPMailer struct maps an SMTP server that sends emails from the
+// From address. The server is logged into if a username is given, and
+// must send each email within Timeout (DefaultMailTimeout if zero).
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+// Send sends an email through the SMTP server, upgrading the connection to
+// TLS if the server supports it like smtp.SendMail. Unlike smtp.SendMail,
+// a server that stops responding fails the email once the timeout passes.
+func (mailer SMTPMailer) Send(message MailMessage) error {
+	timeout := mailer.Timeout
+	if timeout == 0 {
+		timeout = DefaultMailTimeout
+	}
+
+	conn, err := net.DialTimeout(
+		"tcp", net.JoinHostPort(mailer.Host, mailer.Port), timeout,
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, mailer.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: mailer.Host}); err != nil {
+			return err
+		}
+	}
+	if mailer.Username != "" {
+		auth := smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(mailer.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(
+		FormatMailMessage(mailer.From, message, time.Now()),
+	); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// A FileMailer struct maps a file that emails are appended to instead of
+// being sent, such as for testing offline.
+type FileMailer struct {
+	Path  string
+	From  string
+	mutex sync.Mutex
+}
+
+// Send appends an email to the file.
+func (mailer *FileMailer) Send(message MailMessage) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	file, err := os.OpenFile(mailer.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(
+		FormatMailMessage(mailer.From, message, time.Now()), "\r\n"...,
+	))
+	return err
+}
+
+// A LogMailer logs emails instead of sending them.
+type LogMailer struct{}
+
+// Send logs an email.
+func (LogMailer) Send(message MailMessage) error {
+	log.Printf("Email to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// NewMailer returns the mailer set up by the environmental variables: an
+// SMTPMailer if MAIL_SMTP_HOST is set, a FileMailer if MAIL_FILE is set,
+// or else a LogMailer.
+func NewMailer() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	if host := os.Getenv("MAIL_SMTP_HOST"); host != "" {
+		port := os.Getenv("MAIL_SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return SMTPMailer{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("MAIL_SMTP_USER"),
+			Password: os.Getenv("MAIL_SMTP_PASS"),
+			From:     from,
+		}
+	}
+
+	if path := os.Getenv("MAIL_FILE"); path != "" {
+		return &FileMailer{Path: path, From: from}
+	}
+	return LogMailer{}
+}
+
+// FormatMailMessage returns an email in the Internet Message Format (RFC
+// 5322) with a UTF-8 plain text body and CRLF line breaks.
+func FormatMailMessage(from string, message MailMessage, date time.Time) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + message.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+
+	body := strings.Replace(message.Body, "\r\n", "\n", -1)
+	body = strings.Replace(body, "\n", "\r\n", -1)
+	return []byte(fmt.Sprintf("%s\r\n\r\n%s", strings.Join(headers, "\r\n"), body))
+}
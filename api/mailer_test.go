@@ -0,0 +1,88 @@
+// mailer_test.go contains tests that test mailer.go
+package api
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFormatMailMessage calls api.FormatMailMessage and checks the
+// headers and CRLF line breaks of an email.
+func TestFormatMailMessage(t *testing.T) {
+	date := time.Date(2022, 6, 22, 9, 0, 0, 0, time.UTC)
+	actual := string(FormatMailMessage("noreply@example.com", MailMessage{
+		To: "ops@example.com", Subject: "Outages — Remuera", Body: "Line 1\nLine 2\n",
+	}, date))
+	expected := "From: noreply@example.com\r\n" +
+		"To: ops@example.com\r\n" +
+		"Subject: =?utf-8?q?Outages_=E2=80=94_Remuera?=\r\n" +
+		"Date: Wed, 22 Jun 2022 09:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Line 1\r\nLine 2\r\n"
+
+	if actual != expected {
+		t.Fatalf(`TestFormatMailMessage did not return %q got %q`, expected, actual)
+	}
+}
+
+// TestFileMailer calls api.FileMailer.Send and checks that emails are
+// appended to the file.
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer := &FileMailer{Path: filepath.Join(dir, "mail.txt"), From: "noreply@example.com"}
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := mailer.Send(MailMessage{To: to, Subject: "Digest", Body: "Hi"}); err != nil {
+			t.Fatalf(`TestFileMailer did not send the email got %v`, err)
+		}
+	}
+
+	actual, _ := ioutil.ReadFile(mailer.Path)
+	if strings.Count(string(actual), "Subject: Digest\r\n") != 2 ||
+		!strings.Contains(string(actual), "To: b@example.com\r\n") {
+		t.Fatalf(`TestFileMailer did not append 2 emails got %q`, actual)
+	}
+}
+
+// TestSMTPMailerTimeout calls api.SMTPMailer.Send and checks that an SMTP
+// server that never responds fails the email once the timeout passes.
+func TestSMTPMailerTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			<-done
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	mailer := SMTPMailer{
+		Host: host, Port: port, From: "noreply@example.com",
+		Timeout: 100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	err = mailer.Send(MailMessage{To: "ops@example.com", Subject: "Digest", Body: "Hi"})
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Fatalf(`TestSMTPMailerTimeout did not return a timeout got %v after %v`,
+			err, time.Since(start))
+	}
+}
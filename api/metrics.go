@@ -0,0 +1,340 @@
+// metrics.go contains the counters, gauges and histograms of this app,
+// such as the requests of each route and the results of each ingest, and
+// the controller function that writes them in the Prometheus text format.
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+	"github.com/gorilla/mux"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the buckets of
+// request and fetch latencies.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The kinds of metrics.
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+)
+
+// A MetricsRegistry struct maps the metrics of this app by their name.
+type MetricsRegistry struct {
+	mutex   sync.Mutex
+	metrics map[string]*Metric
+}
+
+// A Metric struct maps a counter, gauge or histogram, with a series of
+// values for each set of labels.
+type Metric struct {
+	Name    string
+	Help    string
+	Kind    string
+	Buckets []float64
+
+	registry *MetricsRegistry
+	series   map[string]*metricSeries
+}
+
+// A metricSeries struct maps the value of a counter or gauge, or the
+// bucket counts, sum and count of a histogram, for a set of labels.
+type metricSeries struct {
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+// NewMetricsRegistry returns a MetricsRegistry without any metrics.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{metrics: map[string]*Metric{}}
+}
+
+// Metrics is the registry of the metrics of this app.
+var Metrics = NewMetricsRegistry()
+
+var (
+	httpRequests = Metrics.Counter("water_http_requests_total",
+		"Number of HTTP requests by route, method and status.")
+	httpRequestDuration = Metrics.Histogram("water_http_request_duration_seconds",
+		"Latency of HTTP requests by route, method and status.", LatencyBuckets)
+	ingestRuns = Metrics.Counter("water_ingest_runs_total",
+		"Number of ingests by result (success, or error if any region failed).")
+	ingestLastSuccess = Metrics.Gauge("water_ingest_last_success_timestamp_seconds",
+		"Unix time the last ingest without errors finished.")
+	ingestFetched = Metrics.Counter("water_ingest_outages_fetched_total",
+		"Number of outages fetched from the outage API of each region.")
+	ingestEvents = Metrics.Counter("water_ingest_outage_events_total",
+		"Number of outages created, updated or resolved by ingests.")
+	upstreamFetchDuration = Metrics.Histogram("water_upstream_fetch_duration_seconds",
+		"Latency of fetching the outage API of each region.", LatencyBuckets)
+	upstreamFetchErrors = Metrics.Counter("water_upstream_fetch_errors_total",
+		"Number of failed fetches of the outage API of each region.")
+	activeOutages = Metrics.Gauge("water_active_outages",
+		"Number of outages listed by the outage API of each region at the last ingest.")
+	dbConnections = Metrics.Gauge("water_db_open_connections",
+		"Number of open database connections by state (in_use or idle).")
+	dbMaxOpenConnections = Metrics.Gauge("water_db_max_open_connections",
+		"Largest number of open database connections (0 is unlimited).")
+	dbWaitCount = Metrics.Gauge("water_db_wait_count",
+		"Number of times a query waited for a database connection.")
+	dbWaitDuration = Metrics.Gauge("water_db_wait_duration_seconds",
+		"Total time queries waited for a database connection.")
+	dbConnMaxIdleTime = Metrics.Gauge("water_db_conn_max_idle_time_seconds",
+		"Time after which idle database connections are closed.")
+	dbMaxIdleTimeClosed = Metrics.Gauge("water_db_max_idle_time_closed",
+		"Number of database connections closed for being idle too long.")
+)
+
+// register returns the metric of a name, adding it if it is new.
+func (registry *MetricsRegistry) register(name, help, kind string, buckets []float64) *Metric {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if metric, ok := registry.metrics[name]; ok {
+		return metric
+	}
+	metric := &Metric{
+		Name: name, Help: help, Kind: kind, Buckets: buckets,
+		registry: registry, series: map[string]*metricSeries{},
+	}
+	registry.metrics[name] = metric
+	return metric
+}
+
+// Counter returns a counter, which only goes up.
+func (registry *MetricsRegistry) Counter(name, help string) *Metric {
+	return registry.register(name, help, counterMetric, nil)
+}
+
+// Gauge returns a gauge, which is set to the latest value.
+func (registry *MetricsRegistry) Gauge(name, help string) *Metric {
+	return registry.register(name, help, gaugeMetric, nil)
+}
+
+// Histogram returns a histogram, which counts values into buckets by
+// their upper bounds.
+func (registry *MetricsRegistry) Histogram(name, help string, buckets []float64) *Metric {
+	return registry.register(name, help, histogramMetric, buckets)
+}
+
+// FormatLabels returns the labels of a series from pairs of label names
+// and values, such as {region="auckland"}.
+func FormatLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+escaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// getSeries returns the series of labels, adding it if it is new. The
+// registry must be locked.
+func (metric *Metric) getSeries(labels []string) *metricSeries {
+	key := FormatLabels(labels...)
+	series, ok := metric.series[key]
+	if !ok {
+		series = &metricSeries{counts: make([]uint64, len(metric.Buckets))}
+		metric.series[key] = series
+	}
+	return series
+}
+
+// Add adds a value to the counter of the labels, given as pairs of
+// label names and values.
+func (metric *Metric) Add(value float64, labels ...string) {
+	metric.registry.mutex.Lock()
+	defer metric.registry.mutex.Unlock()
+	metric.getSeries(labels).value += value
+}
+
+// Set sets the gauge of the labels to a value.
+func (metric *Metric) Set(value float64, labels ...string) {
+	metric.registry.mutex.Lock()
+	defer metric.registry.mutex.Unlock()
+	metric.getSeries(labels).value = value
+}
+
+// Observe counts a value into the histogram of the labels.
+func (metric *Metric) Observe(value float64, labels ...string) {
+	metric.registry.mutex.Lock()
+	defer metric.registry.mutex.Unlock()
+
+	series := metric.getSeries(labels)
+	for i, bound := range metric.Buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.value += value
+	series.count++
+}
+
+// WriteText writes the metrics in the Prometheus text format, sorted by
+// name and labels.
+func (registry *MetricsRegistry) WriteText(w io.Writer) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	names := make([]string, 0, len(registry.metrics))
+	for name := range registry.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		metric := registry.metrics[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, metric.Help, name, metric.Kind)
+
+		keys := make([]string, 0, len(metric.series))
+		for key := range metric.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := metric.series[key]
+			if metric.Kind != histogramMetric {
+				fmt.Fprintf(w, "%s%s %s\n", name, key, formatMetricValue(series.value))
+				continue
+			}
+
+			for i, bound := range metric.Buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name,
+					withLabel(key, "le", formatMetricValue(bound)), series.counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(key, "le", "+Inf"), series.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, key, formatMetricValue(series.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, key, series.count)
+		}
+	}
+}
+
+// withLabel returns formatted labels with another label at the end.
+func withLabel(labels, name, value string) string {
+	label := FormatLabels(name, value)
+	if labels == "" {
+		return label
+	}
+	return strings.TrimSuffix(labels, "}") + "," + strings.TrimPrefix(label, "{")
+}
+
+// formatMetricValue returns the shortest text of a value.
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// statusRecorder records the status of a response, passing on flushes
+// for streamed responses.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// MetricsMiddleware counts each request and its latency by the route
+// template (such as /outages/{id:[0-9]+}), method and status.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		labels := []string{
+			"route", route, "method", r.Method, "status", strconv.Itoa(recorder.status),
+		}
+		httpRequests.Add(1, labels...)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), labels...)
+	})
+}
+
+// ObserveIngest records the result of an ingest: the outages fetched and
+// listed by each region, and the outages created, updated or resolved.
+func ObserveIngest(report IngestReport) {
+	result := "success"
+	if len(report.Errors) > 0 {
+		result = "error"
+	} else {
+		ingestLastSuccess.Set(float64(report.FinishedAt.Unix()))
+	}
+	ingestRuns.Add(1, "result", result)
+
+	for region, fetched := range report.Fetched {
+		ingestFetched.Add(float64(fetched), "region", region)
+		if report.Errors[region] == nil {
+			activeOutages.Set(float64(fetched), "region", region)
+		}
+	}
+
+	for _, eventType := range []string{OutageCreated, OutageUpdated, OutageResolved} {
+		ingestEvents.Add(0, "type", eventType)
+	}
+	for _, event := range report.Events {
+		ingestEvents.Add(1, "type", event.Type)
+	}
+}
+
+// observeFetch records the latency and any error of fetching the outage
+// API of a region.
+func observeFetch(region string, start time.Time, err error) {
+	upstreamFetchDuration.Observe(time.Since(start).Seconds(), "region", region)
+	upstreamFetchErrors.Add(0, "region", region)
+	if err != nil {
+		upstreamFetchErrors.Add(1, "region", region)
+	}
+}
+
+// observeDBStats records the statistics of the pool of database
+// connections.
+func observeDBStats() {
+	stats := database.DB().Stats()
+	dbConnections.Set(float64(stats.InUse), "state", "in_use")
+	dbConnections.Set(float64(stats.Idle), "state", "idle")
+	dbMaxOpenConnections.Set(float64(stats.MaxOpenConnections))
+	dbWaitCount.Set(float64(stats.WaitCount))
+	dbWaitDuration.Set(stats.WaitDuration.Seconds())
+	dbConnMaxIdleTime.Set(database.Pool().ConnMaxIdleTime.Seconds())
+	dbMaxIdleTimeClosed.Set(float64(stats.MaxIdleTimeClosed))
+}
+
+// GetMetrics writes the metrics of this app in the Prometheus text
+// format.
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetMetrics request.")
+
+	observeDBStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	Metrics.WriteText(w)
+}
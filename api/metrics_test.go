@@ -0,0 +1,115 @@
+// metrics_test.go contains tests that test metrics.go
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TestMetricsRegistryWriteText calls api.MetricsRegistry.WriteText and
+// checks the Prometheus text format of a counter, gauge and histogram.
+func TestMetricsRegistryWriteText(t *testing.T) {
+	registry := NewMetricsRegistry()
+	registry.Counter("test_requests_total", "Requests.").Add(2, "route", "/", "status", "200")
+	registry.Gauge("test_active", "Active.").Set(7)
+	latency := registry.Histogram("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.05, "region", "auckland")
+	latency.Observe(0.5, "region", "auckland")
+	latency.Observe(3, "region", "auckland")
+
+	var actual strings.Builder
+	registry.WriteText(&actual)
+	expected := `# HELP test_active Active.
+# TYPE test_active gauge
+test_active 7
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{region="auckland",le="0.1"} 1
+test_latency_seconds_bucket{region="auckland",le="1"} 2
+test_latency_seconds_bucket{region="auckland",le="+Inf"} 3
+test_latency_seconds_sum{region="auckland"} 3.55
+test_latency_seconds_count{region="auckland"} 3
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/",status="200"} 2
+`
+
+	if actual.String() != expected {
+		t.Fatalf(`TestMetricsRegistryWriteText did not return %v got %v`, expected, actual.String())
+	}
+}
+
+// TestFormatLabels calls api.FormatLabels and checks that label values
+// are escaped.
+func TestFormatLabels(t *testing.T) {
+	actual := FormatLabels("suburb", `Say "hi"\`, "region", "auckland")
+	expected := `{suburb="Say \"hi\"\\",region="auckland"}`
+
+	if actual != expected {
+		t.Fatalf(`TestFormatLabels did not return %v got %v`, expected, actual)
+	}
+}
+
+// TestMetricsMiddleware calls api.MetricsMiddleware and checks that
+// requests are counted by their route template and status.
+func TestMetricsMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(MetricsMiddleware)
+	router.HandleFunc("/test/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, target := range []string{"/test/1", "/test/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	var actual strings.Builder
+	Metrics.WriteText(&actual)
+	expected := []string{
+		`water_http_requests_total{route="/test/{id:[0-9]+}",method="GET",status="418"} 2`,
+		`water_http_request_duration_seconds_count{route="/test/{id:[0-9]+}",method="GET",status="418"} 2`,
+	}
+	for _, part := range expected {
+		if !strings.Contains(actual.String(), part) {
+			t.Fatalf(`TestMetricsMiddleware did not return %v got %v`, part, actual.String())
+		}
+	}
+}
+
+// TestObserveIngest calls api.ObserveIngest and checks the metrics of
+// the outages fetched, listed and changed by an ingest.
+func TestObserveIngest(t *testing.T) {
+	ObserveIngest(IngestReport{
+		FinishedAt: time.Now(),
+		Fetched:    map[string]int{"testregion": 12, "failedregion": 3},
+		Events: []OutageEvent{
+			{Type: OutageCreated, Region: "testregion"},
+			{Type: OutageResolved, Region: "testregion"},
+		},
+		Errors: map[string]error{"failedregion": errors.New("saving failed")},
+	})
+
+	var actual strings.Builder
+	Metrics.WriteText(&actual)
+	expected := []string{
+		`water_ingest_runs_total{result="error"} 1`,
+		`water_ingest_outages_fetched_total{region="testregion"} 12`,
+		`water_active_outages{region="testregion"} 12`,
+		`water_ingest_outage_events_total{type="outage.resolved"} 1`,
+		`water_ingest_outage_events_total{type="outage.updated"} 0`,
+	}
+	for _, part := range expected {
+		if !strings.Contains(actual.String(), part) {
+			t.Fatalf(`TestObserveIngest did not return %v got %v`, part, actual.String())
+		}
+	}
+	if strings.Contains(actual.String(), `water_active_outages{region="failedregion"}`) {
+		t.Fatalf(`TestObserveIngest did not skip the failed region got %v`, actual.String())
+	}
+}
@@ -12,14 +12,17 @@ type WaterOutage struct {
 	StartDate  string  `json:"startDate"`
 	EndDate    string  `json:"endDate"`
 	OutageType string  `json:"outageType"`
+	Region     string  `json:"-"`
 }
 
 // A DBWaterOutage struct maps a water outage from the database of this app.
 type DBWaterOutage struct {
 	OutageID     int     `json:"outage_id,omitempty"`
+	Region       string  `json:"region,omitempty"`
 	Street       string  `json:"street,omitempty"`
 	Suburb       string  `json:"suburb,omitempty"`
 	Location     string  `json:"location,omitempty"`
+	RawLocation  string  `json:"raw_location,omitempty"`
 	OutageType   string  `json:"outage_type,omitempty"`
 	StartDate    string  `json:"start_date,omitempty"`
 	EndDate      string  `json:"end_date,omitempty"`
@@ -27,7 +30,29 @@ type DBWaterOutage struct {
 	UpdatedAt    string  `json:"updated_at,omitempty"`
 	TotalOutages int     `json:"total_outages,omitempty"`
 	TotalHours   float64 `json:"total_hours,omitempty"`
-	Status       bool    `json:"status"`
+	// The statistics of the hours of a group of outages are missing if no
+	// outage of the group has an end date (or only one, for StddevHours).
+	AvgHours    *float64 `json:"avg_hours,omitempty"`
+	MedianHours *float64 `json:"median_hours,omitempty"`
+	P90Hours    *float64 `json:"p90_hours,omitempty"`
+	MaxHours    *float64 `json:"max_hours,omitempty"`
+	MinHours    *float64 `json:"min_hours,omitempty"`
+	StddevHours *float64 `json:"stddev_hours,omitempty"`
+	DistanceM   *float64 `json:"distance_m,omitempty"`
+	// DurationHours is the hours of an outage by the selected duration
+	// model, which add up to the total_hours of counts.
+	DurationHours *float64 `json:"duration_hours,omitempty"`
+	// Population and Dwellings are the denominators of the rates, which
+	// are missing if the population of a suburb is unknown.
+	Population           *int64   `json:"population,omitempty"`
+	Dwellings            *int64   `json:"dwellings,omitempty"`
+	RatePer1000People    *float64 `json:"rate_per_1000_people,omitempty"`
+	RatePer1000Dwellings *float64 `json:"rate_per_1000_dwellings,omitempty"`
+	MissingDenominator   bool     `json:"missing_denominator,omitempty"`
+	// Recurring is true if the outage is at a place hit by multiple
+	// outages within a short time.
+	Recurring *bool `json:"recurring,omitempty"`
+	Status    bool  `json:"status"`
 }
 
 // DBWaterOutageCol returns a reference for a column of a DBWaterOutage
@@ -35,12 +60,16 @@ func DBWaterOutageCol(colname string, outage *DBWaterOutage) interface{} {
 	switch colname {
 	case "outage_id":
 		return &outage.OutageID
+	case "region":
+		return &outage.Region
 	case "street":
 		return &outage.Street
 	case "suburb":
 		return &outage.Suburb
 	case "location":
 		return &outage.Location
+	case "raw_location":
+		return &outage.RawLocation
 	case "start_date":
 		return &outage.StartDate
 	case "end_date":
@@ -55,6 +84,36 @@ func DBWaterOutageCol(colname string, outage *DBWaterOutage) interface{} {
 		return &outage.TotalOutages
 	case "total_hours":
 		return &outage.TotalHours
+	case "avg_hours":
+		return &outage.AvgHours
+	case "median_hours":
+		return &outage.MedianHours
+	case "p90_hours":
+		return &outage.P90Hours
+	case "max_hours":
+		return &outage.MaxHours
+	case "min_hours":
+		return &outage.MinHours
+	case "stddev_hours":
+		return &outage.StddevHours
+	case "distance_m":
+		return &outage.DistanceM
+	case "duration_hours":
+		return &outage.DurationHours
+	case "population":
+		return &outage.Population
+	case "dwellings":
+		return &outage.Dwellings
+	case "rate_per_1000_people":
+		return &outage.RatePer1000People
+	case "rate_per_1000_dwellings":
+		return &outage.RatePer1000Dwellings
+	case "missing_denominator":
+		return &outage.MissingDenominator
+	case "recurring":
+		return &outage.Recurring
+	case "status":
+		return &outage.Status
 	default:
 		panic("unknown column " + colname)
 	}
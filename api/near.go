@@ -0,0 +1,267 @@
+// near.go creates the controller function that finds outages near a
+// free-text address.
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// An AddressPoint struct maps a free-text address resolved to a point.
+type AddressPoint struct {
+	Address    string  `json:"address"`
+	Region     string  `json:"region"`
+	Street     string  `json:"street,omitempty"`
+	Suburb     string  `json:"suburb,omitempty"`
+	Resolution string  `json:"resolution"`
+	Longitude  float64 `json:"longitude"`
+	Latitude   float64 `json:"latitude"`
+}
+
+// A NearOutages struct maps the outages found near an address.
+type NearOutages struct {
+	AddressPoint
+	Radius  float64         `json:"radius"`
+	Outages []DBWaterOutage `json:"outages"`
+}
+
+// An AmbiguousAddressError lists the suburbs of a street when an address
+// without a suburb could be on the street in any of them.
+type AmbiguousAddressError []string
+
+func (suburbs AmbiguousAddressError) Error() string {
+	return "the street is in several suburbs: " + strings.Join(suburbs, ", ")
+}
+
+// ResolveAddressPoint resolves a free-text address to a point using the
+// outages saved in the database. The point is the centroid of previous
+// outages on the same street and suburb, or else the centroid of previous
+// outages in the same suburb. An address without a suburb, such as
+// "12 Queen Street", resolves to the centroid of the street if previous
+// outages put it in one suburb, or else returns an AmbiguousAddressError.
+func ResolveAddressPoint(
+	db *sql.DB, address string, region *Region) (point AddressPoint, err error) {
+	point.Address = address
+	point.Region = region.Name
+	street, suburb := region.AddressToStreetSuburb(address)
+
+	// Street centroid from historical outages
+	err = db.QueryRow(
+		`SELECT ST_X(centroid), ST_Y(centroid) FROM (
+			SELECT ST_Centroid(ST_Collect(location::geometry)) AS centroid
+			FROM outage WHERE region = $1 AND lower(street) = lower($2)
+			AND lower(suburb) = lower($3)
+		) street WHERE centroid IS NOT NULL`,
+		region.Name, street, suburb,
+	).Scan(&point.Longitude, &point.Latitude)
+	if err == nil {
+		point.Street, point.Suburb, point.Resolution = street, suburb, "street"
+		return
+	} else if err != sql.ErrNoRows {
+		return
+	}
+
+	// The suburb is not found when only a street or a suburb is given,
+	// such as "12 Queen Street" or "Remuera"
+	if strings.EqualFold(suburb, "Unknown") {
+		var suburbs []string
+		suburbs, err = resolveStreetPoint(db, &point, street, region.Name)
+		if err != nil || len(suburbs) == 1 {
+			return
+		} else if len(suburbs) > 1 {
+			err = AmbiguousAddressError(suburbs)
+			return
+		}
+
+		// Try the street as the suburb instead
+		suburb = street
+	}
+
+	// Suburb centroid from historical outages
+	err = db.QueryRow(
+		`SELECT ST_X(centroid), ST_Y(centroid) FROM (
+			SELECT ST_Centroid(ST_Collect(location::geometry)) AS centroid
+			FROM outage WHERE region = $1 AND lower(suburb) = lower($2)
+		) suburb WHERE centroid IS NOT NULL`,
+		region.Name, suburb,
+	).Scan(&point.Longitude, &point.Latitude)
+	if err == nil {
+		point.Suburb, point.Resolution = suburb, "suburb"
+	}
+	return
+}
+
+// resolveStreetPoint returns the suburbs of previous outages on a street
+// in any suburb. If there is only one, the point is set to the centroid
+// of the outages on the street.
+func resolveStreetPoint(db *sql.DB, point *AddressPoint,
+	street, region string) ([]string, error) {
+	rows, err := db.Query(
+		`SELECT suburb, ST_X(centroid), ST_Y(centroid) FROM (
+			SELECT MIN(suburb) AS suburb,
+			ST_Centroid(ST_Collect(location::geometry)) AS centroid
+			FROM outage WHERE region = $1 AND lower(street) = lower($2)
+			GROUP BY lower(suburb)
+		) street WHERE centroid IS NOT NULL ORDER BY suburb`,
+		region, street,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suburbs []string
+	var longitude, latitude float64
+	for rows.Next() {
+		var suburb string
+		if err := rows.Scan(&suburb, &longitude, &latitude); err != nil {
+			return nil, err
+		}
+		suburbs = append(suburbs, suburb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(suburbs) == 1 {
+		point.Street, point.Suburb, point.Resolution = street, suburbs[0], "street"
+		point.Longitude, point.Latitude = longitude, latitude
+	}
+	return suburbs, nil
+}
+
+// GetNearOutages JSON-encodes outages within a radius of a free-text
+// address, sorted by distance.
+func GetNearOutages(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetNearOutages request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	params := r.URL.Query()
+	regionName := strings.ToLower(params.Get("region"))
+	if regionName != "" && Regions[regionName] == nil {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3455,
+			Message:   "unknown region",
+			Details: "The region must be one of: " +
+				strings.Join(RegionNames(), ", ") + ".",
+		})
+		return
+	}
+
+	address := strings.TrimSpace(params.Get("address"))
+	if address == "" {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3450,
+			Message:   "no address set",
+			Details:   "The address parameter is needed to find outages near it.",
+		})
+		return
+	}
+
+	radius := 1000.0
+	if value := params.Get("radius"); value != "" {
+		var err error
+		if radius, err = ParseDistance(value); err != nil {
+			WriteAppError(w, http.StatusBadRequest, AppError{
+				ErrorCode: 3451,
+				Message:   "invalid radius",
+				Details:   "The radius must be a distance such as 500, 500m or 2km.",
+			})
+			return
+		}
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	// Resolve the address to a point
+	point, err := ResolveAddressPoint(db, address, GetRegion(regionName))
+	if err == sql.ErrNoRows {
+		WriteAppError(w, http.StatusNotFound, AppError{
+			ErrorCode: 3452,
+			Message:   "address not found",
+			Details:   "No outages have been recorded on the street or suburb of this address.",
+		})
+		return
+	} else if suburbs, ok := err.(AmbiguousAddressError); ok {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3454,
+			Message:   "ambiguous address",
+			Details: "Outages have been recorded on this street in several suburbs, " +
+				"so add one of them to the address: " + strings.Join(suburbs, ", ") + ".",
+		})
+		return
+	} else if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3453,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	// Get outages within the radius and any other filters, which are
+	// combined by the excl parameter while the radius always applies
+	params.Del("longitude")
+	params.Del("latitude")
+	params.Del("radius")
+	params["sort"] = []string{"distance"}
+	query := new(Query)
+	order := query.MakeOrderbyPaginationString(params)
+	filter := query.MakeWhereStringWith(params, query.MakeLocationRadiusWhere(
+		point.Longitude, point.Latitude, radius,
+	))
+	main := `SELECT ` + query.MakeSelectString(OutageColumns) +
+		` FROM outage` + filter + order
+
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	outages, err := ScanOutages(rows)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3441,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	FinishOutages(outages)
+
+	WriteJSON(w, NearOutages{
+		AddressPoint: point,
+		Radius:       radius,
+		Outages:      outages,
+	})
+}
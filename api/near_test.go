@@ -0,0 +1,22 @@
+// near_test.go contains tests that test near.go
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestGetNearOutagesRegion calls api.GetNearOutages and checks that
+// unknown regions are rejected with the names of the regions.
+func TestGetNearOutagesRegion(t *testing.T) {
+	w := httptest.NewRecorder()
+	GetNearOutages(w, httptest.NewRequest("GET", "/near?address=Queen+Street&region=atlantis", nil))
+
+	if w.Code != http.StatusBadRequest ||
+		!strings.Contains(w.Body.String(), "auckland, christchurch, wellington") ||
+		!strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "X-API-Key") {
+		t.Fatalf(`TestGetNearOutagesRegion did not return 400 got %v %v`, w.Code, w.Body.String())
+	}
+}
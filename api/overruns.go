@@ -0,0 +1,239 @@
+// overruns.go creates the controller function of the overrun report,
+// which compares the originally announced end of planned outages with
+// their final end.
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// DefaultOverrunTolerance is the number of minutes a planned outage may
+// end after (or before) its announced end and still be on time.
+const DefaultOverrunTolerance = 15
+
+// An Overrun struct maps the announced & final end of a planned outage.
+// OverrunHours is negative if the outage ended early. Verified is false if
+// the first snapshot was not recorded when the outage was first ingested,
+// so the end it announced may already have been revised.
+type Overrun struct {
+	OutageID     int     `json:"outage_id"`
+	Region       string  `json:"region"`
+	Suburb       string  `json:"suburb"`
+	Month        string  `json:"-"`
+	StartDate    string  `json:"start_date"`
+	AnnouncedEnd string  `json:"announced_end"`
+	FinalEnd     string  `json:"final_end"`
+	OverrunHours float64 `json:"overrun_hours"`
+	Verified     bool    `json:"-"`
+}
+
+// An OverrunSummary struct maps how often a number of planned outages
+// ended late, early or on time, and by how many hours.
+type OverrunSummary struct {
+	PlannedOutages     int     `json:"planned_outages"`
+	Late               int     `json:"late"`
+	Early              int     `json:"early"`
+	OnTime             int     `json:"on_time"`
+	LateShare          float64 `json:"late_share"`
+	AvgOverrunHours    float64 `json:"avg_overrun_hours"`
+	MedianOverrunHours float64 `json:"median_overrun_hours"`
+	MaxOverrunHours    float64 `json:"max_overrun_hours"`
+	TotalLateHours     float64 `json:"total_late_hours"`
+}
+
+// An OverrunGroup struct maps the overrun summary of the planned outages
+// of a suburb in a month (YYYY-MM).
+type OverrunGroup struct {
+	Suburb string `json:"suburb"`
+	Month  string `json:"month"`
+	OverrunSummary
+	Outages []Overrun `json:"outages,omitempty"`
+}
+
+// An OverrunReport struct maps the overrun summary of all planned
+// outages, and of each suburb & month. Unverified outages are counted
+// but not summarised.
+type OverrunReport struct {
+	ToleranceMinutes float64        `json:"tolerance_minutes"`
+	Unverified       int            `json:"unverified_outages"`
+	Total            OverrunSummary `json:"total"`
+	Groups           []OverrunGroup `json:"groups"`
+}
+
+// SummariseOverruns returns the overrun summary of planned outages. An
+// outage is late or early if it ended more than tolerance hours after or
+// before its announced end.
+func SummariseOverruns(overruns []Overrun, tolerance float64) OverrunSummary {
+	summary := OverrunSummary{PlannedOutages: len(overruns)}
+	if len(overruns) == 0 {
+		return summary
+	}
+
+	hours := make([]float64, len(overruns))
+	for i, overrun := range overruns {
+		hours[i] = overrun.OverrunHours
+		summary.AvgOverrunHours += overrun.OverrunHours / float64(len(overruns))
+		if i == 0 || overrun.OverrunHours > summary.MaxOverrunHours {
+			summary.MaxOverrunHours = overrun.OverrunHours
+		}
+
+		switch {
+		case overrun.OverrunHours > tolerance:
+			summary.Late++
+			summary.TotalLateHours += overrun.OverrunHours
+		case overrun.OverrunHours < -tolerance:
+			summary.Early++
+		default:
+			summary.OnTime++
+		}
+	}
+
+	summary.LateShare = float64(summary.Late) / float64(len(overruns))
+	summary.MedianOverrunHours = Median(hours)
+	return summary
+}
+
+// MakeOverrunReport groups verified overruns, ordered by suburb and
+// month, into a report. The outages of each group are only included if
+// withOutages is true.
+func MakeOverrunReport(overruns []Overrun, toleranceMinutes float64,
+	withOutages bool) OverrunReport {
+	var verified []Overrun
+	unverified := 0
+	for _, overrun := range overruns {
+		if overrun.Verified {
+			verified = append(verified, overrun)
+		} else {
+			unverified++
+		}
+	}
+	overruns = verified
+
+	tolerance := toleranceMinutes / 60
+	report := OverrunReport{
+		ToleranceMinutes: toleranceMinutes,
+		Unverified:       unverified,
+		Total:            SummariseOverruns(overruns, tolerance),
+		Groups:           []OverrunGroup{},
+	}
+
+	for start := 0; start < len(overruns); {
+		end := start
+		for end < len(overruns) && overruns[end].Suburb == overruns[start].Suburb &&
+			overruns[end].Month == overruns[start].Month {
+			end++
+		}
+
+		group := OverrunGroup{
+			Suburb:         overruns[start].Suburb,
+			Month:          overruns[start].Month,
+			OverrunSummary: SummariseOverruns(overruns[start:end], tolerance),
+		}
+		if withOutages {
+			group.Outages = overruns[start:end]
+		}
+		report.Groups = append(report.Groups, group)
+		start = end
+	}
+
+	return report
+}
+
+// GetOverrunReport JSON-encodes how often planned outages ended after
+// their originally announced end, per suburb and month. The announced
+// end is the end date of the first snapshot of an outage, which is only
+// verified if it was recorded when the outage was first ingested.
+func GetOverrunReport(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetOverrunReport request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := r.URL.Query()
+	tolerance := float64(DefaultOverrunTolerance)
+	if value := params.Get("tolerance"); value != "" {
+		var err error
+		if tolerance, err = strconv.ParseFloat(value, 64); err != nil || tolerance < 0 {
+			WriteAppError(w, http.StatusBadRequest, AppError{
+				ErrorCode: 3480,
+				Message:   "invalid tolerance",
+				Details:   "The tolerance must be a number of minutes.",
+			})
+			return
+		}
+	}
+
+	query := &Query{IsCount: true}
+	filter := query.MakeWhereStringWith(params,
+		"outage_type = 'Planned'", "announced_end IS NOT NULL", "end_date IS NOT NULL")
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	main := `SELECT outage_id, region, COALESCE(suburb, ''),
+		to_char(start_date, 'YYYY-MM'), start_date, announced_end, end_date,
+		(EXTRACT(EPOCH FROM end_date - announced_end) / 3600)::float,
+		COALESCE(announced_at <= first_ingested_at + interval '10 minutes', false)
+		FROM (
+			SELECT outage.*, announced.end_date AS announced_end,
+			announced.recorded_at AS announced_at FROM outage
+			LEFT JOIN LATERAL (
+				SELECT end_date, recorded_at FROM outage_snapshot
+				WHERE outage_snapshot.region = outage.region
+				AND outage_snapshot.outage_id = outage.outage_id
+				ORDER BY id LIMIT 1
+			) announced ON true
+		) AS outage` + filter + ` ORDER BY 3, 4, start_date, outage_id`
+
+	// Setup the database
+	db := database.DB()
+
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	var overruns []Overrun
+	for rows.Next() {
+		var overrun Overrun
+		if err := rows.Scan(&overrun.OutageID, &overrun.Region, &overrun.Suburb,
+			&overrun.Month, &overrun.StartDate, &overrun.AnnouncedEnd,
+			&overrun.FinalEnd, &overrun.OverrunHours, &overrun.Verified); err != nil {
+			log.Println(err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3481,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+
+		region := GetRegion(overrun.Region)
+		overrun.StartDate = region.FormatOutageTime(overrun.StartDate)
+		overrun.AnnouncedEnd = region.FormatOutageTime(overrun.AnnouncedEnd)
+		overrun.FinalEnd = region.FormatOutageTime(overrun.FinalEnd)
+		overruns = append(overruns, overrun)
+	}
+
+	WriteJSON(w, MakeOverrunReport(
+		overruns, tolerance, params.Get("outages") == "true",
+	))
+}
@@ -0,0 +1,43 @@
+// overruns_test.go contains tests that test overruns.go
+package api
+
+import "testing"
+
+// TestMakeOverrunReport calls api.MakeOverrunReport and checks the
+// summary of all verified outages and of each suburb & month.
+func TestMakeOverrunReport(t *testing.T) {
+	overruns := []Overrun{
+		{OutageID: 1, Suburb: "Ponsonby", Month: "2022-06", OverrunHours: 2, Verified: true},
+		{OutageID: 2, Suburb: "Ponsonby", Month: "2022-06", OverrunHours: 0.1, Verified: true},
+		{OutageID: 3, Suburb: "Ponsonby", Month: "2022-06", OverrunHours: -1, Verified: true},
+		{OutageID: 4, Suburb: "Ponsonby", Month: "2022-07", OverrunHours: 3, Verified: true},
+		{OutageID: 5, Suburb: "Remuera", Month: "2022-06", OverrunHours: 0, Verified: true},
+		{OutageID: 6, Suburb: "Remuera", Month: "2022-06", OverrunHours: 9},
+	}
+
+	actual := MakeOverrunReport(overruns, 15, false)
+	if actual.Unverified != 1 {
+		t.Fatalf(`TestMakeOverrunReport did not return 1 unverified outage got %v`, actual.Unverified)
+	}
+
+	total := actual.Total
+	if total.PlannedOutages != 5 || total.Late != 2 || total.Early != 1 || total.OnTime != 2 ||
+		total.LateShare != 0.4 || total.TotalLateHours != 5 || total.MaxOverrunHours != 3 ||
+		total.MedianOverrunHours != 0.1 {
+		t.Fatalf(`TestMakeOverrunReport did not summarise all outages got %+v`, total)
+	}
+
+	if len(actual.Groups) != 3 {
+		t.Fatalf(`TestMakeOverrunReport did not return 3 groups got %v`, len(actual.Groups))
+	}
+	first := actual.Groups[0]
+	if first.Suburb != "Ponsonby" || first.Month != "2022-06" || first.PlannedOutages != 3 ||
+		first.Late != 1 || first.Outages != nil {
+		t.Fatalf(`TestMakeOverrunReport did not summarise Ponsonby in June got %+v`, first)
+	}
+
+	actual = MakeOverrunReport(overruns, 15, true)
+	if outages := actual.Groups[2].Outages; len(outages) != 1 || outages[0].OutageID != 5 {
+		t.Fatalf(`TestMakeOverrunReport did not list outage 5 got %+v`, outages)
+	}
+}
@@ -0,0 +1,224 @@
+// population.go contains functions that import the population and number
+// of dwellings of suburbs, used to count outages per 1000 people or
+// dwellings.
+package api
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// A SuburbPopulation struct maps the population and number of dwellings
+// of a suburb. Either may be unknown.
+type SuburbPopulation struct {
+	Suburb     string
+	Population sql.NullInt64
+	Dwellings  sql.NullInt64
+}
+
+// PopulationColumns maps the columns of a population CSV file to the
+// (lowercase) headers they may have, such as the headers of Stats NZ
+// census tables.
+var PopulationColumns = map[string][]string{
+	"suburb": {
+		"suburb", "suburb_name", "area", "area_name", "name", "locality",
+		"sa2_name", "sa2_name_ascii", "sa2_v1_00_name", "sa2_v1_00_name_ascii",
+	},
+	"population": {
+		"population", "usually_resident_population", "census_usually_resident_population_count",
+		"population_count", "people",
+	},
+	"dwellings": {
+		"dwellings", "occupied_dwellings", "census_occupied_dwellings_count",
+		"total_dwellings", "dwelling_count", "households",
+	},
+}
+
+// normalisePopulationHeader returns a CSV header in lowercase, with
+// spaces and dashes replaced by underscores.
+func normalisePopulationHeader(header string) string {
+	header = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(header)
+}
+
+// parsePopulationCount returns a count from a CSV cell. Empty, negative
+// and suppressed counts (such as "..C" in Stats NZ tables) are unknown.
+func parsePopulationCount(cell string) sql.NullInt64 {
+	cell = strings.NewReplacer(",", "", " ", "").Replace(cell)
+	count, err := strconv.ParseInt(cell, 10, 64)
+	if err != nil || count < 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: count, Valid: true}
+}
+
+// ParsePopulationCSV reads the population and number of dwellings of
+// each suburb from a CSV file with a header row. The columns are found by
+// PopulationColumns, and a suburb column with a population and/or
+// dwellings column is needed. Suburb names are cleaned like the suburbs
+// of outages of the region, and the counts of rows with the same suburb
+// are added up.
+func ParsePopulationCSV(r io.Reader, region *Region) ([]SuburbPopulation, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, cell := range header {
+		cell = normalisePopulationHeader(cell)
+		for column, aliases := range PopulationColumns {
+			if _, ok := columns[column]; !ok && isStringInArray(cell, aliases) {
+				columns[column] = i
+			}
+		}
+	}
+
+	if _, ok := columns["suburb"]; !ok {
+		return nil, errors.New("population CSV has no suburb column")
+	}
+	_, hasPopulation := columns["population"]
+	_, hasDwellings := columns["dwellings"]
+	if !hasPopulation && !hasDwellings {
+		return nil, errors.New("population CSV has no population or dwellings column")
+	}
+
+	var populations []SuburbPopulation
+	bySuburb := map[string]int{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		cell := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		suburb := region.CleanAddressName(cell("suburb"), "suburb")
+		if suburb == "" {
+			continue
+		}
+
+		i, ok := bySuburb[strings.ToLower(suburb)]
+		if !ok {
+			i = len(populations)
+			bySuburb[strings.ToLower(suburb)] = i
+			populations = append(populations, SuburbPopulation{Suburb: suburb})
+		}
+
+		populations[i].Population = addPopulationCounts(
+			populations[i].Population, parsePopulationCount(cell("population")))
+		populations[i].Dwellings = addPopulationCounts(
+			populations[i].Dwellings, parsePopulationCount(cell("dwellings")))
+	}
+
+	return populations, nil
+}
+
+// addPopulationCounts adds up two counts, either of which may be unknown.
+func addPopulationCounts(a, b sql.NullInt64) sql.NullInt64 {
+	if !a.Valid {
+		return b
+	}
+	if b.Valid {
+		a.Int64 += b.Int64
+	}
+	return a
+}
+
+// ImportPopulation upserts the population and number of dwellings of the
+// suburbs of a region from a CSV file.
+func ImportPopulation(path, region string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	populations, err := ParsePopulationCSV(file, GetRegion(region))
+	if err != nil {
+		return err
+	}
+
+	// Open database
+	db := database.DB()
+
+	for _, population := range populations {
+		_, err = db.Exec(
+			`INSERT INTO suburb_population (region, suburb, population, dwellings)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (region, suburb) DO UPDATE SET
+			population = excluded.population, dwellings = excluded.dwellings`,
+			region, population.Suburb, population.Population, population.Dwellings,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Imported the population of %d %s suburbs.", len(populations), region)
+	return nil
+}
+
+// ImportConfiguredPopulation imports the population of suburbs from the
+// CSV file in the POPULATION_CSV environmental variable, if set. The
+// region of the suburbs defaults to the default region and can be changed
+// with the POPULATION_CSV_REGION environmental variable.
+func ImportConfiguredPopulation() {
+	path := os.Getenv("POPULATION_CSV")
+	if path == "" {
+		return
+	}
+
+	region := strings.ToLower(os.Getenv("POPULATION_CSV_REGION"))
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	if err := ImportPopulation(path, region); err != nil {
+		log.Println("Importing the population of suburbs failed:", err)
+	}
+}
+
+// GetSuburbPopulations returns the population and number of dwellings of
+// all suburbs of a region.
+func GetSuburbPopulations(db *sql.DB, region string) ([]SuburbPopulation, error) {
+	var populations []SuburbPopulation
+
+	rows, err := db.Query(
+		`SELECT suburb, population, dwellings FROM suburb_population 
+		WHERE region = $1 ORDER BY suburb`, region,
+	)
+	if err != nil {
+		return populations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var population SuburbPopulation
+		if err := rows.Scan(
+			&population.Suburb, &population.Population, &population.Dwellings,
+		); err != nil {
+			return populations, err
+		}
+		populations = append(populations, population)
+	}
+	return populations, rows.Err()
+}
@@ -0,0 +1,53 @@
+// population_test.go contains tests that test population.go
+package api
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParsePopulationCSV calls api.ParsePopulationCSV and checks that
+// Stats NZ headers are recognised, suburbs are cleaned and added up, and
+// suppressed counts are unknown.
+func TestParsePopulationCSV(t *testing.T) {
+	csv := "\ufeffSA2 Name,Census usually resident population count,Census occupied dwellings count\n" +
+		"Mt Eden,\"12,345\",4000\n" +
+		"Ponsonby,..C,3000\n" +
+		"Mt Eden,100,\n" +
+		",5,5\n"
+
+	actual, err := ParsePopulationCSV(strings.NewReader(csv), GetRegion(DefaultRegion))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []SuburbPopulation{
+		{
+			Suburb:     "Mount Eden",
+			Population: sql.NullInt64{Int64: 12445, Valid: true},
+			Dwellings:  sql.NullInt64{Int64: 4000, Valid: true},
+		},
+		{
+			Suburb:    "Ponsonby",
+			Dwellings: sql.NullInt64{Int64: 3000, Valid: true},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf(`TestParsePopulationCSV did not return %+v got %+v`, expected, actual)
+	}
+}
+
+// TestParsePopulationCSVColumns calls api.ParsePopulationCSV and checks
+// that files without a suburb or count column are rejected.
+func TestParsePopulationCSVColumns(t *testing.T) {
+	for _, csv := range []string{
+		"population,dwellings\n100,50\n",
+		"suburb,median_age\nPonsonby,35\n",
+	} {
+		if _, err := ParsePopulationCSV(strings.NewReader(csv), GetRegion(DefaultRegion)); err == nil {
+			t.Fatalf(`TestParsePopulationCSVColumns did not reject %q`, csv)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// ratelimit.go contains the token buckets that limit the number of
+// requests per minute of each API key and IP address.
+package api
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimitSweep is the time between removing the buckets that have
+// refilled, so that idle clients are forgotten.
+const rateLimitSweep = time.Minute
+
+// A RateLimit struct maps the result of taking a request from a bucket.
+// RetryAfter is the wait until the next request is allowed, and Reset
+// the wait until the bucket is full again.
+type RateLimit struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// A tokenBucket struct maps the requests left to a client, as of the
+// time it was updated.
+type tokenBucket struct {
+	tokens  float64
+	limit   int
+	updated time.Time
+}
+
+// A RateLimiter struct maps token buckets that each allow a number of
+// requests per minute. Buckets refill continuously, so a client may
+// burst up to its whole limit after being idle for a minute.
+type RateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter without any buckets.
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// Allow takes a request from the bucket of a client that is allowed
+// limit requests per minute. A limit of 0 or less is unlimited.
+func (limiter *RateLimiter) Allow(client string, limit int, now time.Time) RateLimit {
+	return limiter.check(client, limit, now, true)
+}
+
+// Peek returns whether the bucket of a client has a request left, like
+// Allow but without taking the request.
+func (limiter *RateLimiter) Peek(client string, limit int, now time.Time) RateLimit {
+	return limiter.check(client, limit, now, false)
+}
+
+// check returns whether the bucket of a client has a request left, and
+// takes it if take is true.
+func (limiter *RateLimiter) check(client string, limit int, now time.Time, take bool) RateLimit {
+	if limit <= 0 {
+		return RateLimit{Allowed: true}
+	}
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if now.Sub(limiter.swept) >= rateLimitSweep {
+		limiter.sweep(now)
+	}
+
+	bucket := limiter.buckets[client]
+	if bucket == nil || bucket.limit != limit {
+		bucket = &tokenBucket{tokens: float64(limit), limit: limit, updated: now}
+		limiter.buckets[client] = bucket
+	}
+
+	// Refill the tokens of the time since the bucket was updated
+	perSecond := float64(limit) / time.Minute.Seconds()
+	if elapsed := now.Sub(bucket.updated).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(float64(limit), bucket.tokens+elapsed*perSecond)
+		bucket.updated = now
+	}
+
+	result := RateLimit{Limit: limit}
+	if bucket.tokens >= 1 {
+		if take {
+			bucket.tokens--
+		}
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - bucket.tokens) / perSecond)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = secondsDuration((float64(limit) - bucket.tokens) / perSecond)
+	return result
+}
+
+// sweep removes the buckets that have refilled since they were updated.
+func (limiter *RateLimiter) sweep(now time.Time) {
+	for client, bucket := range limiter.buckets {
+		if now.Sub(bucket.updated) >= time.Minute {
+			delete(limiter.buckets, client)
+		}
+	}
+	limiter.swept = now
+}
+
+// secondsDuration returns the duration of a number of seconds.
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,63 @@
+// ratelimit_test.go contains tests that test ratelimit.go
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRateLimiterAllow calls api.RateLimiter.Allow and checks that a
+// bucket allows its limit in a burst, then refills over the minute.
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := NewRateLimiter()
+	now := time.Date(2022, 6, 22, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if actual := limiter.Allow("ip:1", 3, now); !actual.Allowed || actual.Remaining != 2-i {
+			t.Fatalf(`TestRateLimiterAllow did not allow request %d got %+v`, i+1, actual)
+		}
+	}
+
+	actual := limiter.Allow("ip:1", 3, now)
+	if actual.Allowed || actual.RetryAfter != 20*time.Second || actual.Reset != time.Minute {
+		t.Fatalf(`TestRateLimiterAllow did not limit the 4th request got %+v`, actual)
+	}
+
+	// Other clients have their own buckets
+	if actual := limiter.Allow("ip:2", 3, now); !actual.Allowed {
+		t.Fatalf(`TestRateLimiterAllow did not allow another client got %+v`, actual)
+	}
+
+	// A token is refilled every 20 seconds
+	if actual := limiter.Allow("ip:1", 3, now.Add(20*time.Second)); !actual.Allowed {
+		t.Fatalf(`TestRateLimiterAllow did not refill the bucket got %+v`, actual)
+	}
+}
+
+// TestRateLimiterUnlimited calls api.RateLimiter.Allow and checks that a
+// limit of 0 is unlimited.
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := NewRateLimiter()
+	for i := 0; i < 100; i++ {
+		if actual := limiter.Allow("key:1", 0, time.Now()); !actual.Allowed {
+			t.Fatalf(`TestRateLimiterUnlimited did not allow request %d`, i+1)
+		}
+	}
+}
+
+// TestRateLimiterPeek calls api.RateLimiter.Peek and checks that it
+// doesn't take requests from the bucket of a client.
+func TestRateLimiterPeek(t *testing.T) {
+	limiter := NewRateLimiter()
+	now := time.Date(2022, 6, 22, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		if actual := limiter.Peek("ip:203.0.113.7", 1, now); !actual.Allowed {
+			t.Fatalf(`TestRateLimiterPeek did not allow peek %d got %+v`, i, actual)
+		}
+	}
+	limiter.Allow("ip:203.0.113.7", 1, now)
+	if actual := limiter.Peek("ip:203.0.113.7", 1, now); actual.Allowed {
+		t.Fatalf(`TestRateLimiterPeek did not return an empty bucket got %+v`, actual)
+	}
+}
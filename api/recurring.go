@@ -0,0 +1,287 @@
+// recurring.go contains the job that flags outages at places hit by
+// multiple outages within a short time, and the controller function of
+// the recurring outage report.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+	"github.com/lib/pq"
+)
+
+// RecurringPlaceDistance is the largest distance (in m) between
+// neighbouring outages of the same street & suburb that are counted as
+// the same place, so same-named streets far apart are not merged.
+const RecurringPlaceDistance = 1000.0
+
+// A RecurringOutage struct maps an outage at a place, which is a street &
+// suburb split into groups of nearby outages.
+type RecurringOutage struct {
+	Region    string
+	Street    string
+	Suburb    string
+	Place     int
+	OutageID  int
+	Start     time.Time
+	Hours     float64
+	Longitude float64
+	Latitude  float64
+}
+
+// A Recurrence struct maps a place hit by multiple outages within a
+// window. Only the outages of the place that are part of such a window
+// are listed.
+type Recurrence struct {
+	Region      string   `json:"region"`
+	Street      string   `json:"street"`
+	Suburb      string   `json:"suburb"`
+	Longitude   float64  `json:"longitude"`
+	Latitude    float64  `json:"latitude"`
+	Occurrences int      `json:"occurrences"`
+	MaxInWindow int      `json:"max_in_window"`
+	TotalHours  float64  `json:"total_hours"`
+	OutageIDs   []int    `json:"outage_ids"`
+	Dates       []string `json:"dates"`
+}
+
+// GetRecurringSettings returns the least number of outages within a
+// window for a place to be recurring, and the window. They are read from
+// the RECURRING_MIN and RECURRING_WINDOW environmental variables, and
+// default to 3 outages within 90 days.
+func GetRecurringSettings() (minOutages int, window time.Duration) {
+	minOutages, window = 3, 90*24*time.Hour
+
+	if value, err := strconv.Atoi(os.Getenv("RECURRING_MIN")); err == nil && value > 1 {
+		minOutages = value
+	}
+	if value, err := ParseWindow(os.Getenv("RECURRING_WINDOW")); err == nil {
+		window = value
+	}
+	return
+}
+
+// MakeRecurringQuery returns an SQL query of the outages matching the SQL
+// WHERE string with the place of each outage, ordered by place and start
+// date. The hours of outages are counted by the SQL expression of hours.
+func MakeRecurringQuery(where, hours string) string {
+	return fmt.Sprintf(
+		`SELECT region, street, COALESCE(suburb, '') AS suburb, ST_ClusterDBSCAN(
+			ST_Transform(location::geometry, %d), eps := %f, minpoints := 1
+		) OVER (PARTITION BY region, lower(street), lower(COALESCE(suburb, ''))) AS place,
+		outage_id, start_date, COALESCE(%s, 0),
+		ST_X(location::geometry), ST_Y(location::geometry)
+		FROM outage%s
+		ORDER BY region, lower(street), lower(COALESCE(suburb, '')), place, start_date`,
+		MetricSRID, RecurringPlaceDistance, hours, where,
+	)
+}
+
+// GetRecurringOutages returns the outages of a query made by
+// MakeRecurringQuery.
+func GetRecurringOutages(db *sql.DB, query string) ([]RecurringOutage, error) {
+	var outages []RecurringOutage
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return outages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var outage RecurringOutage
+		if err := rows.Scan(&outage.Region, &outage.Street, &outage.Suburb,
+			&outage.Place, &outage.OutageID, &outage.Start, &outage.Hours,
+			&outage.Longitude, &outage.Latitude); err != nil {
+			return outages, err
+		}
+		outages = append(outages, outage)
+	}
+	return outages, rows.Err()
+}
+
+// samePlace returns true if two outages are at the same place.
+func samePlace(a, b RecurringOutage) bool {
+	return a.Region == b.Region && a.Place == b.Place &&
+		strings.EqualFold(a.Street, b.Street) && strings.EqualFold(a.Suburb, b.Suburb)
+}
+
+// FindRecurrences returns the places with at least minOutages outages
+// starting within a rolling window, with the busiest first. The outages must be
+// ordered by place and start date.
+func FindRecurrences(outages []RecurringOutage, minOutages int, window time.Duration) []Recurrence {
+	recurrences := []Recurrence{}
+
+	for start := 0; start < len(outages); {
+		end := start
+		for end < len(outages) && samePlace(outages[start], outages[end]) {
+			end++
+		}
+		place := outages[start:end]
+		start = end
+
+		// Slide a window over the outages of the place, and mark the
+		// outages of every window with enough outages
+		recurring := make([]bool, len(place))
+		maxInWindow, first := 0, 0
+		for last := range place {
+			for place[last].Start.Sub(place[first].Start) > window {
+				first++
+			}
+			if count := last - first + 1; count >= minOutages {
+				for i := first; i <= last; i++ {
+					recurring[i] = true
+				}
+				if count > maxInWindow {
+					maxInWindow = count
+				}
+			}
+		}
+		if maxInWindow == 0 {
+			continue
+		}
+
+		recurrence := Recurrence{
+			Region:      place[0].Region,
+			Street:      place[0].Street,
+			Suburb:      place[0].Suburb,
+			MaxInWindow: maxInWindow,
+		}
+		region := GetRegion(recurrence.Region)
+		for i, outage := range place {
+			if !recurring[i] {
+				continue
+			}
+			recurrence.Occurrences++
+			recurrence.TotalHours += outage.Hours
+			recurrence.Longitude += outage.Longitude
+			recurrence.Latitude += outage.Latitude
+			recurrence.OutageIDs = append(recurrence.OutageIDs, outage.OutageID)
+			recurrence.Dates = append(recurrence.Dates,
+				region.FormatOutageTime(outage.Start.Format("2006-01-02T15:04:05")))
+		}
+		recurrence.Longitude /= float64(recurrence.Occurrences)
+		recurrence.Latitude /= float64(recurrence.Occurrences)
+		recurrences = append(recurrences, recurrence)
+	}
+
+	sort.SliceStable(recurrences, func(i, j int) bool {
+		if recurrences[i].MaxInWindow != recurrences[j].MaxInWindow {
+			return recurrences[i].MaxInWindow > recurrences[j].MaxInWindow
+		}
+		return recurrences[i].Occurrences > recurrences[j].Occurrences
+	})
+	return recurrences
+}
+
+// recurringConditions are the conditions of outages that can recur.
+var recurringConditions = []string{
+	"street IS NOT NULL", "street <> ''", "start_date IS NOT NULL",
+}
+
+// FlagRecurringOutages saves whether each outage is part of a recurrence
+// by the RECURRING_MIN and RECURRING_WINDOW settings.
+func FlagRecurringOutages() {
+	minOutages, window := GetRecurringSettings()
+
+	// Open database
+	db := database.DB()
+
+	query := MakeRecurringQuery(
+		" WHERE "+strings.Join(recurringConditions, " AND "),
+		DurationHoursSQL(DefaultDurationModel, -1),
+	)
+	outages, err := GetRecurringOutages(db, query)
+	if err != nil {
+		log.Println("Finding recurring outages failed:", err)
+		return
+	}
+
+	ids := make(map[string][]int64)
+	for _, recurrence := range FindRecurrences(outages, minOutages, window) {
+		for _, id := range recurrence.OutageIDs {
+			ids[recurrence.Region] = append(ids[recurrence.Region], int64(id))
+		}
+	}
+
+	for _, region := range RegionNames() {
+		regionIDs := ids[region]
+		if regionIDs == nil {
+			regionIDs = []int64{}
+		}
+
+		_, err := db.Exec(
+			`UPDATE outage SET recurring = (outage_id = ANY($2))
+			WHERE region = $1 AND recurring IS DISTINCT FROM (outage_id = ANY($2))`,
+			region, pq.Array(regionIDs),
+		)
+		if err != nil {
+			log.Println("Flagging recurring outages failed:", err)
+			return
+		}
+	}
+
+	log.Println("Recurring outages have been flagged.")
+}
+
+// GetRecurringReport JSON-encodes the places hit by at least min outages
+// (defaults to 3) within a rolling window (defaults to 90d). The outages
+// can be filtered by the same parameters as GetOutages.
+func GetRecurringReport(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetRecurringReport request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := r.URL.Query()
+	minOutages, window := 3, 90*24*time.Hour
+
+	var minErr, windowErr error
+	if value := params.Get("min"); value != "" {
+		minOutages, minErr = strconv.Atoi(value)
+	}
+	if value := params.Get("window"); value != "" {
+		window, windowErr = ParseWindow(value)
+	}
+	if minErr != nil || minOutages < 2 || windowErr != nil {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3485,
+			Message:   "invalid recurrence",
+			Details:   "The min parameter must be at least 2, and the window a duration such as 90d, 12w or 36h.",
+		})
+		return
+	}
+
+	query := &Query{IsCount: true}
+	filter := query.MakeWhereStringWith(params, recurringConditions...)
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	main := MakeRecurringQuery(filter, query.Hours)
+	outages, err := GetRecurringOutages(db, main)
+	if err != nil {
+		logQueryError(err, main)
+		writeQueryError(w, err, 3486)
+		return
+	}
+
+	WriteJSON(w, FindRecurrences(outages, minOutages, window))
+}
@@ -0,0 +1,50 @@
+// recurring_test.go contains tests that test recurring.go
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFindRecurrences calls api.FindRecurrences and checks that only
+// outages within a window of the same place are counted, and that places
+// of same-named streets are kept apart.
+func TestFindRecurrences(t *testing.T) {
+	day := func(n int) time.Time {
+		return time.Date(2022, 1, 1, 9, 0, 0, 0, time.UTC).AddDate(0, 0, n)
+	}
+	outage := func(id, place, start int) RecurringOutage {
+		return RecurringOutage{
+			Region: "auckland", Street: "Queen Street", Suburb: "Auckland Central",
+			Place: place, OutageID: id, Start: day(start), Hours: 2,
+		}
+	}
+
+	outages := []RecurringOutage{
+		outage(1, 0, 0),
+		outage(2, 0, 30),
+		outage(3, 0, 60),
+		outage(4, 0, 200),
+		outage(5, 1, 0),
+		outage(6, 1, 10),
+		outage(7, 2, 5),
+	}
+
+	actual := FindRecurrences(outages, 3, 90*24*time.Hour)
+	if len(actual) != 1 {
+		t.Fatalf(`TestFindRecurrences did not return 1 recurrence got %+v`, actual)
+	}
+
+	recurrence := actual[0]
+	if recurrence.Occurrences != 3 || recurrence.MaxInWindow != 3 ||
+		recurrence.TotalHours != 6 || len(recurrence.OutageIDs) != 3 ||
+		recurrence.OutageIDs[2] != 3 ||
+		recurrence.Dates[0] != "2022-01-01T09:00:00+13:00" {
+		t.Fatalf(`TestFindRecurrences did not return outages 1-3 got %+v`, recurrence)
+	}
+
+	if actual := FindRecurrences(outages, 2, 15*24*time.Hour); len(actual) != 1 ||
+		actual[0].OutageIDs[0] != 5 {
+		t.Fatalf(`TestFindRecurrences did not return outages 5-6 got %+v`, actual)
+	}
+}
@@ -0,0 +1,229 @@
+// region.go contains the regions whose water outages are tracked by this
+// app, and the sources their outages are retrieved from.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Region struct maps an area with its own water outage API, address
+// heuristics and timezone.
+type Region struct {
+	Name string
+	// Timezone is the IANA timezone of the start and end dates of outages.
+	Timezone string
+	// Suburbs is the gazetteer used to find the suburb of addresses that
+	// are not comma-separated.
+	Suburbs []string
+	// CityNames are the names of the city that addresses may end with,
+	// such as "auckland" in "12 queen street, auckland central, auckland".
+	CityNames []string
+	// CentralSuburbs are suburbs that contain a city name, such as
+	// "auckland central".
+	CentralSuburbs []string
+	// AddressRules are the abbreviations and casing rules used to clean
+	// the street and suburb of addresses.
+	AddressRules AddressRules
+	// PlannedDailyHours is the number of hours counted per day of planned
+	// outages that last multiple days by the planned_factor duration
+	// model, as planned work usually only takes place during the day. If
+	// 0, the wall-clock hours are counted.
+	PlannedDailyHours float64
+	// Source is where the outages of the region are retrieved from.
+	Source OutageSource
+}
+
+// An OutageSource retrieves the latest outages of a region.
+type OutageSource interface {
+	FetchOutages() ([]WaterOutage, error)
+}
+
+// An HTTPSource struct maps a JSON API that returns outages in the format
+// of the Watercare API. The URL is read from the URLEnv environmental
+// variable.
+type HTTPSource struct {
+	URLEnv string
+}
+
+// Regions maps all supported regions by name.
+var Regions = map[string]*Region{
+	"auckland": {
+		Name:           "auckland",
+		Timezone:       "Pacific/Auckland",
+		Suburbs:        suburbs,
+		CityNames:      []string{"auckland"},
+		CentralSuburbs: []string{"auckland central", "auckland cbd"},
+		AddressRules: DefaultAddressRules().Merge(AddressRules{
+			CasingExceptions: auckland_casing_exceptions,
+		}),
+		PlannedDailyHours: 2.85,
+		Source:            HTTPSource{URLEnv: "SRC_API"},
+	},
+	"wellington": {
+		Name:      "wellington",
+		Timezone:  "Pacific/Auckland",
+		Suburbs:   wellington_suburbs,
+		CityNames: []string{"wellington", "lower hutt", "upper hutt", "porirua"},
+		CentralSuburbs: []string{
+			"wellington central", "lower hutt central", "upper hutt central",
+			"porirua central",
+		},
+		AddressRules: DefaultAddressRules(),
+		Source:       HTTPSource{URLEnv: "SRC_API_WELLINGTON"},
+	},
+	"christchurch": {
+		Name:           "christchurch",
+		Timezone:       "Pacific/Auckland",
+		Suburbs:        christchurch_suburbs,
+		CityNames:      []string{"christchurch"},
+		CentralSuburbs: []string{"christchurch central"},
+		AddressRules:   DefaultAddressRules(),
+		Source:         HTTPSource{URLEnv: "SRC_API_CHRISTCHURCH"},
+	},
+}
+
+// MetricSRID is the SRID of NZTM2000, a projection in metres that covers
+// all supported regions. It is used to measure cells and clusters.
+const MetricSRID = 2193
+
+// DefaultRegion is the region of outages saved before regions were
+// added, and of outages without a region.
+const DefaultRegion = "auckland"
+
+// enabledRegions are the regions whose outages are retrieved.
+var enabledRegions = []*Region{Regions[DefaultRegion]}
+
+// SetupRegions enables the regions in a comma-separated list of region
+// names. Only the default region is enabled if the list is empty.
+func SetupRegions(names string) error {
+	if strings.TrimSpace(names) == "" {
+		enabledRegions = []*Region{Regions[DefaultRegion]}
+		return nil
+	}
+
+	var regions []*Region
+	for _, name := range strings.Split(names, ",") {
+		region, ok := Regions[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return fmt.Errorf("unknown region %q", name)
+		}
+		regions = append(regions, region)
+	}
+
+	enabledRegions = regions
+	return nil
+}
+
+// EnabledRegions returns the regions whose outages are retrieved.
+func EnabledRegions() []*Region {
+	return enabledRegions
+}
+
+// GetRegion returns the region with the given name, or the default
+// region if there is none.
+func GetRegion(name string) *Region {
+	if region, ok := Regions[name]; ok {
+		return region
+	}
+	return Regions[DefaultRegion]
+}
+
+// RegionNames returns the names of all supported regions in alphabetical
+// order.
+func RegionNames() []string {
+	var names []string
+	for name := range Regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Location returns the timezone of the region.
+func (region *Region) Location() *time.Location {
+	location, err := time.LoadLocation(region.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// FetchOutages returns the latest outages of the region, with the region
+// name set on each outage.
+func (region *Region) FetchOutages() ([]WaterOutage, error) {
+	start := time.Now()
+	outages, err := region.Source.FetchOutages()
+	observeFetch(region.Name, start, err)
+	for i := range outages {
+		outages[i].Region = region.Name
+	}
+	return outages, err
+}
+
+// FetchOutages returns the outages from the JSON API.
+func (source HTTPSource) FetchOutages() ([]WaterOutage, error) {
+	var outages []WaterOutage
+
+	url := os.Getenv(source.URLEnv)
+	if url == "" {
+		return outages, fmt.Errorf("%s is not set", source.URLEnv)
+	}
+
+	// Get data from the API
+	response, err := http.Get(url)
+	if err != nil {
+		return outages, err
+	}
+	defer response.Body.Close()
+
+	// Read response data
+	outagesJSON, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return outages, err
+	}
+
+	// Fold responseData into WaterOutage structs
+	err = json.Unmarshal(outagesJSON, &outages)
+	return outages, err
+}
+
+// FormatOutageTime converts a start or end date from the database, which
+// is saved in the local time of the region, to an RFC 3339 timestamp with
+// the offset of the region.
+func (region *Region) FormatOutageTime(date string) string {
+	local, ok := region.ParseOutageTime(date)
+	if !ok {
+		return date
+	}
+	return local.Format(time.RFC3339)
+}
+
+// FormatIngestTime formats a UTC RFC 3339 timestamp of when an outage was
+// ingested to a timestamp with the offset of the region.
+func (region *Region) FormatIngestTime(date string) string {
+	ingested, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return date
+	}
+	return ingested.In(region.Location()).Format(time.RFC3339)
+}
+
+// ParseOutageTime returns the time of a start or end date from the
+// database, which is saved in the local time of the region.
+func (region *Region) ParseOutageTime(date string) (time.Time, bool) {
+	if len(date) < 19 {
+		return time.Time{}, false
+	}
+
+	local, err := time.ParseInLocation(
+		"2006-01-02T15:04:05", date[:19], region.Location(),
+	)
+	return local, err == nil
+}
@@ -0,0 +1,90 @@
+// region_test.go contains tests that test region.go
+package api
+
+import (
+	"testing"
+)
+
+// TestRegionAddressToStreetSuburb calls api.Region.AddressToStreetSuburb
+// and checks the street and suburb of addresses in other regions.
+func TestRegionAddressToStreetSuburb(t *testing.T) {
+	tests := map[string][][]string{
+		"wellington": {
+			{"12 Cuba St, Te Aro, Wellington 6011", "Cuba Street", "Te Aro"},
+			{"4 Molesworth Street, Wellington Central, Wellington", "Molesworth Street", "Wellington Central"},
+			{"88 Jackson Street Petone Lower Hutt", "Jackson Street", "Petone"},
+		},
+		"christchurch": {
+			{"3 Riccarton Rd, Upper Riccarton, Christchurch", "Riccarton Road", "Upper Riccarton"},
+			{"15 Marine Parade North New Brighton", "Marine Parade", "North New Brighton"},
+		},
+	}
+
+	for name, addresses := range tests {
+		for _, expected := range addresses {
+			street, suburb := GetRegion(name).AddressToStreetSuburb(expected[0])
+			if street != expected[1] || suburb != expected[2] {
+				t.Fatalf(
+					`TestRegionAddressToStreetSuburb did not return %s, %s got %s, %s`,
+					expected[1], expected[2], street, suburb,
+				)
+			}
+		}
+	}
+}
+
+// TestFormatOutageTime calls api.Region.FormatOutageTime and checks that
+// the offset of the region is added for both summer and winter dates.
+func TestFormatOutageTime(t *testing.T) {
+	region := GetRegion(DefaultRegion)
+	tests := map[string]string{
+		"2022-01-20T22:00:00Z": "2022-01-20T22:00:00+13:00",
+		"2022-06-20T22:00:00Z": "2022-06-20T22:00:00+12:00",
+		"not a date":           "not a date",
+	}
+
+	for test, expected := range tests {
+		if actual := region.FormatOutageTime(test); actual != expected {
+			t.Fatalf(
+				`TestFormatOutageTime did not return %s got %s`,
+				expected, actual,
+			)
+		}
+	}
+}
+
+// TestFormatIngestTime calls api.Region.FormatIngestTime and checks that
+// UTC timestamps are formatted with the offset of the region.
+func TestFormatIngestTime(t *testing.T) {
+	region := GetRegion(DefaultRegion)
+	tests := map[string]string{
+		"2022-06-20T22:00:00Z": "2022-06-21T10:00:00+12:00",
+		"":                     "",
+	}
+
+	for test, expected := range tests {
+		if actual := region.FormatIngestTime(test); actual != expected {
+			t.Fatalf(
+				`TestFormatIngestTime did not return %s got %s`,
+				expected, actual,
+			)
+		}
+	}
+}
+
+// TestSetupRegions calls api.SetupRegions and checks that regions are
+// enabled by name, and that unknown regions are rejected.
+func TestSetupRegions(t *testing.T) {
+	defer SetupRegions("")
+
+	if err := SetupRegions("auckland, Wellington"); err != nil {
+		t.Fatal(err)
+	}
+	if regions := EnabledRegions(); len(regions) != 2 || regions[1].Name != "wellington" {
+		t.Fatalf(`TestSetupRegions did not enable auckland and wellington`)
+	}
+
+	if err := SetupRegions("auckland,atlantis"); err == nil {
+		t.Fatalf(`TestSetupRegions did not reject an unknown region`)
+	}
+}
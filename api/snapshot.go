@@ -0,0 +1,77 @@
+// snapshot.go contains functions that record the dates of outages each
+// time the upstream API changes them, so announced dates can be compared
+// with the final ones.
+package api
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// snapshotOutagesQuery records the type & dates of the given outages of a
+// region if they are new or differ from their latest snapshot, and returns
+// the recorded outages with whether they are new.
+const snapshotOutagesQuery = `WITH changed AS (
+		SELECT outage.region, outage.outage_id, outage.outage_type,
+		outage.start_date, outage.end_date, latest.id IS NULL AS is_new
+		FROM outage LEFT JOIN LATERAL (
+			SELECT id, start_date, end_date FROM outage_snapshot
+			WHERE outage_snapshot.region = outage.region
+			AND outage_snapshot.outage_id = outage.outage_id
+			ORDER BY id DESC LIMIT 1
+		) latest ON true
+		WHERE outage.region = $1 AND outage.outage_id = ANY($2)
+		AND (latest.id IS NULL
+			OR latest.start_date IS DISTINCT FROM outage.start_date
+			OR latest.end_date IS DISTINCT FROM outage.end_date)
+	), recorded AS (
+		INSERT INTO outage_snapshot
+		(region, outage_id, outage_type, start_date, end_date)
+		SELECT region, outage_id, outage_type, start_date, end_date
+		FROM changed
+	)
+	SELECT outage_id, is_new FROM changed ORDER BY outage_id`
+
+// RecordOutageSnapshots records a snapshot of each of the given outages
+// (as just written to the database) whose dates are new or changed, and
+// returns an outage.created or outage.updated event of each of them.
+// Only outages still listed by the upstream API are recorded, so the
+// first snapshot of an outage holds its originally announced dates.
+func RecordOutageSnapshots(db *sql.DB, outages []WaterOutage) ([]OutageEvent, error) {
+	ids := make(map[string][]int64)
+	var regions []string
+	for _, outage := range outages {
+		region := GetRegion(outage.Region).Name
+		if ids[region] == nil {
+			regions = append(regions, region)
+		}
+		ids[region] = append(ids[region], int64(outage.OutageID))
+	}
+
+	var events []OutageEvent
+	for _, region := range regions {
+		rows, err := db.Query(snapshotOutagesQuery, region, pq.Array(ids[region]))
+		if err != nil {
+			return events, err
+		}
+
+		for rows.Next() {
+			event := OutageEvent{Type: OutageUpdated, Region: region}
+			var isNew bool
+			if err := rows.Scan(&event.OutageID, &isNew); err != nil {
+				rows.Close()
+				return events, err
+			}
+			if isNew {
+				event.Type = OutageCreated
+			}
+			events = append(events, event)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return events, err
+		}
+	}
+	return events, nil
+}
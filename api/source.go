@@ -3,40 +3,28 @@
 package api
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/axkeyz/water-down-again/database"
 )
 
 // GetAPIData returns the latest data as array of WaterOutage
-// structs from the Watercare Outage API.
+// structs from the outage APIs of all enabled regions.
 func GetAPIData() []WaterOutage {
 	var outages []WaterOutage
 
-	// Get data from the Watercare Outage API.
-	response, err := http.Get(os.Getenv("SRC_API"))
-
-	if err != nil {
-		log.Println(err.Error())
-		return outages
-	}
-
-	// Read response data
-	outagesJSON, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Println(err)
-		return outages
+	for _, region := range EnabledRegions() {
+		regionOutages, err := region.FetchOutages()
+		if err != nil {
+			log.Println(region.Name, err)
+			continue
+		}
+		outages = append(outages, regionOutages...)
 	}
 
-	// Fold responseData into WaterOutage structs
-	json.Unmarshal([]byte(string(outagesJSON)), &outages)
-
 	return outages
 }
 
@@ -59,17 +47,21 @@ func UnpackAPIData(outages []WaterOutage) string {
 // a specific formatted string for bulk insert.
 // Format:
 // `(OutageID, "Street", "Suburb", "(Longitude, Latitude)",
-// "StartDate", "EndDate", "OutageType")`
+// "StartDate", "EndDate", "OutageType", "RawLocation",
+// region.CurrentParserVersion(), "Region")`
 func UnpackSingleAPIData(outage WaterOutage) string {
-	street, suburb := AddressToStreetSuburb(
+	region := GetRegion(outage.Region)
+	street, suburb := region.AddressToStreetSuburb(
 		outage.Location)
 
 	return fmt.Sprintf(
-		"(%d,'%s','%s','POINT(%f %f)','%s', '%s', '%s')",
-		outage.OutageID, strings.TrimSpace(street),
-		strings.TrimSpace(suburb), outage.Longitude,
+		"(%d,'%s','%s','POINT(%f %f)','%s', '%s', '%s', '%s', %d, '%s')",
+		outage.OutageID, EscapeSQLString(strings.TrimSpace(street)),
+		EscapeSQLString(strings.TrimSpace(suburb)), outage.Longitude,
 		outage.Latitude, outage.StartDate,
 		outage.EndDate, outage.OutageType,
+		EscapeSQLString(outage.Location), region.CurrentParserVersion(),
+		region.Name,
 	)
 }
 
@@ -78,9 +70,13 @@ func UnpackSingleAPIData(outage WaterOutage) string {
 func MakeWriteOutageQuery(outage []WaterOutage) string {
 	// Prepare SQL Statement
 	sqlStatement := `insert into outage (outage_id, street, 
-		suburb, location, start_date, end_date, outage_type)  
-		values %s on conflict (outage_id) do update SET 
-		end_date = excluded.end_date;`
+		suburb, location, start_date, end_date, outage_type, 
+		raw_location, parser_version, region) 
+		values %s on conflict (region, outage_id) do update SET 
+		end_date = excluded.end_date, 
+		raw_location = excluded.raw_location, 
+		street = excluded.street, suburb = excluded.suburb, 
+		parser_version = excluded.parser_version;`
 	outages := UnpackAPIData(outage)
 
 	return fmt.Sprintf(sqlStatement, outages)
@@ -90,28 +86,79 @@ func MakeWriteOutageQuery(outage []WaterOutage) string {
 // exists in the database (based on outage_id), WriteOutage
 // attempts to update the endDate if applicable. If the outage
 // does not exist in the database, WriteOutage creates a
-// new record.
-func WriteOutage(outage []WaterOutage) {
+// new record. A snapshot is recorded of outages whose dates are
+// new or changed, and an event of each of them is returned.
+func WriteOutage(outage []WaterOutage) ([]OutageEvent, error) {
 	// Open database
-	db := database.SetupDB()
-	defer db.Close()
+	db := database.DB()
 
 	// Prepare SQL Statement
 	query := MakeWriteOutageQuery(outage)
 
 	// run sql statement
-	_, err := db.Exec(query)
-	if err != nil {
-		log.Println(err)
+	if _, err := db.Exec(query); err != nil {
+		return nil, err
 	}
+
+	// Record the dates of new & changed outages
+	return RecordOutageSnapshots(db, outage)
 }
 
-// UpdateOutages gets the latest data from the Watercare API
-// and upserts the data into the database.
-func UpdateOutages() {
-	outages := GetAPIData()
-	WriteOutage(outages)
+// UpdateOutages gets the latest data from the outage API of
+// each enabled region and upserts the data into the database.
+// Outages no longer listed by the API of their region are
+// resolved, and the events of created, updated & resolved
+// outages are saved.
+func UpdateOutages() IngestReport {
+	report := IngestReport{
+		StartedAt: time.Now(),
+		Fetched:   make(map[string]int),
+		Errors:    make(map[string]error),
+	}
+
+	// Open database
+	db := database.DB()
+
+	for _, region := range EnabledRegions() {
+		outages, err := region.FetchOutages()
+		if err != nil {
+			log.Println("Updating", region.Name, "outages failed:", err)
+			report.Errors[region.Name] = err
+			continue
+		}
+		report.Fetched[region.Name] = len(outages)
+
+		var events []OutageEvent
+		if len(outages) > 0 {
+			if events, err = WriteOutage(outages); err != nil {
+				log.Println("Saving", region.Name, "outages failed:", err)
+				report.Errors[region.Name] = err
+				continue
+			}
+		}
+
+		ids := make([]int64, len(outages))
+		for i, outage := range outages {
+			ids[i] = int64(outage.OutageID)
+		}
+		resolved, err := ResolveOutages(db, region.Name, ids)
+		if err != nil {
+			log.Println("Resolving", region.Name, "outages failed:", err)
+			report.Errors[region.Name] = err
+		}
+
+		saved, err := SaveOutageEvents(db, append(events, resolved...))
+		if err != nil {
+			log.Println("Saving", region.Name, "outage events failed:", err)
+			report.Errors[region.Name] = err
+		}
+		report.Events = append(report.Events, saved...)
+	}
+
+	report.Changed = int64(len(report.Events))
+	report.FinishedAt = time.Now()
 	log.Println("Outage list has been updated.")
+	return report
 }
 
 // GetCurrentOutageIDs returns an int slice of all currently
@@ -126,3 +173,17 @@ func GetCurrentOutageIDs() (current_outage_ids []int) {
 
 	return
 }
+
+// GetCurrentRegionOutageIDs returns the currently active
+// outage ids of each region.
+func GetCurrentRegionOutageIDs() map[string][]int {
+	current_outage_ids := make(map[string][]int)
+
+	for _, current_outage := range GetAPIData() {
+		current_outage_ids[current_outage.Region] = append(
+			current_outage_ids[current_outage.Region],
+			current_outage.OutageID)
+	}
+
+	return current_outage_ids
+}
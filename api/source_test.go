@@ -56,9 +56,11 @@ func TestUnpackAPIData(t *testing.T) {
 	// Get outputs
 	actual_output := UnpackAPIData(packed_api)
 	expected_output := "(15988,'Uranus Street','Unknown','POINT(174.832591 -36.908991)'," +
-		"'2022-06-20T22:00:00+12:00', '2022-06-21T03:00:00+12:00', 'Planned'), " +
+		"'2022-06-20T22:00:00+12:00', '2022-06-21T03:00:00+12:00', 'Planned', " +
+		"'52 Uranus Street', " + fmt.Sprint(GetRegion(DefaultRegion).CurrentParserVersion()) + ", 'auckland'), " +
 		"(26344,'Mercury Road','Unknown','POINT(175.834391 -23.902991)'," +
-		"'2022-05-15T24:00:00+12:00', '2022-07-27T05:00:00+12:00', 'Unplanned')"
+		"'2022-05-15T24:00:00+12:00', '2022-07-27T05:00:00+12:00', 'Unplanned', " +
+		"'34 Mercury Road', " + fmt.Sprint(GetRegion(DefaultRegion).CurrentParserVersion()) + ", 'auckland')"
 
 	// check for issues
 	if actual_output != expected_output {
@@ -0,0 +1,184 @@
+// stats.go contains the statistical functions used by reports to compare
+// outage rates, such as Poisson confidence intervals and chi-square tests.
+package api
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// statsEpsilon is the relative precision of the gamma functions.
+	statsEpsilon = 1e-14
+	// statsMaxIterations bounds the series and continued fraction of the
+	// gamma functions.
+	statsMaxIterations = 1000
+)
+
+// RegularizedGammaP returns the lower regularized incomplete gamma
+// function P(a, x), the cumulative distribution function of a gamma
+// distribution with shape a.
+func RegularizedGammaP(a, x float64) float64 {
+	if x <= 0 || a <= 0 {
+		return 0
+	}
+	if x < a+1 {
+		return gammaSeries(a, x)
+	}
+	return 1 - gammaContinuedFraction(a, x)
+}
+
+// RegularizedGammaQ returns the upper regularized incomplete gamma
+// function Q(a, x) = 1 - P(a, x).
+func RegularizedGammaQ(a, x float64) float64 {
+	if x <= 0 || a <= 0 {
+		return 1
+	}
+	if x < a+1 {
+		return 1 - gammaSeries(a, x)
+	}
+	return gammaContinuedFraction(a, x)
+}
+
+// gammaPrefix returns x^a e^-x / Γ(a).
+func gammaPrefix(a, x float64) float64 {
+	lgamma, _ := math.Lgamma(a)
+	return math.Exp(-x + a*math.Log(x) - lgamma)
+}
+
+// gammaSeries returns P(a, x) by its series, which converges quickly for
+// x < a+1.
+func gammaSeries(a, x float64) float64 {
+	term := 1 / a
+	sum := term
+	for n := 1; n < statsMaxIterations; n++ {
+		term *= x / (a + float64(n))
+		sum += term
+		if math.Abs(term) < math.Abs(sum)*statsEpsilon {
+			break
+		}
+	}
+	return sum * gammaPrefix(a, x)
+}
+
+// gammaContinuedFraction returns Q(a, x) by its continued fraction
+// (modified Lentz's method), which converges quickly for x >= a+1.
+func gammaContinuedFraction(a, x float64) float64 {
+	const tiny = 1e-300
+
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < statsMaxIterations; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < statsEpsilon {
+			break
+		}
+	}
+	return gammaPrefix(a, x) * h
+}
+
+// ChiSquareQuantile returns the value below which a chi-square
+// distribution with df degrees of freedom falls with probability p.
+func ChiSquareQuantile(p, df float64) float64 {
+	if p <= 0 || df <= 0 {
+		return 0
+	}
+	if p >= 1 {
+		return math.Inf(1)
+	}
+
+	// Bracket the quantile, then bisect
+	low, high := 0.0, df+1
+	for RegularizedGammaP(df/2, high/2) < p {
+		low, high = high, high*2
+	}
+	for i := 0; i < 200 && high-low > statsEpsilon*high; i++ {
+		middle := (low + high) / 2
+		if RegularizedGammaP(df/2, middle/2) < p {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return (low + high) / 2
+}
+
+// PoissonCI returns the exact (Garwood) confidence interval of the mean
+// of a Poisson distribution from an observed count.
+func PoissonCI(count int, confidence float64) (lower, upper float64) {
+	alpha := 1 - confidence
+	if count > 0 {
+		lower = ChiSquareQuantile(alpha/2, 2*float64(count)) / 2
+	}
+	upper = ChiSquareQuantile(1-alpha/2, 2*float64(count)+2) / 2
+	return
+}
+
+// PoissonTest returns the two-sided p-value of observing count events
+// where expected events are expected, doubling the smaller tail.
+func PoissonTest(count int, expected float64) float64 {
+	if expected <= 0 {
+		if count == 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// P(X <= count) and P(X >= count) of a Poisson distribution
+	lowerTail := RegularizedGammaQ(float64(count)+1, expected)
+	upperTail := 1.0
+	if count > 0 {
+		upperTail = RegularizedGammaP(float64(count), expected)
+	}
+
+	return math.Min(1, 2*math.Min(lowerTail, upperTail))
+}
+
+// ChiSquareTest returns the chi-square goodness-of-fit statistic of the
+// observed counts against the expected counts, with its degrees of
+// freedom and p-value. Categories without an expected count are skipped.
+func ChiSquareTest(observed, expected []float64) (statistic float64, df int, p float64) {
+	categories := 0
+	for i := range observed {
+		if i >= len(expected) || expected[i] <= 0 {
+			continue
+		}
+		statistic += math.Pow(observed[i]-expected[i], 2) / expected[i]
+		categories++
+	}
+
+	df = categories - 1
+	if df < 1 {
+		return statistic, 0, 1
+	}
+	return statistic, df, RegularizedGammaQ(float64(df)/2, statistic/2)
+}
+
+// Median returns the median of values, or 0 if there are none. The
+// values are sorted in place.
+func Median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
@@ -0,0 +1,109 @@
+// stats_test.go contains tests that test stats.go
+package api
+
+import (
+	"math"
+	"testing"
+)
+
+// almostEqual returns true if two numbers differ by at most tolerance.
+func almostEqual(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// TestRegularizedGamma calls api.RegularizedGammaP and
+// api.RegularizedGammaQ and checks them against the exponential
+// distribution and each other.
+func TestRegularizedGamma(t *testing.T) {
+	for _, x := range []float64{0.1, 1, 2.5, 10, 40} {
+		// P(1, x) is the CDF of an exponential distribution
+		if actual := RegularizedGammaP(1, x); !almostEqual(actual, 1-math.Exp(-x), 1e-12) {
+			t.Fatalf(`TestRegularizedGamma did not return %v got %v`, 1-math.Exp(-x), actual)
+		}
+		if sum := RegularizedGammaP(3.5, x) + RegularizedGammaQ(3.5, x); !almostEqual(sum, 1, 1e-12) {
+			t.Fatalf(`TestRegularizedGamma did not return P + Q = 1 got %v`, sum)
+		}
+	}
+}
+
+// TestChiSquareQuantile calls api.ChiSquareQuantile and checks it against
+// values of chi-square tables.
+func TestChiSquareQuantile(t *testing.T) {
+	tests := []struct {
+		inputs   [2]float64 // p, degrees of freedom
+		expected float64
+	}{
+		{[2]float64{0.95, 1}, 3.841459},
+		{[2]float64{0.95, 10}, 18.307038},
+		{[2]float64{0.025, 20}, 9.590777},
+	}
+
+	for _, test := range tests {
+		actual := ChiSquareQuantile(test.inputs[0], test.inputs[1])
+		if !almostEqual(actual, test.expected, 1e-5) {
+			t.Fatalf(`TestChiSquareQuantile did not return %v got %v`, test.expected, actual)
+		}
+	}
+}
+
+// TestPoissonCI calls api.PoissonCI and checks the exact 95% confidence
+// intervals of counts.
+func TestPoissonCI(t *testing.T) {
+	tests := map[int][]float64{
+		0:  {0, 3.688879},
+		10: {4.795389, 18.390356},
+	}
+
+	for count, expected := range tests {
+		lower, upper := PoissonCI(count, 0.95)
+		if !almostEqual(lower, expected[0], 1e-5) || !almostEqual(upper, expected[1], 1e-5) {
+			t.Fatalf(`TestPoissonCI did not return %v got %v, %v`, expected, lower, upper)
+		}
+	}
+}
+
+// TestPoissonTest calls api.PoissonTest and checks the two-sided p-value
+// of counts.
+func TestPoissonTest(t *testing.T) {
+	if actual := PoissonTest(10, 10); actual != 1 {
+		t.Fatalf(`TestPoissonTest did not return 1 got %v`, actual)
+	}
+	// P(X >= 20) of a Poisson distribution with mean 10 is 0.003454
+	if actual := PoissonTest(20, 10); !almostEqual(actual, 2*0.003454, 1e-5) {
+		t.Fatalf(`TestPoissonTest did not return %v got %v`, 2*0.003454, actual)
+	}
+	if actual := PoissonTest(0, 0); actual != 1 {
+		t.Fatalf(`TestPoissonTest did not return 1 got %v`, actual)
+	}
+}
+
+// TestChiSquareTest calls api.ChiSquareTest and checks the statistic,
+// degrees of freedom and p-value.
+func TestChiSquareTest(t *testing.T) {
+	statistic, df, p := ChiSquareTest(
+		[]float64{10, 20, 30}, []float64{20, 20, 20},
+	)
+	if statistic != 10 || df != 2 || !almostEqual(p, math.Exp(-5), 1e-12) {
+		t.Fatalf(`TestChiSquareTest did not return 10, 2, %v got %v, %v, %v`,
+			math.Exp(-5), statistic, df, p)
+	}
+}
+
+// TestMedian calls api.Median and checks the median of no, an odd and an
+// even number of values.
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		inputs   []float64
+		expected float64
+	}{
+		{nil, 0},
+		{[]float64{3, 1, 2}, 2},
+		{[]float64{4, 1, 3, 2}, 2.5},
+	}
+
+	for _, test := range tests {
+		if actual := Median(test.inputs); actual != test.expected {
+			t.Fatalf(`TestMedian did not return %v got %v`, test.expected, actual)
+		}
+	}
+}
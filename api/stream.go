@@ -0,0 +1,213 @@
+// stream.go creates the controller function of the live stream of outage
+// events, sent as Server-Sent Events.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+const (
+	// StreamHeartbeat is the time between comments sent to keep idle
+	// streams open through proxies.
+	StreamHeartbeat = 15 * time.Second
+	// StreamRetry is the time (in ms) clients wait before reconnecting.
+	StreamRetry = 10000
+	// streamBatch is the number of events read from the database at once.
+	streamBatch = 500
+	// MaxStreamResume is the number of events before the latest one that
+	// a client can resume from. Older events can't be read through the
+	// stream, so it is not a way around the export scope.
+	MaxStreamResume = 1000
+)
+
+var (
+	// outageEventsMutex guards outageEventsReady, which is closed and
+	// replaced whenever an ingest saves events.
+	outageEventsMutex sync.Mutex
+	outageEventsReady = make(chan struct{})
+)
+
+// NotifyOutageEvents wakes up the streams waiting for events if an
+// ingest saved any.
+func NotifyOutageEvents(report IngestReport) {
+	if len(report.Events) == 0 {
+		return
+	}
+
+	outageEventsMutex.Lock()
+	close(outageEventsReady)
+	outageEventsReady = make(chan struct{})
+	outageEventsMutex.Unlock()
+}
+
+// waitOutageEvents returns a channel that is closed when the next events
+// are saved.
+func waitOutageEvents() <-chan struct{} {
+	outageEventsMutex.Lock()
+	defer outageEventsMutex.Unlock()
+	return outageEventsReady
+}
+
+// WriteServerSentEvent writes an event in the Server-Sent Events format.
+// Each line of data becomes its own data field.
+func WriteServerSentEvent(w io.Writer, id int64, event string, data []byte) error {
+	message := fmt.Sprintf("id: %d\nevent: %s\n", id, event)
+	for _, line := range strings.Split(string(data), "\n") {
+		message += "data: " + line + "\n"
+	}
+
+	_, err := io.WriteString(w, message+"\n")
+	return err
+}
+
+// GetLastEventID returns the ID of the last event a client received, from
+// the Last-Event-ID header sent when an EventSource reconnects or the
+// last_event_id parameter. It returns false if there is none.
+func GetLastEventID(r *http.Request) (int64, bool) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// ResumeEventID returns the ID of the event after which a stream resumes:
+// the last event a client received, but no more than MaxStreamResume
+// events before the latest event.
+func ResumeEventID(lastID, latestID int64) int64 {
+	if latestID-lastID > MaxStreamResume {
+		return latestID - MaxStreamResume
+	}
+	return lastID
+}
+
+// StreamOutages sends the events of outages matching the same parameters
+// as GetOutages as Server-Sent Events, as each ingest saves them. Clients
+// resume after the Last-Event-ID (up to MaxStreamResume events back), or
+// else receive only new events.
+func StreamOutages(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received StreamOutages request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := r.URL.Query()
+	query := &Query{IsCount: true}
+	query.MakeWhereString(params)
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3500,
+			Message:   "streaming unsupported",
+			Details:   "The server cannot stream responses.",
+		})
+		return
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	var latestID int64
+	if err := db.QueryRow(
+		`SELECT COALESCE(MAX(id), 0) FROM outage_event`,
+	).Scan(&latestID); err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3501,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	lastID, resume := GetLastEventID(r)
+	if resume {
+		lastID = ResumeEventID(lastID, latestID)
+	} else {
+		lastID = latestID
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", StreamRetry)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		// Wait for events saved after reading the latest ones
+		ready := waitOutageEvents()
+
+		for {
+			events, err := GetOutageEvents(db, lastID, streamBatch)
+			if err != nil {
+				log.Println("Streaming outage events failed:", err)
+				return
+			}
+
+			payloads, err := MatchOutageEvents(db, events, params)
+			if err != nil {
+				log.Println("Streaming outage events failed:", err)
+				return
+			}
+
+			for _, payload := range payloads {
+				data, err := json.Marshal(payload)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				if err := WriteServerSentEvent(w, payload.ID, payload.Type, data); err != nil {
+					return
+				}
+			}
+			if len(events) > 0 {
+				lastID = events[len(events)-1].ID
+			}
+			flusher.Flush()
+
+			if len(events) < streamBatch {
+				break
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ready:
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
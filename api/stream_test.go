@@ -0,0 +1,79 @@
+// stream_test.go contains tests that test stream.go
+package api
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWriteServerSentEvent calls api.WriteServerSentEvent and checks that
+// each line of data becomes a data field.
+func TestWriteServerSentEvent(t *testing.T) {
+	var actual bytes.Buffer
+	WriteServerSentEvent(&actual, 42, OutageUpdated, []byte("{\n\"outage_id\":1}"))
+	expected := "id: 42\nevent: outage.updated\ndata: {\ndata: \"outage_id\":1}\n\n"
+
+	if actual.String() != expected {
+		t.Fatalf(`TestWriteServerSentEvent did not return %q got %q`, expected, actual.String())
+	}
+}
+
+// TestGetLastEventID calls api.GetLastEventID and checks that the
+// Last-Event-ID header is preferred over the last_event_id parameter.
+func TestGetLastEventID(t *testing.T) {
+	r := httptest.NewRequest("GET", "/stream?last_event_id=7", nil)
+	if id, ok := GetLastEventID(r); id != 7 || !ok {
+		t.Fatalf(`TestGetLastEventID did not return 7, true got %v, %v`, id, ok)
+	}
+
+	r.Header.Set("Last-Event-ID", "12")
+	if id, ok := GetLastEventID(r); id != 12 || !ok {
+		t.Fatalf(`TestGetLastEventID did not return 12, true got %v, %v`, id, ok)
+	}
+
+	r = httptest.NewRequest("GET", "/stream", nil)
+	if id, ok := GetLastEventID(r); id != 0 || ok {
+		t.Fatalf(`TestGetLastEventID did not return 0, false got %v, %v`, id, ok)
+	}
+}
+
+// TestResumeEventID calls api.ResumeEventID and checks that a stream
+// resumes no more than MaxStreamResume events before the latest event.
+func TestResumeEventID(t *testing.T) {
+	tests := []struct {
+		inputs   [2]int64 // last event id, latest event id
+		expected int64
+	}{
+		{[2]int64{4000, 4500}, 4000},
+		{[2]int64{3500, 4500}, 3500},
+		{[2]int64{0, 4500}, 3500},
+		{[2]int64{0, 20}, 0},
+	}
+
+	for _, test := range tests {
+		if actual := ResumeEventID(test.inputs[0], test.inputs[1]); actual != test.expected {
+			t.Fatalf(`TestResumeEventID did not return %v got %v`, test.expected, actual)
+		}
+	}
+}
+
+// TestNotifyOutageEvents calls api.NotifyOutageEvents and checks that
+// waiting streams are only woken up by an ingest with events.
+func TestNotifyOutageEvents(t *testing.T) {
+	ready := waitOutageEvents()
+
+	NotifyOutageEvents(IngestReport{})
+	select {
+	case <-ready:
+		t.Fatalf(`TestNotifyOutageEvents woke up streams without events`)
+	default:
+	}
+
+	NotifyOutageEvents(IngestReport{Events: []OutageEvent{{ID: 1, Type: OutageCreated}}})
+	select {
+	case <-ready:
+	default:
+		t.Fatalf(`TestNotifyOutageEvents did not wake up streams`)
+	}
+}
@@ -0,0 +1,253 @@
+// timeseries.go creates the controller function that counts outages per
+// day, week, month or year.
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+)
+
+// MaxTimeSeriesBuckets is the largest number of buckets in a time series.
+const MaxTimeSeriesBuckets = 5000
+
+// A TimeSeriesPoint struct maps the value of a single bucket of a time
+// series.
+type TimeSeriesPoint struct {
+	Start string  `json:"start"`
+	Value float64 `json:"value"`
+}
+
+// A TimeSeries struct maps the buckets of a group, such as a suburb.
+type TimeSeries struct {
+	Group  string            `json:"group,omitempty"`
+	Points []TimeSeriesPoint `json:"points"`
+}
+
+// A TimeSeriesResponse struct maps the time series of all groups.
+type TimeSeriesResponse struct {
+	Interval string       `json:"interval"`
+	Metric   string       `json:"metric"`
+	Timezone string       `json:"timezone"`
+	Series   []TimeSeries `json:"series"`
+}
+
+// TruncateTime returns the start of the bucket (day, week, month or year)
+// of a time, in the location of the time. Weeks start on Monday.
+func TruncateTime(t time.Time, interval string) time.Time {
+	year, month, day := t.Date()
+
+	switch interval {
+	case "year":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case "week":
+		weekday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// NextBucket returns the start of the bucket after the given one.
+func NextBucket(t time.Time, interval string) time.Time {
+	switch interval {
+	case "year":
+		return t.AddDate(1, 0, 0)
+	case "month":
+		return t.AddDate(0, 1, 0)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// FillTimeSeries returns the time series of each group with a bucket for
+// every interval from the bucket of from to the bucket of to. The values
+// of each group are mapped by the Unix time of their bucket, and buckets
+// without a value are filled with 0.
+func FillTimeSeries(values map[string]map[int64]float64,
+	interval string, from, to time.Time) ([]TimeSeries, error) {
+	series := []TimeSeries{}
+
+	var groups []string
+	for group := range values {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		points := []TimeSeriesPoint{}
+		for bucket := TruncateTime(from, interval); !bucket.After(to); bucket = NextBucket(bucket, interval) {
+			if len(points) == MaxTimeSeriesBuckets {
+				return series, fmt.Errorf(
+					"more than %d buckets, use a longer interval or a shorter date range",
+					MaxTimeSeriesBuckets)
+			}
+
+			points = append(points, TimeSeriesPoint{
+				Start: bucket.Format(time.RFC3339),
+				Value: values[group][bucket.Unix()],
+			})
+		}
+		series = append(series, TimeSeries{Group: group, Points: points})
+	}
+
+	return series, nil
+}
+
+// parseDateParam returns the time of a date parameter, given as a date
+// ("2022-06-22") or an RFC 3339 timestamp, in the given location.
+func parseDateParam(value string, location *time.Location) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02"} {
+		if len(value) >= len(layout) {
+			if t, err := time.ParseInLocation(layout, value[:len(layout)], location); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// GetTimeSeries JSON-encodes the number or total hours of outages per
+// bucket (day, week, month or year), optionally per group such as suburb.
+func GetTimeSeries(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received GetTimeSeries request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	params := GetRequestParams(r)
+	interval, metric, group := params.Get("interval"), params.Get("metric"), params.Get("group")
+	if interval == "" {
+		interval = "month"
+	}
+	if metric == "" {
+		metric = "count"
+	}
+
+	if !isStringInArray(interval, TimeSeriesIntervals) ||
+		(metric != "count" && metric != "total_hours") ||
+		(group != "" && !isStringInArray(group, GroupableColumns)) {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3470,
+			Message:   "invalid time series",
+			Details: "The interval must be day, week, month or year, the metric count or " +
+				"total_hours, and the group one of " + strings.Join(GroupableColumns, ", ") + ".",
+		})
+		return
+	}
+
+	query := &Query{IsCount: true}
+	filter := query.MakeWhereStringWith(params, "start_date IS NOT NULL")
+	if len(query.InvalidParams) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(query.InvalidParams, ", ") + ".",
+		})
+		return
+	}
+
+	// Buckets are in the timezone of the region
+	region := GetRegion(strings.ToLower(params.Get("region")))
+	location := region.Location()
+
+	groupColumn := "''"
+	if group != "" {
+		groupColumn = fmt.Sprintf("COALESCE(%s, '')", group)
+	}
+	value := "count(*)::float"
+	if metric == "total_hours" {
+		value = "COALESCE(SUM(" + query.Hours + "), 0)"
+	}
+
+	main := fmt.Sprintf(
+		`SELECT to_char(date_trunc('%s', start_date AT TIME ZONE '%s', '%s')
+		AT TIME ZONE '%s', 'YYYY-MM-DD"T"HH24:MI:SS') AS bucket, %s AS grouped, %s
+		FROM outage %s GROUP BY 1, 2`,
+		interval, region.Timezone, region.Timezone, region.Timezone,
+		groupColumn, value, filter,
+	)
+
+	// Setup the database
+	db := database.DB()
+
+	rows, err := db.Query(main)
+	if err != nil {
+		logQueryError(err, main)
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details:   "Parameters given for this API were invalid.",
+		})
+		return
+	}
+	defer rows.Close()
+
+	// Map the values of each group by bucket, and find the first & last
+	// bucket if no date range is given
+	values := make(map[string]map[int64]float64)
+	from, hasFrom := parseDateParam(params.Get("after_start_date"), location)
+	to, hasTo := parseDateParam(params.Get("before_start_date"), location)
+	for rows.Next() {
+		var bucketString, grouped string
+		var bucketValue float64
+		if err := rows.Scan(&bucketString, &grouped, &bucketValue); err != nil {
+			log.Println(err)
+			WriteAppError(w, http.StatusInternalServerError, AppError{
+				ErrorCode: 3471,
+				Message:   "unknown error",
+				Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+			})
+			return
+		}
+
+		bucket, _ := parseDateParam(bucketString, location)
+		if values[grouped] == nil {
+			values[grouped] = make(map[int64]float64)
+		}
+		values[grouped][bucket.Unix()] = bucketValue
+
+		if !hasFrom && (from.IsZero() || bucket.Before(from)) {
+			from = bucket
+		}
+		if !hasTo && (to.IsZero() || bucket.After(to)) {
+			to = bucket
+		}
+	}
+
+	// Without groups, the date range is filled even if there are no outages
+	if group == "" && len(values) == 0 && hasFrom && hasTo {
+		values[""] = make(map[int64]float64)
+	}
+
+	series, err := FillTimeSeries(values, interval, from, to)
+	if err != nil {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3472,
+			Message:   "too many buckets",
+			Details:   "The time series has " + err.Error() + ".",
+		})
+		return
+	}
+
+	WriteJSON(w, TimeSeriesResponse{
+		Interval: interval,
+		Metric:   metric,
+		Timezone: region.Timezone,
+		Series:   series,
+	})
+}
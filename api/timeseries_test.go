@@ -0,0 +1,85 @@
+// timeseries_test.go contains tests that test timeseries.go
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestTruncateTime calls api.TruncateTime and checks the start of the
+// bucket of each interval.
+func TestTruncateTime(t *testing.T) {
+	location := GetRegion(DefaultRegion).Location()
+	date := time.Date(2022, 6, 23, 15, 30, 0, 0, location) // Thursday
+
+	tests := map[string]time.Time{
+		"day":   time.Date(2022, 6, 23, 0, 0, 0, 0, location),
+		"week":  time.Date(2022, 6, 20, 0, 0, 0, 0, location),
+		"month": time.Date(2022, 6, 1, 0, 0, 0, 0, location),
+		"year":  time.Date(2022, 1, 1, 0, 0, 0, 0, location),
+	}
+
+	for interval, expected := range tests {
+		if actual := TruncateTime(date, interval); !actual.Equal(expected) {
+			t.Fatalf(
+				`TestTruncateTime(%s) did not return %v got %v`,
+				interval, expected, actual,
+			)
+		}
+	}
+
+	// Sundays belong to the week that started on the Monday before
+	sunday := time.Date(2022, 6, 26, 8, 0, 0, 0, location)
+	if actual := TruncateTime(sunday, "week"); actual.Day() != 20 {
+		t.Fatalf(`TestTruncateTime(week) did not return Monday 20th got %v`, actual)
+	}
+}
+
+// TestFillTimeSeries calls api.FillTimeSeries and checks that empty
+// buckets are filled with zeros, across a daylight saving change.
+func TestFillTimeSeries(t *testing.T) {
+	location := GetRegion(DefaultRegion).Location()
+	from := time.Date(2022, 9, 24, 10, 0, 0, 0, location)
+	to := time.Date(2022, 9, 27, 0, 0, 0, 0, location)
+
+	values := map[string]map[int64]float64{
+		"Remuera": {time.Date(2022, 9, 25, 0, 0, 0, 0, location).Unix(): 2},
+		"Epsom":   {time.Date(2022, 9, 27, 0, 0, 0, 0, location).Unix(): 1},
+	}
+
+	series, err := FillTimeSeries(values, "day", from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []TimeSeries{
+		{Group: "Epsom", Points: []TimeSeriesPoint{
+			{"2022-09-24T00:00:00+12:00", 0}, {"2022-09-25T00:00:00+12:00", 0},
+			{"2022-09-26T00:00:00+13:00", 0}, {"2022-09-27T00:00:00+13:00", 1},
+		}},
+		{Group: "Remuera", Points: []TimeSeriesPoint{
+			{"2022-09-24T00:00:00+12:00", 0}, {"2022-09-25T00:00:00+12:00", 2},
+			{"2022-09-26T00:00:00+13:00", 0}, {"2022-09-27T00:00:00+13:00", 0},
+		}},
+	}
+
+	for i := range expected {
+		if series[i].Group != expected[i].Group || len(series[i].Points) != len(expected[i].Points) {
+			t.Fatalf(`TestFillTimeSeries did not return %v got %v`, expected, series)
+		}
+		for j := range expected[i].Points {
+			if series[i].Points[j] != expected[i].Points[j] {
+				t.Fatalf(
+					`TestFillTimeSeries did not return %v got %v`,
+					expected[i].Points[j], series[i].Points[j],
+				)
+			}
+		}
+	}
+
+	// Too many buckets are rejected
+	_, err = FillTimeSeries(values, "day", from, from.AddDate(20, 0, 0))
+	if err == nil {
+		t.Fatalf(`TestFillTimeSeries did not reject more than %d buckets`, MaxTimeSeriesBuckets)
+	}
+}
@@ -1,8 +1,13 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // isStringInArray returns true if a string is in a
@@ -56,3 +61,72 @@ func StripNonLetters(str string) string {
 	}
 	return string(s[:n])
 }
+
+// EscapeSQLString escapes single quotes in a string so that it can be
+// used inside an SQL string literal.
+func EscapeSQLString(str string) string {
+	return strings.ReplaceAll(str, "'", "''")
+}
+
+// ParseDistance returns the number of metres in a distance
+// such as "500m", "2km" or "1500" (in metres).
+func ParseDistance(distance string) (float64, error) {
+	number := strings.ToLower(strings.TrimSpace(distance))
+	unit := 1.0
+
+	if strings.HasSuffix(number, "km") {
+		number, unit = strings.TrimSuffix(number, "km"), 1000
+	} else if strings.HasSuffix(number, "m") {
+		number = strings.TrimSuffix(number, "m")
+	}
+
+	// ParseFloat accepts NaN and infinities, which can't be put into SQL
+	metres, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(metres) || math.IsInf(metres*unit, 0) || metres <= 0 {
+		return 0, fmt.Errorf("invalid distance %q", distance)
+	}
+	return metres * unit, nil
+}
+
+// ParseCoordinate returns the degrees of a longitude or latitude, which
+// must be a finite number between -limit and limit (180 for longitudes,
+// 90 for latitudes).
+func ParseCoordinate(coordinate string, limit float64) (float64, error) {
+	degrees, err := strconv.ParseFloat(strings.TrimSpace(coordinate), 64)
+	if err != nil || math.IsNaN(degrees) || math.Abs(degrees) > limit {
+		return 0, fmt.Errorf("invalid coordinate %q", coordinate)
+	}
+	return degrees, nil
+}
+
+// ParseWindow returns the duration of a time window such as "90d",
+// "12w" or "36h". A number without a unit is a number of days.
+func ParseWindow(window string) (time.Duration, error) {
+	number := strings.ToLower(strings.TrimSpace(window))
+	unit := 24 * time.Hour
+
+	switch {
+	case strings.HasSuffix(number, "w"):
+		number, unit = strings.TrimSuffix(number, "w"), 7*24*time.Hour
+	case strings.HasSuffix(number, "d"):
+		number = strings.TrimSuffix(number, "d")
+	case strings.HasSuffix(number, "h"):
+		number, unit = strings.TrimSuffix(number, "h"), time.Hour
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return 0, fmt.Errorf("invalid window %q", window)
+	}
+	return time.Duration(value * float64(unit)), nil
+}
+
+// RandomToken returns a hex-encoded random token of n bytes, such as the
+// secret of a webhook subscription.
+func RandomToken(n int) (string, error) {
+	token := make([]byte, n)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,93 @@
+// utils_test.go contains tests that test utils.go
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseDistance calls api.ParseDistance and checks that distances
+// with and without units are converted to metres.
+func TestParseDistance(t *testing.T) {
+	tests := map[string]float64{
+		"500":    500,
+		"500m":   500,
+		"2km":    2000,
+		" 1.5KM": 1500,
+	}
+
+	for test, expected := range tests {
+		if actual, err := ParseDistance(test); err != nil || actual != expected {
+			t.Fatalf(
+				`TestParseDistance did not return %v got %v, %v`,
+				expected, actual, err,
+			)
+		}
+	}
+
+	for _, test := range []string{"", "far", "-5m", "0", "NaN", "nanm", "Inf", "-Inf", "1e400", "1e306km"} {
+		if _, err := ParseDistance(test); err == nil {
+			t.Fatalf(`TestParseDistance did not reject %q`, test)
+		}
+	}
+}
+
+// TestParseCoordinate calls api.ParseCoordinate and checks that only
+// finite coordinates within the limit are accepted.
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		coordinate string
+		limit      float64
+		expected   bool
+	}{
+		{"174.762415", 180, true},
+		{" -36.855109", 90, true},
+		{"-91", 90, false},
+		{"NaN", 180, false},
+		{"Inf", 180, false},
+		{"-Inf", 90, false},
+		{"1e400", 180, false},
+		{"east", 180, false},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseCoordinate(test.coordinate, test.limit); (err == nil) != test.expected {
+			t.Fatalf(`TestParseCoordinate did not return %v for %q got %v`,
+				test.expected, test.coordinate, err)
+		}
+	}
+}
+
+// TestEscapeSQLString calls api.EscapeSQLString and checks that single
+// quotes are escaped.
+func TestEscapeSQLString(t *testing.T) {
+	if actual := EscapeSQLString("O'Neills Avenue'; --"); actual != "O''Neills Avenue''; --" {
+		t.Fatalf(`TestEscapeSQLString did not escape quotes, got %s`, actual)
+	}
+}
+
+// TestParseWindow calls api.ParseWindow and checks that windows with and
+// without units are converted to durations.
+func TestParseWindow(t *testing.T) {
+	tests := map[string]time.Duration{
+		"90":   90 * 24 * time.Hour,
+		"90d":  90 * 24 * time.Hour,
+		"2W":   14 * 24 * time.Hour,
+		" 36h": 36 * time.Hour,
+	}
+
+	for test, expected := range tests {
+		if actual, err := ParseWindow(test); err != nil || actual != expected {
+			t.Fatalf(
+				`TestParseWindow did not return %v got %v, %v`,
+				expected, actual, err,
+			)
+		}
+	}
+
+	for _, test := range []string{"", "soon", "-5d", "0", "NaN", "Infd"} {
+		if _, err := ParseWindow(test); err == nil {
+			t.Fatalf(`TestParseWindow did not reject %q`, test)
+		}
+	}
+}
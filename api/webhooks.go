@@ -0,0 +1,708 @@
+// webhooks.go contains the webhook subscriptions to outage events, the
+// job that queues the events of each ingest for the matching
+// subscriptions, and the worker that delivers the queues as signed JSON.
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/axkeyz/water-down-again/database"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultWebhookAttempts is the number of times a webhook is sent
+	// before its event is dead-lettered.
+	DefaultWebhookAttempts = 5
+	// DefaultWebhookBackoff is the wait before the first retry of a
+	// webhook, which doubles after each retry.
+	DefaultWebhookBackoff = 2 * time.Second
+	// WebhookTimeout is the time a callback URL has to respond.
+	WebhookTimeout = 10 * time.Second
+	// MaxWebhookRedirects is the number of redirects a webhook follows.
+	MaxWebhookRedirects = 3
+	// MaxQueuedWebhooks is the number of webhooks queued for a
+	// subscription, after which further events are dead-lettered.
+	MaxQueuedWebhooks = 1000
+	// DefaultWebhookWorkers is the number of subscriptions sent webhooks
+	// at the same time.
+	DefaultWebhookWorkers = 4
+	// WebhookPollInterval is the time between sending the queued webhooks
+	// that are due.
+	WebhookPollInterval = 5 * time.Second
+)
+
+// blockedNetworks are the networks that webhooks are not sent to:
+// unspecified, loopback, private, shared, link-local (including the
+// 169.254.169.254 metadata address), reserved and multicast addresses.
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16",
+	"198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+// parseNetworks returns the networks of CIDR strings.
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// SubscriptionFilters are the parameters that filter the outages of a
+// subscription.
+var SubscriptionFilters = []string{
+	"region", "suburb", "street", "outage_type",
+	"longitude", "latitude", "radius",
+}
+
+// A Subscription struct maps a callback URL that is sent the events of
+// outages matching its filter. The secret signs the webhooks, and is only
+// shown when the subscription is created.
+type Subscription struct {
+	ID          int        `json:"id"`
+	CallbackURL string     `json:"callback_url"`
+	Secret      string     `json:"secret,omitempty"`
+	Filter      url.Values `json:"filter"`
+	CreatedAt   string     `json:"created_at,omitempty"`
+}
+
+// A WebhookDelivery struct maps an attempt to send a webhook. StatusCode
+// is 0 if the callback URL could not be reached.
+type WebhookDelivery struct {
+	SubscriptionID int
+	EventID        int64
+	Attempt        int
+	StatusCode     int
+	Error          string
+	Payload        []byte
+}
+
+// A QueuedWebhook struct maps a webhook waiting to be sent to a
+// subscription, with the attempts made so far and when to try again.
+type QueuedWebhook struct {
+	ID            int64
+	Subscription  Subscription
+	EventID       int64
+	EventType     string
+	Payload       []byte
+	Attempts      int
+	NextAttemptAt time.Time
+	Error         string
+}
+
+// A WebhookStore saves the queue of webhooks of each subscription with
+// their retry state, the deliveries of webhooks, and the last failed
+// delivery of events that could not be delivered.
+type WebhookStore interface {
+	// Enqueue adds a webhook to the end of the queue of its subscription,
+	// unless the queue already has limit webhooks.
+	Enqueue(webhook QueuedWebhook, limit int) (bool, error)
+	// DueWebhooks returns the first webhook in the queue of each
+	// subscription, if it is due to be sent.
+	DueWebhooks(now time.Time) ([]QueuedWebhook, error)
+	// Reschedule saves the attempts, next attempt and error of a webhook.
+	Reschedule(webhook QueuedWebhook) error
+	// Dequeue removes a webhook from the queue.
+	Dequeue(webhook QueuedWebhook) error
+	LogDelivery(delivery WebhookDelivery) error
+	DeadLetter(delivery WebhookDelivery) error
+}
+
+// A WebhookDispatcher struct sends the queued webhooks of up to Workers
+// subscriptions at a time, retrying failed webhooks with an exponential
+// backoff. The webhooks of each subscription are sent one at a time, in
+// order, so a failing callback URL only holds up its own queue.
+type WebhookDispatcher struct {
+	Client      *http.Client
+	Store       WebhookStore
+	MaxAttempts int
+	Backoff     time.Duration
+	Workers     int
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher with the default
+// attempts, backoff & workers that keeps its queue in store.
+func NewWebhookDispatcher(store WebhookStore) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Client:      NewWebhookClient(),
+		Store:       store,
+		MaxAttempts: DefaultWebhookAttempts,
+		Backoff:     DefaultWebhookBackoff,
+		Workers:     DefaultWebhookWorkers,
+	}
+}
+
+// SignWebhook returns the signature of a webhook body, the hex-encoded
+// HMAC-SHA256 of the body keyed by the secret of the subscription.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MakeSubscriptionFilter returns the subscription filters of the given
+// parameters, and the names of invalid filters.
+func MakeSubscriptionFilter(params url.Values) (url.Values, []string) {
+	filter := url.Values{}
+	for _, param := range SubscriptionFilters {
+		if values, ok := params[param]; ok {
+			filter[param] = values
+		}
+	}
+
+	query := &Query{IsCount: true}
+	query.MakeWhereString(filter)
+	return filter, query.InvalidParams
+}
+
+// IsCallbackURL returns true if a URL is an absolute http(s) URL.
+func IsCallbackURL(callback string) bool {
+	parsed, err := url.Parse(callback)
+	return err == nil && parsed.Host != "" &&
+		(parsed.Scheme == "http" || parsed.Scheme == "https")
+}
+
+// IsPublicIP returns true if an IP address is not in any of the
+// blockedNetworks.
+func IsPublicIP(ip net.IP) bool {
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCallbackURL returns an error if a URL is not an absolute http(s)
+// URL, or if its host resolves to an address that is not public.
+func CheckCallbackURL(callback string) error {
+	if !IsCallbackURL(callback) {
+		return errors.New("The callback_url parameter must be an absolute http or https URL.")
+	}
+
+	parsed, _ := url.Parse(callback)
+	ips, err := net.LookupIP(parsed.Hostname())
+	if err != nil || len(ips) == 0 {
+		return errors.New("The host of the callback_url parameter could not be resolved.")
+	}
+	for _, ip := range ips {
+		if !IsPublicIP(ip) {
+			return errors.New("The callback_url parameter must not be a loopback, " +
+				"private, link-local or other internal address.")
+		}
+	}
+	return nil
+}
+
+// NewWebhookClient returns an HTTP client that only connects to public
+// addresses. The address is checked when each connection is dialled, so
+// that redirects and hosts that resolve differently after a subscription
+// was created can't reach internal addresses.
+func NewWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: WebhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("webhooks may not be sent to %s", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: WebhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: WebhookTimeout,
+		},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) > MaxWebhookRedirects {
+				return fmt.Errorf("stopped after %d redirects", MaxWebhookRedirects)
+			} else if !IsCallbackURL(request.URL.String()) {
+				return fmt.Errorf("redirected to %s", request.URL)
+			}
+			return nil
+		},
+	}
+}
+
+// Queue adds a webhook of an event to the queue of a subscription. If the
+// queue is full, the event is dead-lettered instead.
+func (dispatcher *WebhookDispatcher) Queue(subscription Subscription,
+	event OutageEvent, body []byte) error {
+	queued, err := dispatcher.Store.Enqueue(QueuedWebhook{
+		Subscription: subscription, EventID: event.ID,
+		EventType: event.Type, Payload: body,
+	}, MaxQueuedWebhooks)
+	if err != nil || queued {
+		return err
+	}
+
+	return dispatcher.Store.DeadLetter(WebhookDelivery{
+		SubscriptionID: subscription.ID, EventID: event.ID, Payload: body,
+		Error: fmt.Sprintf("More than %d webhooks are queued.", MaxQueuedWebhooks),
+	})
+}
+
+// Attempt posts a queued webhook once and logs the delivery to the store.
+// A delivered webhook is dequeued. A failed webhook is rescheduled after
+// the backoff, doubled for each earlier attempt, until the attempts run
+// out, in which case it is dead-lettered and dequeued. Client errors
+// other than 408 & 429 are not retried. It returns true if the webhook
+// was delivered.
+func (dispatcher *WebhookDispatcher) Attempt(webhook QueuedWebhook, now time.Time) bool {
+	webhook.Attempts++
+	delivery := WebhookDelivery{
+		SubscriptionID: webhook.Subscription.ID, EventID: webhook.EventID,
+		Attempt: webhook.Attempts, Payload: webhook.Payload,
+	}
+	retry := true
+
+	response, err := dispatcher.post(webhook)
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		response.Body.Close()
+		delivery.StatusCode = response.StatusCode
+		if response.StatusCode >= 200 && response.StatusCode < 300 {
+			dispatcher.logDelivery(delivery)
+			dispatcher.dequeue(webhook)
+			return true
+		}
+
+		delivery.Error = response.Status
+		retry = response.StatusCode >= 500 ||
+			response.StatusCode == http.StatusRequestTimeout ||
+			response.StatusCode == http.StatusTooManyRequests
+	}
+	dispatcher.logDelivery(delivery)
+
+	if retry && webhook.Attempts < dispatcher.MaxAttempts {
+		webhook.NextAttemptAt = now.Add(dispatcher.Backoff << uint(webhook.Attempts-1))
+		webhook.Error = delivery.Error
+		if err := dispatcher.Store.Reschedule(webhook); err != nil {
+			log.Println("Rescheduling a webhook failed:", err)
+		}
+		return false
+	}
+
+	if err := dispatcher.Store.DeadLetter(delivery); err != nil {
+		log.Println("Dead-lettering a webhook failed:", err)
+		return false
+	}
+	dispatcher.dequeue(webhook)
+	return false
+}
+
+// SendDue attempts the due webhook of each subscription, using up to
+// Workers goroutines, and returns the number of webhooks delivered and
+// failed.
+func (dispatcher *WebhookDispatcher) SendDue(now time.Time) (sent, failed int) {
+	webhooks, err := dispatcher.Store.DueWebhooks(now)
+	if err != nil {
+		log.Println("Getting the queued webhooks failed:", err)
+		return
+	}
+
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	workers := make(chan struct{}, dispatcher.Workers)
+	for _, webhook := range webhooks {
+		wait.Add(1)
+		workers <- struct{}{}
+		go func(webhook QueuedWebhook) {
+			defer func() { <-workers; wait.Done() }()
+
+			delivered := dispatcher.Attempt(webhook, now)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if delivered {
+				sent++
+			} else {
+				failed++
+			}
+		}(webhook)
+	}
+	wait.Wait()
+	return
+}
+
+// dequeue removes a webhook from the queue of the store.
+func (dispatcher *WebhookDispatcher) dequeue(webhook QueuedWebhook) {
+	if err := dispatcher.Store.Dequeue(webhook); err != nil {
+		log.Println("Dequeuing a webhook failed:", err)
+	}
+}
+
+// post sends a single webhook.
+func (dispatcher *WebhookDispatcher) post(webhook QueuedWebhook) (*http.Response, error) {
+	request, err := http.NewRequest(
+		http.MethodPost, webhook.Subscription.CallbackURL,
+		bytes.NewReader(webhook.Payload),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "water-down-again-webhooks")
+	request.Header.Set("X-Webhook-Event", webhook.EventType)
+	request.Header.Set("X-Webhook-ID", fmt.Sprint(webhook.EventID))
+	request.Header.Set("X-Webhook-Signature",
+		SignWebhook(webhook.Subscription.Secret, webhook.Payload))
+	return dispatcher.Client.Do(request)
+}
+
+// logDelivery saves a delivery to the store.
+func (dispatcher *WebhookDispatcher) logDelivery(delivery WebhookDelivery) {
+	if err := dispatcher.Store.LogDelivery(delivery); err != nil {
+		log.Println("Logging a webhook delivery failed:", err)
+	}
+}
+
+// dbWebhookStore saves the queue and deliveries of webhooks to the
+// database.
+type dbWebhookStore struct {
+	db *sql.DB
+}
+
+// Enqueue adds a webhook to the webhook_queue table, unless the queue of
+// its subscription is full.
+func (store dbWebhookStore) Enqueue(webhook QueuedWebhook, limit int) (bool, error) {
+	result, err := store.db.Exec(
+		`INSERT INTO webhook_queue (subscription_id, event_id, event_type, payload)
+		SELECT $1, $2, $3, $4 WHERE (
+			SELECT count(*) FROM webhook_queue WHERE subscription_id = $1
+		) < $5`,
+		webhook.Subscription.ID, webhook.EventID, webhook.EventType,
+		string(webhook.Payload), limit,
+	)
+	if err != nil {
+		return false, err
+	}
+	queued, err := result.RowsAffected()
+	return queued > 0, err
+}
+
+// DueWebhooks returns the oldest queued webhook of each subscription with
+// the subscription, if it is due.
+func (store dbWebhookStore) DueWebhooks(now time.Time) ([]QueuedWebhook, error) {
+	var webhooks []QueuedWebhook
+
+	rows, err := store.db.Query(
+		`SELECT * FROM (
+			SELECT DISTINCT ON (webhook_queue.subscription_id) webhook_queue.id,
+			webhook_queue.subscription_id, subscription.callback_url, subscription.secret,
+			event_id, event_type, payload, attempts, next_attempt_at
+			FROM webhook_queue INNER JOIN subscription
+			ON subscription.id = webhook_queue.subscription_id
+			ORDER BY webhook_queue.subscription_id, webhook_queue.id
+		) heads WHERE next_attempt_at <= $1`, now,
+	)
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook QueuedWebhook
+		var payload string
+		if err := rows.Scan(&webhook.ID, &webhook.Subscription.ID,
+			&webhook.Subscription.CallbackURL, &webhook.Subscription.Secret,
+			&webhook.EventID, &webhook.EventType, &payload, &webhook.Attempts,
+			&webhook.NextAttemptAt); err != nil {
+			return webhooks, err
+		}
+		webhook.Payload = []byte(payload)
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// Reschedule saves the retry state of a webhook to the webhook_queue
+// table.
+func (store dbWebhookStore) Reschedule(webhook QueuedWebhook) error {
+	_, err := store.db.Exec(
+		`UPDATE webhook_queue SET attempts = $2, next_attempt_at = $3,
+		last_error = NULLIF($4, '') WHERE id = $1`,
+		webhook.ID, webhook.Attempts, webhook.NextAttemptAt, webhook.Error,
+	)
+	return err
+}
+
+// Dequeue removes a webhook from the webhook_queue table.
+func (store dbWebhookStore) Dequeue(webhook QueuedWebhook) error {
+	_, err := store.db.Exec(`DELETE FROM webhook_queue WHERE id = $1`, webhook.ID)
+	return err
+}
+
+// LogDelivery saves a delivery to the webhook_delivery table.
+func (store dbWebhookStore) LogDelivery(delivery WebhookDelivery) error {
+	_, err := store.db.Exec(
+		`INSERT INTO webhook_delivery (subscription_id, event_id, attempt,
+		status_code, error) VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''))`,
+		delivery.SubscriptionID, delivery.EventID, delivery.Attempt,
+		delivery.StatusCode, delivery.Error,
+	)
+	return err
+}
+
+// DeadLetter saves an undelivered event to the webhook_dead_letter table.
+func (store dbWebhookStore) DeadLetter(delivery WebhookDelivery) error {
+	_, err := store.db.Exec(
+		`INSERT INTO webhook_dead_letter (subscription_id, event_id, payload,
+		error) VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		delivery.SubscriptionID, delivery.EventID, string(delivery.Payload),
+		delivery.Error,
+	)
+	return err
+}
+
+// GetSubscriptions returns all webhook subscriptions with their secrets.
+func GetSubscriptions(db *sql.DB) ([]Subscription, error) {
+	var subscriptions []Subscription
+
+	rows, err := db.Query(
+		`SELECT id, callback_url, secret, filter, created_at FROM subscription
+		ORDER BY id`,
+	)
+	if err != nil {
+		return subscriptions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subscription Subscription
+		var filter string
+		if err := rows.Scan(&subscription.ID, &subscription.CallbackURL,
+			&subscription.Secret, &filter, &subscription.CreatedAt); err != nil {
+			return subscriptions, err
+		}
+		if subscription.Filter, err = url.ParseQuery(filter); err != nil {
+			return subscriptions, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// QueueWebhooks queues the events of an ingest for the subscriptions
+// whose filter matches the outage of an event, in the order of the
+// events. The webhooks are sent by RunWebhooks.
+func QueueWebhooks(report IngestReport) {
+	if len(report.Events) == 0 {
+		return
+	}
+
+	// Open database
+	db := database.DB()
+
+	subscriptions, err := GetSubscriptions(db)
+	if err != nil {
+		log.Println("Getting subscriptions failed:", err)
+		return
+	}
+
+	dispatcher := NewWebhookDispatcher(dbWebhookStore{db})
+	queued := 0
+	for _, subscription := range subscriptions {
+		payloads, err := MatchOutageEvents(db, report.Events, subscription.Filter)
+		if err != nil {
+			log.Println("Matching the outages of subscription",
+				subscription.ID, "failed:", err)
+			continue
+		}
+
+		for _, payload := range payloads {
+			body, err := json.Marshal(payload)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			if err := dispatcher.Queue(subscription, payload.OutageEvent, body); err != nil {
+				log.Println("Queuing a webhook failed:", err)
+				continue
+			}
+			queued++
+		}
+	}
+
+	log.Printf("Webhooks have been queued (%d queued).", queued)
+}
+
+// RunWebhooks sends the queued webhooks that are due every
+// WebhookPollInterval, and never returns.
+func RunWebhooks() {
+	dispatcher := NewWebhookDispatcher(dbWebhookStore{database.DB()})
+	for {
+		if sent, failed := dispatcher.SendDue(time.Now()); sent+failed > 0 {
+			log.Printf("Webhooks have been sent (%d sent, %d failed).", sent, failed)
+		}
+		<-time.After(WebhookPollInterval)
+	}
+}
+
+// CreateSubscription registers a callback URL that is sent the events of
+// outages matching the suburb, street, outage_type, region and
+// longitude/latitude/radius parameters, and JSON-encodes the subscription
+// with the secret that signs its webhooks.
+func CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received CreateSubscription request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	params := GetRequestParams(r)
+	callback := params.Get("callback_url")
+	if err := CheckCallbackURL(callback); err != nil {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3495,
+			Message:   "invalid callback url",
+			Details:   err.Error(),
+		})
+		return
+	}
+
+	filter, invalid := MakeSubscriptionFilter(params)
+	if len(invalid) > 0 {
+		WriteAppError(w, http.StatusBadRequest, AppError{
+			ErrorCode: 3440,
+			Message:   "invalid parameters",
+			Details: "Parameters given for this API were invalid: " +
+				strings.Join(invalid, ", ") + ".",
+		})
+		return
+	}
+
+	secret, err := RandomToken(32)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3496,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+	subscription := Subscription{
+		CallbackURL: callback,
+		Secret:      secret,
+		Filter:      filter,
+	}
+
+	// Setup the database
+	db := database.DB()
+
+	err = db.QueryRow(
+		`INSERT INTO subscription (callback_url, secret, filter)
+		VALUES ($1, $2, $3) RETURNING id, created_at`,
+		subscription.CallbackURL, subscription.Secret, filter.Encode(),
+	).Scan(&subscription.ID, &subscription.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3496,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+// ListSubscriptions JSON-encodes all webhook subscriptions, without their
+// secrets.
+func ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received ListSubscriptions request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Setup the database
+	db := database.DB()
+
+	subscriptions, err := GetSubscriptions(db)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3496,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	for i := range subscriptions {
+		subscriptions[i].Secret = ""
+	}
+	if subscriptions == nil {
+		subscriptions = []Subscription{}
+	}
+	WriteJSON(w, subscriptions)
+}
+
+// DeleteSubscription deletes the webhook subscription of the id in the
+// url, with its deliveries and dead letters.
+func DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received DeleteSubscription request.")
+
+	// Setup CORS headers
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	// Setup the database
+	db := database.DB()
+
+	result, err := db.Exec(
+		`DELETE FROM subscription WHERE id = $1`, mux.Vars(r)["id"],
+	)
+	if err != nil {
+		log.Println(err)
+		WriteAppError(w, http.StatusInternalServerError, AppError{
+			ErrorCode: 3496,
+			Message:   "unknown error",
+			Details:   "Please contact me at xahkun@gmail.com to figure out this issue.",
+		})
+		return
+	}
+
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		WriteAppError(w, http.StatusNotFound, AppError{
+			ErrorCode: 3497,
+			Message:   "subscription not found",
+			Details:   "There is no subscription with this id.",
+		})
+		return
+	}
+
+	WriteJSON(w, map[string]string{"Message": "The subscription has been deleted."})
+}
@@ -0,0 +1,352 @@
+// webhooks_test.go contains tests that test webhooks.go
+package api
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryWebhookStore keeps the queue and deliveries of webhooks in
+// memory.
+type memoryWebhookStore struct {
+	mutex       sync.Mutex
+	queue       []QueuedWebhook
+	nextID      int64
+	deliveries  []WebhookDelivery
+	deadLetters []WebhookDelivery
+}
+
+func (store *memoryWebhookStore) Enqueue(webhook QueuedWebhook, limit int) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	queued := 0
+	for _, other := range store.queue {
+		if other.Subscription.ID == webhook.Subscription.ID {
+			queued++
+		}
+	}
+	if queued >= limit {
+		return false, nil
+	}
+	store.nextID++
+	webhook.ID = store.nextID
+	store.queue = append(store.queue, webhook)
+	return true, nil
+}
+
+func (store *memoryWebhookStore) DueWebhooks(now time.Time) ([]QueuedWebhook, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var due []QueuedWebhook
+	seen := map[int]bool{}
+	for _, webhook := range store.queue {
+		if !seen[webhook.Subscription.ID] && !webhook.NextAttemptAt.After(now) {
+			due = append(due, webhook)
+		}
+		seen[webhook.Subscription.ID] = true
+	}
+	return due, nil
+}
+
+func (store *memoryWebhookStore) Reschedule(webhook QueuedWebhook) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := range store.queue {
+		if store.queue[i].ID == webhook.ID {
+			store.queue[i] = webhook
+		}
+	}
+	return nil
+}
+
+func (store *memoryWebhookStore) Dequeue(webhook QueuedWebhook) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for i := range store.queue {
+		if store.queue[i].ID == webhook.ID {
+			store.queue = append(store.queue[:i], store.queue[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (store *memoryWebhookStore) LogDelivery(delivery WebhookDelivery) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.deliveries = append(store.deliveries, delivery)
+	return nil
+}
+
+func (store *memoryWebhookStore) DeadLetter(delivery WebhookDelivery) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.deadLetters = append(store.deadLetters, delivery)
+	return nil
+}
+
+// TestSignWebhook calls api.SignWebhook and checks that the signature is
+// the HMAC-SHA256 of the body.
+func TestSignWebhook(t *testing.T) {
+	actual := SignWebhook("key", []byte("The quick brown fox jumps over the lazy dog"))
+	expected := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+
+	if actual != expected {
+		t.Fatalf(`TestSignWebhook did not return %v got %v`, expected, actual)
+	}
+}
+
+// TestMakeSubscriptionFilter calls api.MakeSubscriptionFilter and checks
+// that only subscription filters are kept, and invalid ones are found.
+func TestMakeSubscriptionFilter(t *testing.T) {
+	filter, invalid := MakeSubscriptionFilter(url.Values{
+		"callback_url": {"https://example.com/hook"},
+		"suburb":       {"Remuera"},
+		"outage_type":  {"Planned"},
+		"limit":        {"10"},
+	})
+	expected := "outage_type=Planned&suburb=Remuera"
+	if filter.Encode() != expected || len(invalid) != 0 {
+		t.Fatalf(`TestMakeSubscriptionFilter did not return %v got %v, %v`,
+			expected, filter.Encode(), invalid)
+	}
+
+	_, invalid = MakeSubscriptionFilter(url.Values{
+		"longitude": {"174.76"}, "latitude": {"north"}, "radius": {"500m"},
+	})
+	if len(invalid) != 1 {
+		t.Fatalf(`TestMakeSubscriptionFilter did not return 1 invalid param got %v`, invalid)
+	}
+}
+
+// TestIsCallbackURL calls api.IsCallbackURL and checks that only
+// absolute http(s) URLs are callback URLs.
+func TestIsCallbackURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/hook":      true,
+		"http://localhost:9000/outages": true,
+		"ftp://example.com/hook":        false,
+		"/hook":                         false,
+		"":                              false,
+	}
+
+	for callback, expected := range tests {
+		if actual := IsCallbackURL(callback); actual != expected {
+			t.Fatalf(`TestIsCallbackURL did not return %v for %q got %v`,
+				expected, callback, actual)
+		}
+	}
+}
+
+// TestIsPublicIP calls api.IsPublicIP and checks that loopback, private,
+// link-local and metadata addresses are not public.
+func TestIsPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.20.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fd00::1":          false,
+		"fe80::1":          false,
+		"::ffff:127.0.0.1": false,
+	}
+
+	for address, expected := range tests {
+		if actual := IsPublicIP(net.ParseIP(address)); actual != expected {
+			t.Fatalf(`TestIsPublicIP did not return %v for %s got %v`, expected, address, actual)
+		}
+	}
+}
+
+// TestCheckCallbackURL calls api.CheckCallbackURL and checks that URLs of
+// internal addresses are rejected.
+func TestCheckCallbackURL(t *testing.T) {
+	for _, callback := range []string{
+		"http://127.0.0.1:9000/outages", "http://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook", "http://10.0.0.5/hook", "ftp://93.184.216.34/hook",
+	} {
+		if err := CheckCallbackURL(callback); err == nil {
+			t.Fatalf(`TestCheckCallbackURL did not reject %s`, callback)
+		}
+	}
+
+	if err := CheckCallbackURL("https://93.184.216.34/hook"); err != nil {
+		t.Fatalf(`TestCheckCallbackURL did not return nil got %v`, err)
+	}
+}
+
+// TestNewWebhookClient calls api.NewWebhookClient and checks that it does
+// not connect to a loopback address.
+func TestNewWebhookClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf(`TestNewWebhookClient connected to a loopback address`)
+		},
+	))
+	defer server.Close()
+
+	if response, err := NewWebhookClient().Post(server.URL, "application/json", nil); err == nil {
+		response.Body.Close()
+		t.Fatalf(`TestNewWebhookClient did not return an error`)
+	}
+}
+
+// TestWebhookDispatcherSendDue calls api.WebhookDispatcher.SendDue and
+// checks that a signed webhook is rescheduled with a doubling backoff
+// until it is delivered, without holding up the next webhooks.
+func TestWebhookDispatcherSendDue(t *testing.T) {
+	body := []byte(`{"id":7,"type":"outage.created"}`)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			received, _ := ioutil.ReadAll(r.Body)
+			if string(received) != string(body) ||
+				r.Header.Get("X-Webhook-Signature") != SignWebhook("secret", body) ||
+				r.Header.Get("X-Webhook-Event") != OutageCreated {
+				t.Errorf(`TestWebhookDispatcherSendDue received an unsigned webhook %s`, received)
+			}
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		},
+	))
+	defer server.Close()
+
+	store := &memoryWebhookStore{}
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Client = server.Client()
+
+	subscription := Subscription{ID: 1, CallbackURL: server.URL, Secret: "secret"}
+	dispatcher.Queue(subscription, OutageEvent{ID: 7, Type: OutageCreated}, body)
+
+	now := time.Now()
+	if sent, failed := dispatcher.SendDue(now); sent != 0 || failed != 1 {
+		t.Fatalf(`TestWebhookDispatcherSendDue did not return 0, 1 got %v, %v`, sent, failed)
+	}
+	if next := store.queue[0].NextAttemptAt; !next.Equal(now.Add(DefaultWebhookBackoff)) {
+		t.Fatalf(`TestWebhookDispatcherSendDue did not reschedule after %v got %v`,
+			DefaultWebhookBackoff, next.Sub(now))
+	}
+
+	// The webhook is not due until its backoff has passed
+	if sent, failed := dispatcher.SendDue(now); sent+failed != 0 {
+		t.Fatalf(`TestWebhookDispatcherSendDue sent a webhook before it was due`)
+	}
+
+	now = now.Add(DefaultWebhookBackoff)
+	dispatcher.SendDue(now)
+	if next := store.queue[0].NextAttemptAt; !next.Equal(now.Add(2 * DefaultWebhookBackoff)) {
+		t.Fatalf(`TestWebhookDispatcherSendDue did not double the backoff got %v`, next.Sub(now))
+	}
+
+	if sent, _ := dispatcher.SendDue(now.Add(time.Hour)); sent != 1 || len(store.queue) != 0 {
+		t.Fatalf(`TestWebhookDispatcherSendDue did not deliver the webhook`)
+	}
+	if len(store.deliveries) != 3 || store.deliveries[2].StatusCode != 200 ||
+		store.deliveries[0].StatusCode != 503 || len(store.deadLetters) != 0 {
+		t.Fatalf(`TestWebhookDispatcherSendDue did not log 3 deliveries got %+v, %+v`,
+			store.deliveries, store.deadLetters)
+	}
+}
+
+// TestWebhookDispatcherQueue calls api.WebhookDispatcher.SendDue and
+// checks that the webhooks of a subscription are sent in order, and that
+// a failing subscription does not hold up others.
+func TestWebhookDispatcherQueue(t *testing.T) {
+	var mutex sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, r.URL.Path+"#"+r.Header.Get("X-Webhook-ID"))
+			if r.URL.Path == "/down" {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		},
+	))
+	defer server.Close()
+
+	store := &memoryWebhookStore{}
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Client = server.Client()
+
+	up := Subscription{ID: 1, CallbackURL: server.URL + "/up"}
+	down := Subscription{ID: 2, CallbackURL: server.URL + "/down"}
+	for id := int64(1); id <= 2; id++ {
+		dispatcher.Queue(down, OutageEvent{ID: id}, []byte(`{}`))
+		dispatcher.Queue(up, OutageEvent{ID: id}, []byte(`{}`))
+	}
+
+	now := time.Now()
+	dispatcher.SendDue(now)
+	dispatcher.SendDue(now)
+
+	sort.Strings(received)
+	expected := "/down#1 /up#1 /up#2"
+	if actual := strings.Join(received, " "); actual != expected {
+		t.Fatalf(`TestWebhookDispatcherQueue did not return %v got %v`, expected, actual)
+	}
+}
+
+// TestWebhookDispatcherDeadLetter calls api.WebhookDispatcher.Attempt and
+// checks that a webhook that keeps failing is dead-lettered, that client
+// errors are not retried, and that events are dead-lettered when the
+// queue is full.
+func TestWebhookDispatcherDeadLetter(t *testing.T) {
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		},
+	))
+	defer server.Close()
+
+	store := &memoryWebhookStore{}
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Client = server.Client()
+	dispatcher.MaxAttempts = 3
+
+	subscription := Subscription{ID: 1, CallbackURL: server.URL, Secret: "secret"}
+	dispatcher.Queue(subscription, OutageEvent{ID: 8, Type: OutageResolved}, []byte(`{}`))
+	for attempt := 0; attempt < 3; attempt++ {
+		dispatcher.SendDue(time.Now().Add(time.Duration(attempt) * time.Hour))
+	}
+	if len(store.deliveries) != 3 || len(store.deadLetters) != 1 ||
+		store.deadLetters[0].EventID != 8 || len(store.queue) != 0 {
+		t.Fatalf(`TestWebhookDispatcherDeadLetter did not dead-letter after 3 attempts got %+v, %+v`,
+			store.deliveries, store.deadLetters)
+	}
+
+	status = http.StatusGone
+	store.deliveries, store.deadLetters = nil, nil
+	dispatcher.Queue(subscription, OutageEvent{ID: 9}, []byte(`{}`))
+	dispatcher.SendDue(time.Now())
+	if len(store.deliveries) != 1 || len(store.deadLetters) != 1 || len(store.queue) != 0 {
+		t.Fatalf(`TestWebhookDispatcherDeadLetter retried a client error got %+v`,
+			store.deliveries)
+	}
+
+	store.deadLetters = nil
+	for id := int64(0); id <= MaxQueuedWebhooks; id++ {
+		dispatcher.Queue(subscription, OutageEvent{ID: id}, []byte(`{}`))
+	}
+	if len(store.queue) != MaxQueuedWebhooks || len(store.deadLetters) != 1 {
+		t.Fatalf(`TestWebhookDispatcherDeadLetter did not dead-letter the event after a full queue got %v, %v`,
+			len(store.queue), len(store.deadLetters))
+	}
+}
@@ -0,0 +1,244 @@
+// migrate.go applies schema changes made after docker_postgres_init.sql
+// to the database of this app.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+)
+
+// migrations lists the schema changes applied on top of
+// docker_postgres_init.sql. New changes are appended to the end and
+// existing entries are never edited, as their index is the version
+// recorded in the schema_migrations table.
+var migrations = []string{
+	// 1: keep the raw upstream location and the address parser version
+	`ALTER TABLE outage ADD COLUMN IF NOT EXISTS raw_location TEXT;
+	ALTER TABLE outage ADD COLUMN IF NOT EXISTS parser_version INT NOT NULL DEFAULT 0;`,
+	// 2: track outages of multiple regions, whose outage ids may overlap
+	`ALTER TABLE outage ADD COLUMN IF NOT EXISTS region VARCHAR(64) NOT NULL DEFAULT 'auckland';
+	ALTER TABLE outage DROP CONSTRAINT IF EXISTS outage_outage_id_key;
+	CREATE UNIQUE INDEX IF NOT EXISTS outage_region_outage_id_key ON outage (region, outage_id);`,
+	// 3: boundary polygons of areas such as local boards and wards
+	`CREATE TABLE IF NOT EXISTS boundary (
+		id SERIAL PRIMARY KEY,
+		kind VARCHAR(32) NOT NULL,
+		name VARCHAR(256) NOT NULL,
+		region VARCHAR(64) NOT NULL DEFAULT 'auckland',
+		geom geometry(MultiPolygon, 4326) NOT NULL,
+		UNIQUE (kind, name)
+	);
+	CREATE INDEX IF NOT EXISTS boundary_geom_idx ON boundary USING GIST (geom);`,
+	// 4: cluster of recurring outages at the same place
+	`ALTER TABLE outage ADD COLUMN IF NOT EXISTS cluster_id INT;
+	CREATE INDEX IF NOT EXISTS outage_region_cluster_id_idx ON outage (region, cluster_id);`,
+	// 5: population and number of dwellings of suburbs
+	`CREATE TABLE IF NOT EXISTS suburb_population (
+		region VARCHAR(64) NOT NULL DEFAULT 'auckland',
+		suburb VARCHAR(256) NOT NULL,
+		population INT,
+		dwellings INT,
+		PRIMARY KEY (region, suburb)
+	);`,
+	// 6: duration models that count the hours of an outage
+	`CREATE OR REPLACE FUNCTION outage_hours_wall_clock(
+		start_date TIMESTAMP, end_date TIMESTAMP
+	) RETURNS FLOAT AS $$
+		SELECT (EXTRACT(EPOCH FROM end_date - start_date) / 3600)::float
+	$$ LANGUAGE SQL IMMUTABLE;
+	CREATE OR REPLACE FUNCTION outage_hours_business(
+		start_date TIMESTAMP, end_date TIMESTAMP, day_start TIME, day_end TIME
+	) RETURNS FLOAT AS $$
+		SELECT CASE WHEN start_date IS NULL OR end_date IS NULL THEN NULL
+		ELSE COALESCE(SUM(EXTRACT(EPOCH FROM
+			LEAST(end_date, day + day_end) - GREATEST(start_date, day + day_start)
+		) / 3600), 0)::float END
+		FROM generate_series(date_trunc('day', start_date), end_date, INTERVAL '1 day') AS day
+		WHERE EXTRACT(ISODOW FROM day) < 6
+		AND LEAST(end_date, day + day_end) > GREATEST(start_date, day + day_start)
+	$$ LANGUAGE SQL IMMUTABLE;
+	CREATE OR REPLACE FUNCTION outage_hours_planned_factor(
+		start_date TIMESTAMP, end_date TIMESTAMP, outage_type TEXT, daily_hours FLOAT
+	) RETURNS FLOAT AS $$
+		SELECT CASE WHEN outage_type = 'Planned' AND daily_hours > 0
+			AND EXTRACT(day FROM end_date - start_date) > 0
+		THEN (EXTRACT(day FROM end_date - start_date) * daily_hours)::float
+		ELSE outage_hours_wall_clock(start_date, end_date) END
+	$$ LANGUAGE SQL IMMUTABLE;`,
+	// 7: snapshots of the dates of outages whenever the upstream API
+	// changes them, such as extended planned outages
+	`CREATE TABLE IF NOT EXISTS outage_snapshot (
+		id SERIAL PRIMARY KEY,
+		region VARCHAR(64) NOT NULL DEFAULT 'auckland',
+		outage_id INT NOT NULL,
+		outage_type VARCHAR(50),
+		start_date TIMESTAMP WITHOUT TIME ZONE,
+		end_date TIMESTAMP WITHOUT TIME ZONE,
+		recorded_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS outage_snapshot_region_outage_id_idx
+	ON outage_snapshot (region, outage_id, id);`,
+	// 8: outages at a place hit by multiple outages within a short time
+	`ALTER TABLE outage ADD COLUMN IF NOT EXISTS recurring BOOLEAN NOT NULL DEFAULT false;`,
+	// 9: alerts of suburbs whose recent outages spike above their baseline
+	`CREATE TABLE IF NOT EXISTS alerts (
+		id SERIAL PRIMARY KEY,
+		region VARCHAR(64) NOT NULL DEFAULT 'auckland',
+		suburb VARCHAR(256) NOT NULL,
+		window_start TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+		window_end TIMESTAMP WITHOUT TIME ZONE NOT NULL,
+		outages INT NOT NULL,
+		baseline_mean FLOAT NOT NULL,
+		baseline_stddev FLOAT NOT NULL,
+		z_score FLOAT NOT NULL,
+		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (region, suburb, window_end)
+	);`,
+	// 10: whether outages are still listed by the upstream API, and the
+	// events of outages that were created, updated or resolved
+	`ALTER TABLE outage ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT false;
+	CREATE TABLE IF NOT EXISTS outage_event (
+		id BIGSERIAL PRIMARY KEY,
+		region VARCHAR(64) NOT NULL DEFAULT 'auckland',
+		outage_id INT NOT NULL,
+		event_type VARCHAR(32) NOT NULL,
+		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`,
+	// 11: webhook subscriptions to outage events, their deliveries and
+	// the events that could not be delivered
+	`CREATE TABLE IF NOT EXISTS subscription (
+		id SERIAL PRIMARY KEY,
+		callback_url TEXT NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		filter TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhook_delivery (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id INT NOT NULL REFERENCES subscription (id) ON DELETE CASCADE,
+		event_id BIGINT NOT NULL,
+		attempt INT NOT NULL,
+		status_code INT,
+		error TEXT,
+		delivered_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhook_dead_letter (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id INT NOT NULL REFERENCES subscription (id) ON DELETE CASCADE,
+		event_id BIGINT NOT NULL,
+		payload TEXT NOT NULL,
+		error TEXT,
+		created_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`,
+	// 12: email digests of the outages matching a filter, sent daily or
+	// weekly
+	`CREATE TABLE IF NOT EXISTS digest_subscription (
+		id SERIAL PRIMARY KEY,
+		email VARCHAR(320) NOT NULL,
+		frequency VARCHAR(16) NOT NULL DEFAULT 'weekly',
+		filter TEXT NOT NULL DEFAULT '',
+		token VARCHAR(64) NOT NULL UNIQUE,
+		last_sent_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);`,
+	// 13: API keys, stored as SHA-256 hashes, with their scopes and
+	// requests per minute (0 is the default limit)
+	`CREATE TABLE IF NOT EXISTS api_key (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL DEFAULT '{public}',
+		rate_limit INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		revoked_at TIMESTAMP WITH TIME ZONE
+	);`,
+	// 14: boundaries of the same kind and name in different regions
+	`ALTER TABLE boundary DROP CONSTRAINT IF EXISTS boundary_kind_name_key;
+	ALTER TABLE boundary ADD CONSTRAINT boundary_region_kind_name_key
+		UNIQUE (region, kind, name);`,
+	// 15: when outages were first ingested, known for outages first
+	// snapshotted after the first snapshots were recorded
+	`ALTER TABLE outage ADD COLUMN IF NOT EXISTS first_ingested_at TIMESTAMP WITHOUT TIME ZONE;
+	ALTER TABLE outage ALTER COLUMN first_ingested_at SET DEFAULT CURRENT_TIMESTAMP;
+	UPDATE outage SET first_ingested_at = first.recorded_at FROM (
+		SELECT region, outage_id, MIN(recorded_at) AS recorded_at
+		FROM outage_snapshot GROUP BY region, outage_id
+	) first WHERE first.region = outage.region AND first.outage_id = outage.outage_id
+	AND first.recorded_at > (SELECT MIN(recorded_at) FROM outage_snapshot) + interval '10 minutes';`,
+	// 16: the queue of webhooks of each subscription with their retry state
+	`CREATE TABLE IF NOT EXISTS webhook_queue (
+		id BIGSERIAL PRIMARY KEY,
+		subscription_id INT NOT NULL REFERENCES subscription (id) ON DELETE CASCADE,
+		event_id BIGINT NOT NULL,
+		event_type VARCHAR(32) NOT NULL,
+		payload TEXT NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_error TEXT,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS webhook_queue_subscription_id_idx
+	ON webhook_queue (subscription_id, id);`,
+	// 17: when the email addresses of digests were confirmed, which digests
+	// created before confirmations were added are taken to be
+	`ALTER TABLE digest_subscription ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMP WITH TIME ZONE;
+	UPDATE digest_subscription SET confirmed_at = created_at WHERE confirmed_at IS NULL;`,
+}
+
+// SchemaVersion is the schema version expected by this build.
+var SchemaVersion = len(migrations)
+
+// CurrentSchemaVersion returns the schema version recorded in the
+// database, giving up when the context is done.
+func CurrentSchemaVersion(ctx context.Context, db *sql.DB) (version int, err error) {
+	err = db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`,
+	).Scan(&version)
+	return
+}
+
+// Migrate applies all migrations that have not been applied to the
+// database yet, in order.
+func Migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMP WITHOUT TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	current, err := CurrentSchemaVersion(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	for version := current + 1; version <= SchemaVersion; version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(migrations[version-1]); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err = tx.Exec(
+			`INSERT INTO schema_migrations (version) VALUES ($1)`, version,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+
+		log.Println("Applied database migration", version)
+	}
+
+	return nil
+}
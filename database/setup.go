@@ -7,10 +7,103 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
+const (
+	// DefaultMaxOpenConns is the largest number of open connections of
+	// the shared pool, unless DB_MAX_OPEN_CONNS is set.
+	DefaultMaxOpenConns = 20
+	// DefaultMaxIdleConns is the largest number of idle connections of
+	// the shared pool, unless DB_MAX_IDLE_CONNS is set.
+	DefaultMaxIdleConns = 5
+	// DefaultConnMaxIdleTime is the time after which idle connections of
+	// the shared pool are closed, unless DB_CONN_MAX_IDLE_TIME is set.
+	DefaultConnMaxIdleTime = 5 * time.Minute
+)
+
+// A PoolSettings struct maps the limits of a pool of database
+// connections.
+type PoolSettings struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+}
+
+var (
+	shared     *sql.DB
+	sharedPool PoolSettings
+	sharedOnce sync.Once
+)
+
+// DB returns the database object shared by this app, set up on first
+// use with the pool settings of the environmental variables. Its pool of
+// connections is reused by all queries, so it is never closed.
+func DB() *sql.DB {
+	sharedOnce.Do(func() {
+		shared = SetupDB()
+		sharedPool = LoadPoolSettings()
+		ConfigurePool(shared, sharedPool)
+	})
+	return shared
+}
+
+// Pool returns the pool settings of the shared database object.
+func Pool() PoolSettings {
+	DB()
+	return sharedPool
+}
+
+// LoadPoolSettings returns the pool settings of the environmental
+// variables DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_IDLE_TIME
+// (such as 5m), or their defaults if unset or invalid.
+func LoadPoolSettings() PoolSettings {
+	pool := PoolSettings{
+		MaxOpenConns:    DefaultMaxOpenConns,
+		MaxIdleConns:    DefaultMaxIdleConns,
+		ConnMaxIdleTime: DefaultConnMaxIdleTime,
+	}
+
+	if value := os.Getenv("DB_MAX_OPEN_CONNS"); value != "" {
+		if number, err := strconv.Atoi(value); err == nil && number > 0 {
+			pool.MaxOpenConns = number
+		} else {
+			log.Println("Invalid DB_MAX_OPEN_CONNS, using", pool.MaxOpenConns)
+		}
+	}
+	if value := os.Getenv("DB_MAX_IDLE_CONNS"); value != "" {
+		if number, err := strconv.Atoi(value); err == nil && number >= 0 {
+			pool.MaxIdleConns = number
+		} else {
+			log.Println("Invalid DB_MAX_IDLE_CONNS, using", pool.MaxIdleConns)
+		}
+	}
+	if value := os.Getenv("DB_CONN_MAX_IDLE_TIME"); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			pool.ConnMaxIdleTime = duration
+		} else {
+			log.Println("Invalid DB_CONN_MAX_IDLE_TIME, using", pool.ConnMaxIdleTime)
+		}
+	}
+
+	// Idle connections above the open limit would be closed anyway
+	if pool.MaxIdleConns > pool.MaxOpenConns {
+		pool.MaxIdleConns = pool.MaxOpenConns
+	}
+	return pool
+}
+
+// ConfigurePool sets the limits of the pool of connections of a database
+// object.
+func ConfigurePool(db *sql.DB, pool PoolSettings) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+}
+
 // SetupDB loads the data from the .env file and sets up the database object.
 func SetupDB() *sql.DB {
 	// Get key .env variables
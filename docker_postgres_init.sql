@@ -1,6 +1,8 @@
 -- Enable PostGIS (as of 3.0 contains just geometry/geography)
 -- CREATE EXTENSION postgis;
 
+-- Start and end dates of outages are saved in the local time of their region.
+-- The database timezone only applies to created_at and updated_at.
 SET TIME ZONE 'Pacific/Auckland';
 SET SESSION TIME ZONE 'NZDT';
 
@@ -29,4 +31,6 @@ CREATE TABLE "outage" (
 
 -- Auto-update
 CREATE TRIGGER set_timestamp BEFORE UPDATE ON outage
-FOR EACH ROW EXECUTE PROCEDURE trigger_set_timestamp();
\ No newline at end of file
+FOR EACH ROW EXECUTE PROCEDURE trigger_set_timestamp();
+
+-- Later schema changes are applied by the app on startup (database/migrate.go)
\ No newline at end of file
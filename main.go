@@ -2,36 +2,129 @@ package main
 
 import (
 	"log"
+	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/axkeyz/water-down-again/api"
+	"github.com/axkeyz/water-down-again/database"
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	log.Println("Server is running")
+	// Check the readiness of the running server, for the Docker health check
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(api.RunHealthCheck("http://localhost:8080/readyz"))
+	}
+
+	// Load address cleanup rules from the config file, if any
+	if path := os.Getenv("ADDRESS_RULES"); path != "" {
+		rules, err := api.LoadAddressRules(path)
+		if err != nil {
+			log.Fatal("Loading address rules failed: ", err)
+		}
+		api.SetAddressRules(rules)
+	}
+
+	// Enable the regions whose outages are retrieved
+	if err := api.SetupRegions(os.Getenv("REGIONS")); err != nil {
+		log.Fatal("Setting up regions failed: ", err)
+	}
+
+	// Apply schema changes made since the database was created
+	if err := database.Migrate(database.DB()); err != nil {
+		log.Fatal("Database migration failed: ", err)
+	}
+
+	// Import local board and ward boundaries from the configured files
+	api.ImportConfiguredBoundaries()
+
+	// Import the population of suburbs from the configured file
+	api.ImportConfiguredPopulation()
+
+	// Record the metrics of each ingest
+	api.OnIngest(api.ObserveIngest)
+
+	// Wake up the live streams of outage events
+	api.OnIngest(api.NotifyOutageEvents)
+
+	// After each ingest, cluster outages into hot-spots, flag recurring
+	// outages & raise alerts of suburbs whose outages spike
+	api.OnIngest(func(api.IngestReport) { api.ClusterOutages() })
+	api.OnIngest(func(api.IngestReport) { api.FlagRecurringOutages() })
+	api.OnIngest(func(api.IngestReport) { api.DetectAnomalies() })
+
+	// Clear the cached responses of the main & count APIs after each ingest
+	cache := api.NewResponseCache(api.DefaultCacheEntries)
+	api.OnIngest(cache.Invalidate)
+
+	// Queue the events of each ingest for webhook subscriptions, which are
+	// sent apart from ingests so failed webhooks don't hold them up
+	api.OnIngest(api.QueueWebhooks)
+	go api.RunWebhooks()
+
+	// Email the daily & weekly digests that are due, apart from ingests
+	go api.RunDigests()
 
 	// Create a cronjob for every hour to retrieve & write from Watercare API to this
-	// app's database
+	// app's database, then run the ingest jobs
 	go func() {
 		for {
-			api.UpdateOutages()
-			<-time.After(1 * time.Hour)
+			api.Ingest()
+			<-time.After(api.IngestInterval)
 		}
 	}()
 
-	// Reformat street and suburb of old outages from previous builds
+	// Re-derive street and suburb of outages saved by older address parsers
 	api.CleanupOutages()
 
 	// Init the mux router
 	router := mux.NewRouter()
 	router.Use(mux.CORSMethodMiddleware(router))
 
+	// Count requests & their latency by route
+	router.Use(api.MetricsMiddleware)
+
+	// Check API keys & rate limit each API key and IP address
+	auth := api.NewAuthenticator()
+	router.Use(auth.Middleware)
+
 	// Setup routes
-	router.HandleFunc("/", api.GetOutages).Methods("GET")
-	router.HandleFunc("/count", api.CountOutages).Methods("GET")
+	router.Handle("/", cache.Middleware(http.HandlerFunc(api.GetOutages))).
+		Methods("GET", "POST", "OPTIONS")
+	router.Handle("/count", cache.Middleware(http.HandlerFunc(api.CountOutages))).
+		Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/count/grid", api.CountGridOutages).Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/near", api.GetNearOutages).Methods("GET")
+	router.HandleFunc("/hotspots", api.GetHotspots).Methods("GET")
+	router.HandleFunc("/timeseries", api.GetTimeSeries).Methods("GET", "POST", "OPTIONS")
+	router.HandleFunc("/reports/disparity", api.GetDisparityReport).Methods("GET")
+	router.HandleFunc("/reports/overruns", api.GetOverrunReport).Methods("GET")
+	router.HandleFunc("/reports/recurring", api.GetRecurringReport).Methods("GET")
+	router.HandleFunc("/alerts", api.GetAlerts).Methods("GET")
+	router.HandleFunc("/subscriptions", api.CreateSubscription).Methods("POST", "OPTIONS")
+	router.HandleFunc("/subscriptions", api.ListSubscriptions).Methods("GET")
+	router.HandleFunc("/subscriptions/{id:[0-9]+}", api.DeleteSubscription).Methods("DELETE")
+	router.HandleFunc("/stream", api.StreamOutages).Methods("GET")
+	router.HandleFunc("/calendar.ics", api.GetCalendar).Methods("GET")
+	router.HandleFunc("/feed.atom", api.GetFeed).Methods("GET")
+	router.HandleFunc("/feed.rss", api.GetFeed).Methods("GET")
+	router.HandleFunc("/outages/{id:[0-9]+}", api.GetOutageByID).Methods("GET")
+	router.HandleFunc("/digests", api.CreateDigest).Methods("POST", "OPTIONS")
+	router.HandleFunc("/digests/confirm", api.ConfirmDigest).Methods("GET")
+	router.HandleFunc("/digests/unsubscribe", api.UnsubscribeDigest).Methods("GET")
+	router.HandleFunc("/keys", api.CreateAPIKey).Methods("POST", "OPTIONS")
+	router.HandleFunc("/keys/{id:[0-9]+}", api.RevokeAPIKey).Methods("DELETE")
+	router.HandleFunc("/metrics", api.GetMetrics).Methods("GET")
+	router.HandleFunc("/healthz", api.GetHealthz).Methods("GET")
+	router.HandleFunc("/readyz", api.GetReadyz).Methods("GET")
 
-	// Run server
-	log.Println(http.ListenAndServe(":8080", router))
+	// Run server, once it is listening
+	listener, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		log.Fatal("Listening failed: ", err)
+	}
+	log.Println("Server is running")
+	log.Println(http.Serve(listener, router))
 }